@@ -0,0 +1,435 @@
+// Package client is a 9P2000 client, for Go programs that talk to llm9p
+// (or any other 9P server) without shelling out to 9p or mounting it.
+//
+// A Conn is one connection to a server; Attach returns a Fid for the root
+// of the served tree, from which other files are reached with Walk:
+//
+//	conn, err := client.Dial(ctx, "tcp", "localhost:5640")
+//	root, err := conn.Attach(ctx, "glenda", "")
+//	data, err := client.ReadFile(ctx, root, "0/model")
+//
+// A server that requires authentication is attached with Auth, an
+// exchange over the auth fid it returns, and AttachAuth.
+//
+// Requests on one Conn may be issued concurrently. Cancelling a request's
+// context flushes it on the server.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// Types and constants shared with the server, so that programs outside
+// this module can use them.
+type (
+	Qid  = protocol.Qid
+	Stat = protocol.Stat
+
+	// Error is an error reported by the server in an Rerror, such as
+	// ErrNotFound.
+	Error = protocol.Error
+)
+
+const (
+	OREAD  = protocol.OREAD
+	OWRITE = protocol.OWRITE
+	ORDWR  = protocol.ORDWR
+	OTRUNC = protocol.OTRUNC
+
+	DMDIR = protocol.DMDIR
+	QTDIR = protocol.QTDIR
+)
+
+// Errors a server may report. An llm9p server reports these exact
+// strings; other servers may word theirs differently.
+const (
+	ErrNotFound   = protocol.ErrNotFound
+	ErrPermission = protocol.ErrPermission
+	ErrExists     = protocol.ErrExists
+)
+
+// NullStat returns a stat whose every field means "don't touch", for
+// building a Wstat that changes only some fields.
+func NullStat() Stat { return protocol.NullStat() }
+
+// DefaultMsize is the message size a Conn asks for. The server may agree
+// to less.
+const DefaultMsize = 64 << 10
+
+// ioHeader is the space taken by the Twrite header in a message.
+const ioHeader = 4 + 1 + 2 + 4 + 8 + 4
+
+// ErrClosed is returned for requests on a closed Conn.
+var ErrClosed = errors.New("client: connection closed")
+
+// Conn is a connection to a 9P server.
+type Conn struct {
+	rwc   io.ReadWriteCloser
+	enc   *protocol.Encoder
+	dec   *protocol.Decoder
+	msize uint32
+
+	wmu  sync.Mutex // serializes writes to enc
+	bufs sync.Pool  // msize buffers for encoding requests
+
+	mu       sync.Mutex
+	tags     map[uint16]chan reply // requests awaiting a reply
+	nextTag  uint16
+	freeFids []uint32
+	nextFid  uint32
+	err      error // set once the connection has failed
+
+	done chan struct{} // closed when the reader exits
+}
+
+// reply is an R-message delivered to the request that sent its tag.
+type reply struct {
+	msgType uint8
+	payload []byte
+	err     error
+}
+
+// Dial connects to the 9P server at address on the named network and
+// negotiates the protocol version.
+func Dial(ctx context.Context, network, address string) (*Conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewConn(ctx, nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewConn negotiates the protocol version over rwc, which is typically a
+// net.Conn, and returns a Conn that uses it. Closing the Conn closes rwc.
+func NewConn(ctx context.Context, rwc io.ReadWriteCloser) (*Conn, error) {
+	c := &Conn{
+		rwc:  rwc,
+		enc:  protocol.NewEncoder(rwc),
+		dec:  protocol.NewDecoder(rwc),
+		tags: make(map[uint16]chan reply),
+		done: make(chan struct{}),
+	}
+
+	// Tversion is the only message in flight, so it is exchanged before
+	// the reader starts.
+	if err := c.version(ctx, DefaultMsize); err != nil {
+		return nil, err
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *Conn) version(ctx context.Context, msize uint32) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if nc, ok := c.rwc.(net.Conn); ok {
+			nc.SetDeadline(deadline)
+			defer nc.SetDeadline(time.Time{})
+		}
+	}
+
+	buf := make([]byte, protocol.MinMessageSize)
+	msg := &protocol.TversionMsg{Msize: msize, Version: protocol.Version}
+	n := msg.Encode(buf)
+	if err := c.enc.WriteMessage(protocol.Tversion, protocol.NoTag, buf[:n]); err != nil {
+		return err
+	}
+
+	msgType, _, payload, err := c.dec.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if msgType == protocol.Rerror {
+		return rerror(payload)
+	}
+	if msgType != protocol.Rversion {
+		return fmt.Errorf("client: unexpected %s to Tversion", protocol.MessageName(msgType))
+	}
+	r, err := protocol.DecodeRversion(payload)
+	if err != nil {
+		return err
+	}
+	if r.Version != protocol.Version {
+		return fmt.Errorf("client: server speaks %q, not %q", r.Version, protocol.Version)
+	}
+	if r.Msize < protocol.MinMessageSize || r.Msize > msize {
+		return fmt.Errorf("client: server chose bad msize %d", r.Msize)
+	}
+
+	c.msize = r.Msize
+	c.bufs.New = func() any {
+		b := make([]byte, r.Msize)
+		return &b
+	}
+	c.enc.SetMsize(r.Msize)
+	c.dec.SetMsize(r.Msize)
+	return nil
+}
+
+// Msize returns the negotiated maximum message size.
+func (c *Conn) Msize() uint32 {
+	return c.msize
+}
+
+// Close closes the connection. Requests still in flight fail with
+// ErrClosed.
+func (c *Conn) Close() error {
+	err := c.rwc.Close()
+	<-c.done
+	return err
+}
+
+// read delivers replies to the requests waiting for them, until the
+// connection fails.
+func (c *Conn) read() {
+	defer close(c.done)
+	defer c.dec.Release()
+
+	for {
+		msgType, tag, payload, err := c.dec.ReadMessage()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		ch, ok := c.tags[tag]
+		c.mu.Unlock()
+		if !ok {
+			continue // a reply to a request we have given up on
+		}
+		select {
+		case ch <- reply{msgType: msgType, payload: append([]byte(nil), payload...)}:
+		default: // a second reply on one tag; the server is confused
+		}
+	}
+}
+
+// fail records err and wakes every request still waiting for a reply.
+func (c *Conn) fail(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) {
+		err = ErrClosed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	for _, ch := range c.tags {
+		select {
+		case ch <- reply{err: err}:
+		default: // already holds its reply
+		}
+	}
+}
+
+// newTag allocates a tag and the channel its reply will be delivered on.
+func (c *Conn) newTag() (uint16, chan reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	for i := 0; i < int(protocol.NoTag); i++ {
+		tag := c.nextTag
+		c.nextTag++
+		if c.nextTag == protocol.NoTag {
+			c.nextTag = 0
+		}
+		if _, busy := c.tags[tag]; !busy {
+			ch := make(chan reply, 1)
+			c.tags[tag] = ch
+			return tag, ch, nil
+		}
+	}
+	return 0, nil, errors.New("client: too many requests in flight")
+}
+
+func (c *Conn) freeTag(tag uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tags, tag)
+}
+
+func (c *Conn) newFid() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.freeFids); n > 0 {
+		fid := c.freeFids[n-1]
+		c.freeFids = c.freeFids[:n-1]
+		return fid
+	}
+	fid := c.nextFid
+	c.nextFid++
+	return fid
+}
+
+func (c *Conn) freeFid(fid uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.freeFids = append(c.freeFids, fid)
+}
+
+// send writes msg with the given tag.
+func (c *Conn) send(tag uint16, msg protocol.Message) error {
+	b := c.bufs.Get().(*[]byte)
+	defer c.bufs.Put(b)
+	n := msg.Encode(*b)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.enc.WriteMessage(msg.Type(), tag, (*b)[:n])
+}
+
+// rpc sends msg and waits for its reply, which must be of type want. If
+// ctx is cancelled first, the request is flushed.
+func (c *Conn) rpc(ctx context.Context, msg protocol.Message, want uint8) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.roundTrip(ctx, msg, want)
+}
+
+// roundTrip is rpc without the check that ctx is still live: msg is sent
+// even if ctx is already done, and then flushed at once. Tclunk and
+// Tremove use it, since they release the fid on the server even when
+// flushed, and otherwise the fid would never be released.
+func (c *Conn) roundTrip(ctx context.Context, msg protocol.Message, want uint8) ([]byte, error) {
+	tag, ch, err := c.newTag()
+	if err != nil {
+		return nil, err
+	}
+	defer c.freeTag(tag)
+
+	if err := c.send(tag, msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return check(r, want)
+	case <-ctx.Done():
+	}
+
+	// The server replies to the request before the Rflush if it had
+	// already finished. A successful reply means the request took effect;
+	// an error, likely caused by the cancellation, means it did not.
+	if err := c.flush(tag); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		if r.err == nil && r.msgType == want {
+			return r.payload, nil
+		}
+	default:
+	}
+	return nil, ctx.Err()
+}
+
+// flush cancels the request with tag oldtag and waits for the server to
+// acknowledge it.
+func (c *Conn) flush(oldtag uint16) error {
+	tag, ch, err := c.newTag()
+	if err != nil {
+		return err
+	}
+	defer c.freeTag(tag)
+
+	if err := c.send(tag, &protocol.TflushMsg{Oldtag: oldtag}); err != nil {
+		return err
+	}
+	_, err = check(<-ch, protocol.Rflush)
+	return err
+}
+
+// check returns the payload of r, or the error it reports.
+func check(r reply, want uint8) ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.msgType == protocol.Rerror {
+		return nil, rerror(r.payload)
+	}
+	if r.msgType != want {
+		return nil, fmt.Errorf("client: got %s, want %s", protocol.MessageName(r.msgType), protocol.MessageName(want))
+	}
+	return r.payload, nil
+}
+
+func rerror(payload []byte) error {
+	r, err := protocol.DecodeRerror(payload)
+	if err != nil {
+		return err
+	}
+	return Error(r.Ename)
+}
+
+// Attach authenticates as uname, without an auth fid, and returns a Fid
+// for the root of the tree called aname.
+func (c *Conn) Attach(ctx context.Context, uname, aname string) (*Fid, error) {
+	return c.attach(ctx, protocol.NoFid, uname, aname)
+}
+
+// Auth begins authenticating as uname to attach aname and returns the
+// auth fid. The client reads and writes it, without opening it, to carry
+// out the exchange the server asks for, then passes it to AttachAuth and
+// clunks it. Servers that need no authentication refuse Tauth.
+func (c *Conn) Auth(ctx context.Context, uname, aname string) (*Fid, error) {
+	f := &Fid{c: c, fid: c.newFid()}
+	payload, err := c.rpc(ctx, &protocol.TauthMsg{
+		Afid:  f.fid,
+		Uname: uname,
+		Aname: aname,
+	}, protocol.Rauth)
+	if err != nil {
+		c.release(f.fid, err)
+		return nil, err
+	}
+	r, err := protocol.DecodeRauth(payload)
+	if err != nil {
+		c.release(f.fid, err)
+		return nil, err
+	}
+	f.qid = r.Aqid
+	return f, nil
+}
+
+// AttachAuth is Attach for a server that requires authentication, with
+// an auth fid from Auth on which the exchange has been completed.
+func (c *Conn) AttachAuth(ctx context.Context, afid *Fid, uname, aname string) (*Fid, error) {
+	return c.attach(ctx, afid.fid, uname, aname)
+}
+
+func (c *Conn) attach(ctx context.Context, afid uint32, uname, aname string) (*Fid, error) {
+	f := &Fid{c: c, fid: c.newFid()}
+	payload, err := c.rpc(ctx, &protocol.TattachMsg{
+		Fid:   f.fid,
+		Afid:  afid,
+		Uname: uname,
+		Aname: aname,
+	}, protocol.Rattach)
+	if err != nil {
+		c.release(f.fid, err)
+		return nil, err
+	}
+	r, err := protocol.DecodeRattach(payload)
+	if err != nil {
+		c.release(f.fid, err)
+		return nil, err
+	}
+	f.qid = r.Qid
+	return f, nil
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// bufFile is a writable in-memory file.
+type bufFile struct {
+	*protocol.BaseFile
+	mu   sync.Mutex
+	data []byte
+}
+
+func newBufFile(name string) *bufFile {
+	return &bufFile{BaseFile: protocol.NewBaseFile(name, 0666)}
+}
+
+func (f *bufFile) Read(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, f.data[offset:]), nil
+}
+
+func (f *bufFile) Write(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = append(f.data[:min(int(offset), len(f.data))], p...)
+	return len(p), nil
+}
+
+func (f *bufFile) Truncate(length uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = f.data[:min(int(length), len(f.data))]
+	return nil
+}
+
+// slowFile blocks reads until the request is flushed.
+type slowFile struct {
+	*protocol.BaseFile
+}
+
+func (f *slowFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+// serve returns a Conn to a server for root over net.Pipe.
+func serve(t *testing.T, root protocol.Dir) *Conn {
+	t.Helper()
+	return serveWith(t, protocol.NewServer(root))
+}
+
+// serveWith returns a Conn to srv over net.Pipe.
+func serveWith(t *testing.T, srv *protocol.Server) *Conn {
+	t.Helper()
+	cc, sc := net.Pipe()
+	go srv.ServeConn(sc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := NewConn(ctx, cc)
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testTree() (*protocol.StaticDir, *bufFile) {
+	root := protocol.NewStaticDir("")
+	notes := newBufFile("notes")
+	sub := protocol.NewStaticDir("sub")
+	sub.AddChild(protocol.NewStaticFile("greeting", []byte("hello\n")))
+	root.AddChild(sub)
+	root.AddChild(notes)
+	root.AddChild(&slowFile{protocol.NewBaseFile("slow", 0444)})
+	return root, notes
+}
+
+func TestReadWriteFile(t *testing.T) {
+	ctx := context.Background()
+	tree, notes := testTree()
+	c := serve(t, tree)
+	root, err := c.Attach(ctx, "glenda", "")
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if root.Qid().Type&QTDIR == 0 {
+		t.Errorf("root qid type = %#x, want a directory", root.Qid().Type)
+	}
+
+	data, err := ReadFile(ctx, root, "sub/greeting")
+	if err != nil || string(data) != "hello\n" {
+		t.Errorf("ReadFile(sub/greeting) = %q, %v; want hello", data, err)
+	}
+
+	// Larger than one message, so it takes several Twrites and Treads.
+	big := bytes.Repeat([]byte("0123456789abcdef"), int(c.Msize()))
+	if err := WriteFile(ctx, root, "notes", big); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if !bytes.Equal(notes.data, big) {
+		t.Errorf("file holds %d bytes, want %d", len(notes.data), len(big))
+	}
+	data, err = ReadFile(ctx, root, "notes")
+	if err != nil || !bytes.Equal(data, big) {
+		t.Errorf("ReadFile(notes) = %d bytes, %v; want %d", len(data), err, len(big))
+	}
+
+	// WriteFile truncates what was there.
+	if err := WriteFile(ctx, root, "notes", []byte("short")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if string(notes.data) != "short" {
+		t.Errorf("file holds %q, want short", notes.data)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	tree, _ := testTree()
+	c := serve(t, tree)
+	root, err := c.Attach(ctx, "glenda", "")
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	if _, err := ReadFile(ctx, root, "missing"); err != ErrNotFound {
+		t.Errorf("ReadFile(missing) error = %v, want %v", err, ErrNotFound)
+	}
+	// A walk that fails part way is also not found.
+	if _, err := root.WalkPath(ctx, "sub/missing"); err != ErrNotFound {
+		t.Errorf("Walk(sub/missing) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := WriteFile(ctx, root, "sub/greeting", []byte("x")); err != ErrPermission {
+		t.Errorf("WriteFile(sub/greeting) error = %v, want %v", err, ErrPermission)
+	}
+	err = root.Create(ctx, "new", 0644, OWRITE)
+	if err != ErrPermission {
+		t.Errorf("Create error = %v, want %v", err, ErrPermission)
+	}
+	var perr Error
+	if !errors.As(err, &perr) {
+		t.Errorf("error %T is not an Error", err)
+	}
+}
+
+func TestStatAndReadDir(t *testing.T) {
+	ctx := context.Background()
+	tree, _ := testTree()
+	c := serve(t, tree)
+	root, _ := c.Attach(ctx, "glenda", "")
+
+	f, err := root.Walk(ctx, "sub", "greeting")
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	st, err := f.Stat(ctx)
+	if err != nil || st.Name != "greeting" || st.Length != 6 {
+		t.Errorf("Stat = %+v, %v; want greeting of length 6", st, err)
+	}
+	if err := f.Clunk(ctx); err != nil {
+		t.Errorf("Clunk: %v", err)
+	}
+
+	d, _ := root.Walk(ctx)
+	if err := d.Open(ctx, OREAD); err != nil {
+		t.Fatalf("Open root: %v", err)
+	}
+	stats, err := d.ReadDir(ctx)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, st := range stats {
+		names = append(names, st.Name)
+	}
+	if fmt.Sprint(names) != "[sub notes slow]" {
+		t.Errorf("ReadDir names = %v, want [sub notes slow]", names)
+	}
+	d.Clunk(ctx)
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	ctx := context.Background()
+	tree, _ := testTree()
+	c := serve(t, tree)
+	root, _ := c.Attach(ctx, "glenda", "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := ReadFile(ctx, root, "sub/greeting")
+			if err != nil || string(data) != "hello\n" {
+				t.Errorf("ReadFile = %q, %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCancelFlushes(t *testing.T) {
+	tree, _ := testTree()
+	c := serve(t, tree)
+	root, _ := c.Attach(context.Background(), "glenda", "")
+
+	f, err := root.Walk(context.Background(), "slow")
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if err := f.Open(context.Background(), OREAD); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := f.ReadAt(ctx, make([]byte, 10), 0); err != context.DeadlineExceeded {
+		t.Errorf("ReadAt error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The connection is still usable.
+	if _, err := ReadFile(context.Background(), root, "sub/greeting"); err != nil {
+		t.Errorf("ReadFile after flush: %v", err)
+	}
+}
+
+func TestCancelReleasesFids(t *testing.T) {
+	tree, _ := testTree()
+	srv := protocol.NewServer(tree)
+	srv.SetMaxFids(2) // the root and one more
+	c := serveWith(t, srv)
+	root, err := c.Attach(context.Background(), "glenda", "")
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	// ReadFile still clunks the fid when its context times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ReadFile(ctx, root, "slow"); err != context.DeadlineExceeded {
+		t.Errorf("ReadFile(slow) error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := ReadFile(context.Background(), root, "sub/greeting"); err != nil {
+		t.Fatalf("ReadFile after timeout: %v", err)
+	}
+
+	// Clunk with a context that is already done still releases the fid.
+	f, err := root.Walk(context.Background(), "notes")
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	done, cancelDone := context.WithCancel(context.Background())
+	cancelDone()
+	f.Clunk(done)
+	if _, err := ReadFile(context.Background(), root, "sub/greeting"); err != nil {
+		t.Errorf("ReadFile after cancelled Clunk: %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	tree, _ := testTree()
+	srv := protocol.NewServer(tree)
+	srv.SetAuthenticator(protocol.NewPasswordAuth(map[string]string{"glenda": "bunny"}))
+	c := serveWith(t, srv)
+
+	if _, err := c.Attach(ctx, "glenda", ""); err == nil {
+		t.Error("Attach without authenticating succeeded")
+	}
+
+	// A wrong password fails the exchange, and the afid is no good.
+	afid, err := c.Auth(ctx, "glenda", "")
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if _, err := afid.WriteAt(ctx, []byte("carrot"), 0); err == nil {
+		t.Error("wrong password accepted")
+	}
+	if _, err := c.AttachAuth(ctx, afid, "glenda", ""); err == nil {
+		t.Error("AttachAuth after a failed exchange succeeded")
+	}
+	afid.Clunk(ctx)
+
+	afid, err = c.Auth(ctx, "glenda", "")
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	defer afid.Clunk(ctx)
+	if _, err := afid.WriteAt(ctx, []byte("bunny"), 0); err != nil {
+		t.Fatalf("writing the password: %v", err)
+	}
+	if _, err := c.AttachAuth(ctx, afid, "rob", ""); err == nil {
+		t.Error("AttachAuth as another user succeeded")
+	}
+	root, err := c.AttachAuth(ctx, afid, "glenda", "")
+	if err != nil {
+		t.Fatalf("AttachAuth: %v", err)
+	}
+	if data, err := ReadFile(ctx, root, "sub/greeting"); err != nil || string(data) != "hello\n" {
+		t.Errorf("ReadFile(sub/greeting) = %q, %v; want hello", data, err)
+	}
+
+	// A server that needs no authentication refuses Tauth.
+	if _, err := serve(t, tree).Auth(ctx, "glenda", ""); err == nil {
+		t.Error("Auth succeeded on a server without an authenticator")
+	}
+}
+
+func TestClosed(t *testing.T) {
+	tree, _ := testTree()
+	c := serve(t, tree)
+	root, _ := c.Attach(context.Background(), "glenda", "")
+	c.Close()
+
+	if _, err := root.Walk(context.Background(), "notes"); err != ErrClosed {
+		t.Errorf("Walk after Close error = %v, want %v", err, ErrClosed)
+	}
+}
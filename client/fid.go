@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// maxWalk is the most names a single Twalk may carry.
+const maxWalk = 16
+
+// Fid is a reference to a file on the server. A Fid is obtained from
+// Attach or Walk and must be released with Clunk or Remove.
+type Fid struct {
+	c      *Conn
+	fid    uint32
+	qid    Qid
+	iounit uint32
+}
+
+// Qid returns the server's identifier for the file.
+func (f *Fid) Qid() Qid {
+	return f.qid
+}
+
+// Walk returns a new Fid for the file reached by walking names from f,
+// which is left unchanged. With no names the new Fid refers to the same
+// file as f.
+func (f *Fid) Walk(ctx context.Context, names ...string) (*Fid, error) {
+	nf := &Fid{c: f.c, fid: f.c.newFid(), qid: f.qid}
+
+	// The first walk clones f; any further ones move the clone along.
+	from := f.fid
+	for first := true; first || len(names) > 0; first = false {
+		step := names[:min(len(names), maxWalk)]
+		names = names[len(step):]
+
+		payload, err := f.c.rpc(ctx, &protocol.TwalkMsg{Fid: from, Newfid: nf.fid, Names: step}, protocol.Rwalk)
+		if err == nil {
+			var r *protocol.RwalkMsg
+			if r, err = protocol.DecodeRwalk(payload); err == nil {
+				if len(r.Qids) < len(step) {
+					// A partial walk leaves newfid unused.
+					err = ErrNotFound
+				} else if len(r.Qids) > 0 {
+					nf.qid = r.Qids[len(r.Qids)-1]
+				}
+			}
+		}
+		if err != nil {
+			if first {
+				f.c.release(nf.fid, err)
+			} else {
+				nf.Clunk(context.WithoutCancel(ctx))
+			}
+			return nil, err
+		}
+		from = nf.fid
+	}
+	return nf, nil
+}
+
+// WalkPath is Walk for a slash-separated path.
+func (f *Fid) WalkPath(ctx context.Context, path string) (*Fid, error) {
+	return f.Walk(ctx, splitPath(path)...)
+}
+
+func splitPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" && name != "." {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Open opens the file for I/O with a mode such as OREAD or OWRITE|OTRUNC.
+func (f *Fid) Open(ctx context.Context, mode uint8) error {
+	payload, err := f.c.rpc(ctx, &protocol.TopenMsg{Fid: f.fid, Mode: mode}, protocol.Ropen)
+	if err != nil {
+		return err
+	}
+	r, err := protocol.DecodeRopen(payload)
+	if err != nil {
+		return err
+	}
+	f.qid = r.Qid
+	f.iounit = r.Iounit
+	return nil
+}
+
+// Create creates name in the directory f and opens it with mode. On
+// success f refers to the new file. Set DMDIR in perm to make a directory.
+func (f *Fid) Create(ctx context.Context, name string, perm uint32, mode uint8) error {
+	payload, err := f.c.rpc(ctx, &protocol.TcreateMsg{Fid: f.fid, Name: name, Perm: perm, Mode: mode}, protocol.Rcreate)
+	if err != nil {
+		return err
+	}
+	r, err := protocol.DecodeRcreate(payload)
+	if err != nil {
+		return err
+	}
+	f.qid = r.Qid
+	f.iounit = r.Iounit
+	return nil
+}
+
+// ioUnit returns the most data one Tread or Twrite may carry.
+func (f *Fid) ioUnit() int {
+	n := int(f.c.msize) - ioHeader
+	if f.iounit > 0 && int(f.iounit) < n {
+		n = int(f.iounit)
+	}
+	return n
+}
+
+// ReadAt reads from the open file at offset off until p is full or the
+// server returns no more data, in which case the error is io.EOF.
+func (f *Fid) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		count := min(len(p)-n, f.ioUnit())
+		payload, err := f.c.rpc(ctx, &protocol.TreadMsg{Fid: f.fid, Offset: uint64(off) + uint64(n), Count: uint32(count)}, protocol.Rread)
+		if err != nil {
+			return n, err
+		}
+		r, err := protocol.DecodeRread(payload)
+		if err != nil {
+			return n, err
+		}
+		if len(r.Data) == 0 {
+			return n, io.EOF
+		}
+		n += copy(p[n:], r.Data)
+	}
+	return n, nil
+}
+
+// ReadAll reads the open file from offset 0 until the server returns no
+// more data.
+func (f *Fid) ReadAll(ctx context.Context) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, f.ioUnit())
+	for {
+		n, err := f.ReadAt(ctx, buf, int64(len(data)))
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+}
+
+// WriteAt writes p to the open file at offset off, in as many Twrites as
+// the message size requires.
+func (f *Fid) WriteAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var n int
+	for first := true; first || n < len(p); first = false {
+		chunk := p[n:min(len(p), n+f.ioUnit())]
+		payload, err := f.c.rpc(ctx, &protocol.TwriteMsg{Fid: f.fid, Offset: uint64(off) + uint64(n), Data: chunk}, protocol.Rwrite)
+		if err != nil {
+			return n, err
+		}
+		r, err := protocol.DecodeRwrite(payload)
+		if err != nil {
+			return n, err
+		}
+		n += int(r.Count)
+		if int(r.Count) < len(chunk) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// ReadDir reads the entries of the open directory.
+func (f *Fid) ReadDir(ctx context.Context) ([]Stat, error) {
+	data, err := f.ReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var stats []Stat
+	for len(data) > 0 {
+		stat, n := protocol.DecodeStat(data)
+		if n == 0 {
+			return stats, errors.New("client: bad directory entry")
+		}
+		stats = append(stats, stat)
+		data = data[n:]
+	}
+	return stats, nil
+}
+
+// Stat returns the file's metadata.
+func (f *Fid) Stat(ctx context.Context) (Stat, error) {
+	payload, err := f.c.rpc(ctx, &protocol.TstatMsg{Fid: f.fid}, protocol.Rstat)
+	if err != nil {
+		return Stat{}, err
+	}
+	r, err := protocol.DecodeRstat(payload)
+	if err != nil {
+		return Stat{}, err
+	}
+	return r.Stat, nil
+}
+
+// Wstat changes the file's metadata. Start from NullStat and set only the
+// fields to change.
+func (f *Fid) Wstat(ctx context.Context, st Stat) error {
+	_, err := f.c.rpc(ctx, &protocol.TwstatMsg{Fid: f.fid, Stat: st}, protocol.Rwstat)
+	return err
+}
+
+// Clunk releases the Fid. For some files, such as llm9p's ask, this is
+// when buffered writes take effect, and the error reports how that went.
+// Cancelling ctx abandons that work, but the Fid is still released on the
+// server, even if ctx is already done.
+func (f *Fid) Clunk(ctx context.Context) error {
+	_, err := f.c.roundTrip(ctx, &protocol.TclunkMsg{Fid: f.fid}, protocol.Rclunk)
+	f.c.release(f.fid, err)
+	return err
+}
+
+// Remove removes the file and releases the Fid, whether or not the
+// removal succeeds.
+func (f *Fid) Remove(ctx context.Context) error {
+	_, err := f.c.roundTrip(ctx, &protocol.TremoveMsg{Fid: f.fid}, protocol.Rremove)
+	f.c.release(f.fid, err)
+	return err
+}
+
+// release makes fid available for reuse after a request that ended with
+// err released it on the server. If the request was flushed, the server
+// may still hold the fid, so it is never reused.
+func (c *Conn) release(fid uint32, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	c.freeFid(fid)
+}
+
+// ReadFile reads the whole of the file at path, relative to dir.
+func ReadFile(ctx context.Context, dir *Fid, path string) ([]byte, error) {
+	f, err := dir.WalkPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	// Clunk even if ctx has expired, so the fid is not left on the server.
+	defer f.Clunk(context.WithoutCancel(ctx))
+
+	if err := f.Open(ctx, OREAD); err != nil {
+		return nil, err
+	}
+	return f.ReadAll(ctx)
+}
+
+// WriteFile replaces the contents of the file at path, relative to dir,
+// with data. The error includes any reported when the file is closed.
+func WriteFile(ctx context.Context, dir *Fid, path string, data []byte) error {
+	f, err := dir.WalkPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := f.Open(ctx, OWRITE|OTRUNC); err != nil {
+		f.Clunk(context.WithoutCancel(ctx))
+		return err
+	}
+	if _, err := f.WriteAt(ctx, data, 0); err != nil {
+		f.Clunk(context.WithoutCancel(ctx))
+		return err
+	}
+	// This clunk commits the write, so it keeps ctx: cancelling abandons
+	// the commit, and the fid is released either way.
+	return f.Clunk(ctx)
+}
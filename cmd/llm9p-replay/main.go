@@ -0,0 +1,76 @@
+// llm9p-replay replays a protocol trace recorded by llm9p -trace against
+// a fresh server with a mock backend, and reports the replies that differ
+// from the recorded ones.
+//
+// Usage:
+//
+//	llm9p -trace /tmp/llm9p.trace ...   # reproduce the problem
+//	llm9p-replay /tmp/llm9p.trace
+//
+// The mock backend echoes prompts, so replies that carry LLM output will
+// differ from a recording made against a real backend. Qid paths and
+// versions and file times are ignored unless -strict is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/llmfs"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func main() {
+	strict := flag.Bool("strict", false, "Also compare qid paths and versions and file times")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long to wait for each reply")
+	msize := flag.Uint("msize", protocol.MaxMessageSize, "Largest message size to negotiate, as llm9p -msize")
+	perUser := flag.Bool("per-user", false, "Give each user a root showing only their own sessions, as llm9p -per-user")
+	admins := flag.String("admin", "", "Comma-separated users who may attach the admin tree, as llm9p -admin")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: llm9p-replay [flags] trace\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	events, err := readTrace(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	sm := llm.NewSessionManager(llm.NewMockClient())
+	ns := llmfs.NewNamespace(sm)
+	ns.SetPerUser(*perUser)
+	if *admins != "" {
+		ns.SetAdmins(strings.Split(*admins, ",")...)
+	}
+	server := protocol.NewServer(llmfs.NewRoot(sm))
+	server.SetNamespace(ns)
+	server.SetMaxMsize(uint32(min(*msize, protocol.MaxMsizeLimit)))
+
+	r := &Replayer{Server: server, Timeout: *timeout, Strict: *strict}
+	diffs := r.Replay(events)
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Conn < diffs[j].Conn })
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d replies differ\n", len(diffs))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// readTrace reads the JSON lines written by Server.SetTrace.
+func readTrace(r io.Reader) ([]protocol.TraceEvent, error) {
+	var events []protocol.TraceEvent
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 2*protocol.MaxMsizeLimit)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var ev protocol.TraceEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+	return events, sc.Err()
+}
+
+// messageTypes maps message names back to their types.
+var messageTypes = func() map[string]uint8 {
+	m := make(map[string]uint8)
+	for t := 0; t < 256; t++ {
+		m[protocol.MessageName(uint8(t))] = uint8(t)
+	}
+	return m
+}()
+
+// reply is an R-message received during replay.
+type reply struct {
+	typ     uint8
+	tag     uint16
+	payload []byte
+}
+
+// replayConn is the client side of one replayed connection.
+type replayConn struct {
+	conn    net.Conn
+	enc     *protocol.Encoder
+	version string // negotiated in the recorded trace
+
+	// Recorded requests awaiting their replies, by tag, and whether each
+	// fid the recording opened is a directory, so directory reads can be
+	// told from file reads.
+	requests map[uint16]protocol.Message
+	dirs     map[uint32]bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	replies []reply
+	err     error // set when the server stops answering
+}
+
+func (c *replayConn) readLoop() {
+	dec := protocol.NewDecoder(c.conn)
+	dec.SetMsize(protocol.MaxMsizeLimit)
+	defer dec.Release()
+	for {
+		typ, tag, payload, err := dec.ReadMessage()
+		c.mu.Lock()
+		if err != nil {
+			c.err = err
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			return
+		}
+		c.replies = append(c.replies, reply{typ, tag, append([]byte(nil), payload...)})
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+// wait blocks until n replies have arrived, the connection fails or the
+// timeout passes. It reports whether n replies arrived.
+func (c *replayConn) wait(n int, timeout time.Duration) bool {
+	timer := time.AfterFunc(timeout, func() {
+		c.mu.Lock()
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.replies) < n && c.err == nil && time.Now().Before(deadline) {
+		c.cond.Wait()
+	}
+	return len(c.replies) >= n
+}
+
+// recordedReply is an R-message in the trace.
+type recordedReply struct {
+	protocol.TraceEvent
+	dir bool // an Rread of a directory
+}
+
+// record notes a recorded reply and returns it.
+func (c *replayConn) record(ev protocol.TraceEvent) recordedReply {
+	rec := recordedReply{TraceEvent: ev}
+	req := c.requests[ev.Tag]
+	delete(c.requests, ev.Tag)
+	switch m := req.(type) {
+	case *protocol.TopenMsg:
+		if r, err := protocol.DecodeRopen(ev.Raw); err == nil && ev.Type == "Ropen" {
+			c.dirs[m.Fid] = r.Qid.Type&protocol.QTDIR != 0
+		}
+	case *protocol.TcreateMsg:
+		if r, err := protocol.DecodeRcreate(ev.Raw); err == nil && ev.Type == "Rcreate" {
+			c.dirs[m.Fid] = r.Qid.Type&protocol.QTDIR != 0
+		}
+	case *protocol.TreadMsg:
+		rec.dir = ev.Type == "Rread" && c.dirs[m.Fid]
+	}
+	if ev.Type == "Rversion" {
+		if msg, err := protocol.DecodeRversion(ev.Raw); err == nil {
+			c.version = msg.Version
+		}
+	}
+	return rec
+}
+
+// Diff is a recorded reply that replay did not reproduce.
+type Diff struct {
+	Conn     uint64
+	Tag      uint16
+	Recorded string // the recorded reply, or "" if there was none
+	Replayed string // the replayed reply, or "" if there was none
+}
+
+func (d Diff) String() string {
+	return fmt.Sprintf("conn %d tag %d:\n- %s\n+ %s", d.Conn, d.Tag, orNone(d.Recorded), orNone(d.Replayed))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(no reply)"
+	}
+	return s
+}
+
+// Replayer feeds a trace to a server and compares the replies.
+type Replayer struct {
+	Server  *protocol.Server
+	Timeout time.Duration // how long to wait for a reply the trace says came
+	Strict  bool          // compare qid paths and versions and times too
+}
+
+// Replay sends the T-messages of events to r.Server, one connection per
+// recorded connection, and returns the replies that differ from the
+// recorded ones.
+//
+// A T-message is sent only once every reply recorded before it has come
+// back, on any connection, so requests that depended on each other in
+// the recording are replayed in the same order.
+func (r *Replayer) Replay(events []protocol.TraceEvent) []Diff {
+	conns := make(map[uint64]*replayConn)
+	recorded := make(map[uint64][]recordedReply) // R-messages per connection
+	expect := make(map[uint64]int)               // replies recorded so far per connection
+
+	// waitAll waits for the replies recorded so far on every connection.
+	waitAll := func() {
+		for id, c := range conns {
+			c.wait(expect[id], r.Timeout)
+		}
+	}
+
+	connect := func(id uint64) *replayConn {
+		client, server := net.Pipe()
+		c := &replayConn{
+			conn:     client,
+			enc:      protocol.NewEncoder(client),
+			version:  protocol.Version,
+			requests: make(map[uint16]protocol.Message),
+			dirs:     make(map[uint32]bool),
+		}
+		c.enc.SetMsize(protocol.MaxMsizeLimit)
+		c.cond = sync.NewCond(&c.mu)
+		conns[id] = c
+		go r.Server.ServeConn(server)
+		go c.readLoop()
+		return c
+	}
+
+	for _, ev := range events {
+		c := conns[ev.Conn]
+		switch {
+		case ev.Event == "connect":
+			connect(ev.Conn)
+		case ev.Event == "disconnect":
+			if c != nil {
+				waitAll()
+				c.conn.Close()
+			}
+		case ev.Dir == "out":
+			rec := recordedReply{TraceEvent: ev}
+			if c != nil {
+				rec = c.record(ev)
+			}
+			recorded[ev.Conn] = append(recorded[ev.Conn], rec)
+			expect[ev.Conn]++
+		case ev.Dir == "in":
+			if c == nil {
+				// The trace started after this connection did.
+				c = connect(ev.Conn)
+			}
+			typ, ok := messageTypes[ev.Type]
+			if !ok {
+				continue
+			}
+			if msg, err := protocol.DecodeMessage(c.version, typ, ev.Raw); err == nil {
+				c.requests[ev.Tag] = msg
+			}
+			waitAll()
+			c.enc.WriteMessage(typ, ev.Tag, ev.Raw)
+		}
+	}
+	waitAll()
+	for _, c := range conns {
+		c.conn.Close()
+	}
+
+	var diffs []Diff
+	for id, c := range conns {
+		c.mu.Lock()
+		diffs = append(diffs, r.compare(id, c.version, recorded[id], c.replies)...)
+		c.mu.Unlock()
+	}
+	return diffs
+}
+
+// compare pairs recorded and replayed replies by tag, in order, and
+// returns those that differ.
+func (r *Replayer) compare(id uint64, version string, recorded []recordedReply, replayed []reply) []Diff {
+	byTag := make(map[uint16][]reply)
+	for _, rep := range replayed {
+		byTag[rep.tag] = append(byTag[rep.tag], rep)
+	}
+
+	var diffs []Diff
+	for _, ev := range recorded {
+		want := r.describe(version, messageTypes[ev.Type], ev.Raw, ev.dir)
+		var got string
+		if q := byTag[ev.Tag]; len(q) > 0 {
+			got = r.describe(version, q[0].typ, q[0].payload, ev.dir)
+			byTag[ev.Tag] = q[1:]
+		}
+		if got != want {
+			diffs = append(diffs, Diff{Conn: id, Tag: ev.Tag, Recorded: want, Replayed: got})
+		}
+	}
+	// Whatever is left was not in the recording.
+	for _, rep := range replayed {
+		if q := byTag[rep.tag]; len(q) > 0 {
+			diffs = append(diffs, Diff{Conn: id, Tag: rep.tag, Replayed: r.describe(version, q[0].typ, q[0].payload, false)})
+			byTag[rep.tag] = q[1:]
+		}
+	}
+	return diffs
+}
+
+// describe renders a reply for comparison: its name and the decoded
+// message as JSON, without the fields that vary from run to run unless
+// r.Strict is set. The data of an Rread is shown as a string, or, if dir
+// is set, as the stat entries of the directory read.
+func (r *Replayer) describe(version string, typ uint8, payload []byte, dir bool) string {
+	msg, err := protocol.DecodeMessage(version, typ, payload)
+	if err != nil {
+		return fmt.Sprintf("%s %x", protocol.MessageName(typ), payload)
+	}
+	var v any = msg
+	if m, ok := msg.(*protocol.RreadMsg); ok {
+		stats, ok := decodeDir(version, m.Data)
+		if !dir || !ok {
+			return fmt.Sprintf("Rread %q", m.Data)
+		}
+		v = stats
+	}
+	data, _ := json.Marshal(v)
+	if !r.Strict {
+		var v any
+		json.Unmarshal(data, &v)
+		data, _ = json.Marshal(normalize(v))
+	}
+	return fmt.Sprintf("%s %s", protocol.MessageName(typ), data)
+}
+
+// decodeDir decodes the stat entries of a directory read. It reports
+// false if data is not a whole number of entries.
+func decodeDir(version string, data []byte) ([]protocol.Stat, bool) {
+	decode := protocol.DecodeStat
+	if version == protocol.VersionU {
+		decode = protocol.DecodeStatU
+	}
+	stats := []protocol.Stat{}
+	for len(data) > 0 {
+		s, n := decode(data)
+		if n == 0 {
+			return nil, false
+		}
+		stats = append(stats, s)
+		data = data[n:]
+	}
+	return stats, true
+}
+
+// normalize removes qid paths and versions and access and modification
+// times, which depend on when and in what order files were made.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if _, isQid := v["Path"]; isQid {
+			delete(v, "Path")
+			delete(v, "Version")
+		}
+		delete(v, "Atime")
+		delete(v, "Mtime")
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+	}
+	return v
+}
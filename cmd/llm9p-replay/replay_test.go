@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p"
+	"github.com/NERVsystems/llm9p/client"
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/llmfs"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func newServer() *protocol.Server {
+	return protocol.NewServer(llmfs.NewRoot(llm.NewSessionManager(llm.NewMockClient())))
+}
+
+// record runs a short session against a mock server and returns its trace
+func record(t *testing.T) []protocol.TraceEvent {
+	t.Helper()
+	var buf bytes.Buffer
+	srv := newServer()
+	srv.SetTrace(&buf)
+
+	cc, sc := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		srv.ServeConn(sc)
+		close(done)
+	}()
+
+	ctx := context.Background()
+	conn, err := client.NewConn(ctx, cc)
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	c, err := llm9p.New(ctx, conn, "glenda")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s, err := c.NewSession(ctx)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	if err := s.SetModel(ctx, "replay-model"); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	if answer, err := s.Ask(ctx, "hello"); err != nil || answer != "hello" {
+		t.Fatalf("Ask = %q, %v", answer, err)
+	}
+
+	// List the root, whose entries' qid paths and times differ from run
+	// to run.
+	root, err := conn.Attach(ctx, "glenda", "")
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	dir, err := root.Walk(ctx)
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if err := dir.Open(ctx, protocol.OREAD); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if stats, err := dir.ReadDir(ctx); err != nil || len(stats) == 0 {
+		t.Fatalf("ReadDir = %v, %v", stats, err)
+	}
+	dir.Clunk(ctx)
+	root.Clunk(ctx)
+	c.Close()
+	<-done
+
+	events, err := readTrace(&buf)
+	if err != nil {
+		t.Fatalf("readTrace: %v", err)
+	}
+	return events
+}
+
+func TestReplay_Identical(t *testing.T) {
+	events := record(t)
+	r := &Replayer{Server: newServer(), Timeout: 5 * time.Second}
+	if diffs := r.Replay(events); len(diffs) != 0 {
+		t.Errorf("replay differs:\n%v", diffs)
+	}
+}
+
+func TestReplay_Strict(t *testing.T) {
+	// Qid paths come from a counter shared by every server in the
+	// process, so a strict replay sees them differ, in the directory
+	// listing as elsewhere.
+	events := record(t)
+	r := &Replayer{Server: newServer(), Timeout: 5 * time.Second, Strict: true}
+	diffs := r.Replay(events)
+	for _, d := range diffs {
+		if strings.HasPrefix(d.Recorded, "Rread [") {
+			return
+		}
+	}
+	t.Errorf("strict replay shows no decoded directory read among:\n%v", diffs)
+}
+
+func TestReplay_Diff(t *testing.T) {
+	events := record(t)
+
+	// Change the recorded answer.
+	changed := 0
+	for i, ev := range events {
+		if ev.Dir == "out" && ev.Type == "Rread" && bytes.Contains(ev.Raw, []byte("hello")) {
+			buf := make([]byte, 64)
+			n := (&protocol.RreadMsg{Data: []byte("goodbye")}).Encode(buf)
+			events[i].Raw = buf[:n]
+			changed++
+		}
+	}
+	if changed != 1 {
+		t.Fatalf("found %d answers in the trace, want 1", changed)
+	}
+
+	r := &Replayer{Server: newServer(), Timeout: 5 * time.Second}
+	diffs := r.Replay(events)
+	if len(diffs) != 1 {
+		t.Fatalf("diffs = %v, want 1", diffs)
+	}
+	if s := diffs[0].String(); !strings.Contains(s, "goodbye") {
+		t.Errorf("diff %q does not show the recorded answer", s)
+	}
+}
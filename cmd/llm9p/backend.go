@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"gopkg.in/yaml.v3"
+)
+
+// optionList collects repeated -backend-opt name=value flags.
+type optionList llm.BackendConfig
+
+func (o optionList) String() string {
+	var parts []string
+	for k, v := range o {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (o optionList) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("want name=value, not %q", s)
+	}
+	o[name] = value
+	return nil
+}
+
+// backendFile is the backend configuration file, in YAML or JSON:
+//
+//	backend: api            # the default backend
+//	options:                # and its options
+//	  model: claude-sonnet-4-20250514
+//	backends:               # more backends, for models named "ollama/..."
+//	  ollama:
+//	    host: http://gpu-box:11434
+type backendFile struct {
+	Backend  string                    `yaml:"backend"`
+	Options  map[string]any            `yaml:"options"`
+	Backends map[string]map[string]any `yaml:"backends"`
+}
+
+// loadBackendFile reads a backend configuration file. It returns the
+// backends it names, the default first, and their options.
+func loadBackendFile(path string) ([]string, map[string]llm.BackendConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var f backendFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var names []string
+	cfgs := make(map[string]llm.BackendConfig)
+	add := func(name string, options map[string]any) {
+		cfg, ok := cfgs[name]
+		if !ok {
+			names = append(names, name)
+			cfg = make(llm.BackendConfig)
+			cfgs[name] = cfg
+		}
+		for k, v := range options {
+			cfg[k] = fmt.Sprint(v)
+		}
+	}
+	if f.Backend != "" {
+		add(f.Backend, f.Options)
+	}
+	extra := make([]string, 0, len(f.Backends))
+	for name := range f.Backends {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		add(name, f.Backends[name])
+	}
+	return names, cfgs, nil
+}
+
+// configureBackends builds the backends named by -backend, a
+// comma-separated list whose first is the default, or else those of the
+// configuration file. Options from -backend-opt override the file's: a
+// plain name sets an option of the default backend, and "backend.name"
+// one of any backend.
+func configureBackends(list string, listSet bool, file string, opts llm.BackendConfig) (map[string]llm.Backend, []string, error) {
+	names := strings.Split(list, ",")
+	cfgs := make(map[string]llm.BackendConfig)
+	if file != "" {
+		fileNames, fileCfgs, err := loadBackendFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !listSet && len(fileNames) > 0 {
+			names = fileNames
+		}
+		cfgs = fileCfgs
+	}
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+		if cfgs[names[i]] == nil {
+			cfgs[names[i]] = make(llm.BackendConfig)
+		}
+	}
+
+	for k, v := range opts {
+		name, opt, ok := strings.Cut(k, ".")
+		if !ok {
+			name, opt = names[0], k
+		}
+		cfg, ok := cfgs[name]
+		if !ok || !slices.Contains(names, name) {
+			return nil, nil, fmt.Errorf("option %s is for backend %s, which is not in use", k, name)
+		}
+		cfg[opt] = v
+	}
+
+	backends := make(map[string]llm.Backend, len(names))
+	for _, name := range names {
+		if _, dup := backends[name]; dup {
+			return nil, nil, fmt.Errorf("backend %s given twice", name)
+		}
+		b, err := llm.NewBackend(name, cfgs[name])
+		if err != nil {
+			return nil, nil, err
+		}
+		backends[name] = b
+	}
+	return backends, names, nil
+}
+
+// listBackends prints the registered backends and their options.
+func listBackends(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, f := range llm.Backends() {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", f.Name, f.Description)
+		for _, opt := range f.Options {
+			var notes []string
+			if opt.Required {
+				notes = append(notes, "required")
+			}
+			if opt.Default != "" {
+				notes = append(notes, "default "+opt.Default)
+			}
+			if opt.Env != "" {
+				notes = append(notes, "$"+opt.Env)
+			}
+			usage := opt.Usage
+			if len(notes) > 0 {
+				usage += " (" + strings.Join(notes, ", ") + ")"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\n", opt.Name, usage)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+)
+
+func TestConfigureBackends(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "backend.yaml", []byte(`
+backend: mock
+options:
+  latency: 1s
+  chunk-delay: 10ms
+`))
+
+	// The file picks the backend; -backend-opt overrides its options.
+	opts := make(optionList)
+	if err := opts.Set("latency=2ms"); err != nil {
+		t.Fatal(err)
+	}
+	backends, names, err := configureBackends("api", false, file, llm.BackendConfig(opts))
+	if err != nil {
+		t.Fatalf("configureBackends error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "mock" {
+		t.Errorf("backends = %v, want [mock] from the file", names)
+	}
+	b := backends["mock"]
+	if _, ok := b.(*llm.MockClient); !ok {
+		t.Errorf("backend is %T, want *llm.MockClient", b)
+	}
+
+	// The latency came from -backend-opt, not the file.
+	start := time.Now()
+	b.Ask(context.Background(), "hi")
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Ask took %v, want the 2ms latency from -backend-opt", d)
+	}
+
+	// An explicit -backend wins over the file.
+	if _, names, _ := configureBackends("ollama", true, file, nil); len(names) != 1 || names[0] != "ollama" {
+		t.Errorf("backends = %v, want [ollama] from the flag", names)
+	}
+
+	if _, _, err := configureBackends("mock", true, filepath.Join(dir, "missing.yaml"), nil); err == nil {
+		t.Error("missing config file accepted")
+	}
+}
+
+func TestConfigureBackends_Several(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "backends.yaml", []byte(`
+backend: mock
+backends:
+  ollama:
+    host: http://gpu-box:11434
+    model: qwen2.5
+`))
+
+	opts := make(optionList)
+	opts.Set("ollama.model=llama3")
+	backends, names, err := configureBackends("api", false, file, llm.BackendConfig(opts))
+	if err != nil {
+		t.Fatalf("configureBackends error: %v", err)
+	}
+	if strings.Join(names, ",") != "mock,ollama" {
+		t.Errorf("backends = %v, want [mock ollama]", names)
+	}
+	if m := backends["ollama"].Model(); m != "llama3" {
+		t.Errorf("ollama model = %q, want llama3 from -backend-opt", m)
+	}
+
+	// A comma-separated -backend names several, the first the default.
+	_, names, err = configureBackends("mock, ollama", true, "", nil)
+	if err != nil {
+		t.Fatalf("configureBackends error: %v", err)
+	}
+	if strings.Join(names, ",") != "mock,ollama" {
+		t.Errorf("backends = %v, want [mock ollama]", names)
+	}
+
+	for _, tc := range []struct {
+		list string
+		opt  string
+	}{
+		{"mock,mock", ""},
+		{"mock", "ollama.model=llama3"},
+		{"mock,nosuch", ""},
+	} {
+		opts := make(optionList)
+		if tc.opt != "" {
+			opts.Set(tc.opt)
+		}
+		if _, _, err := configureBackends(tc.list, true, "", llm.BackendConfig(opts)); err == nil {
+			t.Errorf("configureBackends(%q, %q) succeeded", tc.list, tc.opt)
+		}
+	}
+}
+
+func TestOptionList(t *testing.T) {
+	opts := make(optionList)
+	for _, bad := range []string{"noequals", "=value"} {
+		if err := opts.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+	opts.Set("url=http://host/?a=b")
+	if opts["url"] != "http://host/?a=b" {
+		t.Errorf("url = %q, want everything after the first =", opts["url"])
+	}
+}
+
+func TestListBackends(t *testing.T) {
+	var buf bytes.Buffer
+	listBackends(&buf)
+	out := buf.String()
+	for _, want := range []string{"api ", "cli ", "mock ", "ollama ", "openai ", "$ANTHROPIC_API_KEY", "default llama3.2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list lacks %q:\n%s", want, out)
+		}
+	}
+}
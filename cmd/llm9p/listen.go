@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// addrList is a flag that may be repeated or given a comma-separated list.
+type addrList []string
+
+func (a *addrList) String() string { return strings.Join(*a, ",") }
+
+func (a *addrList) Set(s string) error {
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*a = append(*a, addr)
+		}
+	}
+	return nil
+}
+
+// parseAddr converts a listen address to a network and address for
+// net.Listen. It accepts Plan 9 dial strings (unix!/path, tcp!host!port,
+// with * for any host) as well as plain host:port TCP addresses.
+func parseAddr(addr string) (network, address string, err error) {
+	if !strings.Contains(addr, "!") {
+		return "tcp", addr, nil
+	}
+
+	parts := strings.Split(addr, "!")
+	switch parts[0] {
+	case "unix":
+		if len(parts) != 2 || parts[1] == "" {
+			return "", "", fmt.Errorf("bad unix address %q: want unix!path", addr)
+		}
+		return "unix", parts[1], nil
+
+	case "tcp", "tcp4", "tcp6", "net":
+		if len(parts) != 3 || parts[2] == "" {
+			return "", "", fmt.Errorf("bad tcp address %q: want tcp!host!port", addr)
+		}
+		host := parts[1]
+		if host == "*" {
+			host = ""
+		}
+		network := parts[0]
+		if network == "net" {
+			network = "tcp"
+		}
+		return network, net.JoinHostPort(host, parts[2]), nil
+
+	default:
+		return "", "", fmt.Errorf("unsupported network %q in %q", parts[0], addr)
+	}
+}
+
+// listen opens a listener for a -addr value.
+func listen(addr string) (net.Listener, error) {
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		return listenUnix(address)
+	}
+	return net.Listen(network, address)
+}
+
+// listenUnix listens on a Unix domain socket that only the current user
+// can connect to. A socket left behind by an earlier run is replaced, but
+// any other kind of file at path is an error. The socket file is removed
+// when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	// plan9port keeps its sockets in /tmp/ns.$USER, which may not exist.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// Create the socket without group or other access, rather than
+	// chmoding it afterwards, so no one else can connect in between. The
+	// chmod still guards against a umask that could not be set.
+	var l net.Listener
+	err := withUmask(0077, func() (err error) {
+		l, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// inheritedListeners returns the listening sockets passed by systemd
+// socket activation (LISTEN_PID and LISTEN_FDS), starting at descriptor
+// first. It returns nil if none were passed to this process.
+func inheritedListeners(pid, fds string, first int) ([]net.Listener, error) {
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+		return nil, nil // meant for another process
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad LISTEN_FDS %q", fds)
+	}
+
+	var listeners []net.Listener
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close() // FileListener holds its own copy
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// mountHint describes how to mount the server listening on l.
+func mountHint(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return fmt.Sprintf("9pfuse 'unix!%s' /mnt/llm", l.Addr())
+	}
+	return fmt.Sprintf("9pfuse %s /mnt/llm", l.Addr())
+}
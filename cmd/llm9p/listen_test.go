@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		wantErr bool
+	}{
+		{":5640", "tcp", ":5640", false},
+		{"127.0.0.1:5640", "tcp", "127.0.0.1:5640", false},
+		{"tcp!127.0.0.1!5640", "tcp", "127.0.0.1:5640", false},
+		{"tcp!*!5640", "tcp", ":5640", false},
+		{"net!::1!5640", "tcp", "[::1]:5640", false},
+		{"tcp6!*!5640", "tcp6", ":5640", false},
+		{"unix!/tmp/ns.glenda/llm", "unix", "/tmp/ns.glenda/llm", false},
+		{"unix!", "", "", true},
+		{"tcp!localhost", "", "", true},
+		{"udp!*!5640", "", "", true},
+	}
+	for _, tt := range tests {
+		network, address, err := parseAddr(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAddr(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if network != tt.network || address != tt.address {
+			t.Errorf("parseAddr(%q) = %q, %q; want %q, %q", tt.addr, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestAddrList(t *testing.T) {
+	var addrs addrList
+	addrs.Set("unix!/tmp/a, :5640")
+	addrs.Set("tcp!*!5641")
+	if len(addrs) != 3 || addrs[0] != "unix!/tmp/a" || addrs[1] != ":5640" || addrs[2] != "tcp!*!5641" {
+		t.Errorf("addrs = %q", addrs)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ns", "llm")
+
+	l, err := listen("unix!" + path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket mode = %o, want 0600", perm)
+	}
+
+	// A second server must not steal a live socket.
+	if l2, err := listenUnix(path); err == nil {
+		l2.Close()
+		t.Error("listening on a socket in use should fail")
+	}
+
+	l.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+}
+
+func TestWithUmask(t *testing.T) {
+	// Files created under the umask never have group or other access,
+	// which is how listenUnix closes the window before its chmod.
+	path := filepath.Join(t.TempDir(), "file")
+	err := withUmask(0077, func() error {
+		return os.WriteFile(path, nil, 0666)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("mode = %o, want 0600", perm)
+	}
+}
+
+func TestListenUnix_StaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm")
+
+	// Leave a socket file behind, as a crashed server would.
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	l, err = listenUnix(path)
+	if err != nil {
+		t.Fatalf("listenUnix over stale socket: %v", err)
+	}
+	l.Close()
+}
+
+func TestListenUnix_NotSocket(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "llm", []byte("precious"))
+	if l, err := listenUnix(path); err == nil {
+		l.Close()
+		t.Fatal("listenUnix should refuse to replace a regular file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "precious" {
+		t.Error("regular file was clobbered")
+	}
+}
+
+func TestInheritedListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	pid := strconv.Itoa(os.Getpid())
+
+	if got, err := inheritedListeners("1", "1", int(f.Fd())); err != nil || got != nil {
+		t.Errorf("listeners for another pid = %v, %v; want none", got, err)
+	}
+	if _, err := inheritedListeners(pid, "x", int(f.Fd())); err == nil {
+		t.Error("bad LISTEN_FDS should fail")
+	}
+
+	// inheritedListeners takes ownership of the descriptor, as it would
+	// of one passed by systemd, so hand it a bare duplicate.
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := inheritedListeners(pid, "1", fd)
+	if err != nil {
+		t.Fatalf("inheritedListeners: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d listeners, want 1", len(got))
+	}
+	defer got[0].Close()
+	if got[0].Addr().String() != l.Addr().String() {
+		t.Errorf("inherited addr = %s, want %s", got[0].Addr(), l.Addr())
+	}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -8,6 +8,26 @@
 //
 //	llm9p -addr :5640 -backend cli
 //
+// Or with local models served by Ollama:
+//
+//	OLLAMA_MODEL=llama3.2 llm9p -addr :5640 -backend ollama
+//
+// Or with any OpenAI-compatible server, such as vLLM or llama.cpp:
+//
+//	OPENAI_BASE_URL=http://localhost:8000/v1 OPENAI_MODEL=qwen2.5-7b llm9p -backend openai
+//
+// Or with no LLM at all, for CI and client development:
+//
+//	llm9p -backend mock -backend-opt script=responses.yaml -backend-opt latency=500ms
+//
+// List the backends and their options with:
+//
+//	llm9p -backend list
+//
+// Or on a Unix socket, plan9port style:
+//
+//	llm9p -addr 'unix!/tmp/ns.'$USER'/llm'
+//
 // Mount with:
 //
 //	9pfuse localhost:5640 /mnt/llm
@@ -19,15 +39,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/NERVsystems/llm9p/internal/llm"
 	"github.com/NERVsystems/llm9p/internal/llmfs"
@@ -35,74 +60,198 @@ import (
 )
 
 func main() {
-	addr := flag.String("addr", ":5640", "Address to listen on")
+	var addrs addrList
+	flag.Var(&addrs, "addr", "Address to listen on: host:port, tcp!host!port or unix!path (repeatable or comma-separated; default :5640)")
 	debug := flag.Bool("debug", false, "Enable debug logging")
-	backend := flag.String("backend", "api", "Backend to use: 'api' (Anthropic API) or 'cli' (Claude Code CLI for Max subscription)")
+	msize := flag.Uint("msize", protocol.MaxMessageSize, "Largest 9P message size to negotiate with clients")
+	authSecret := flag.String("auth-secret", "", "Require challenge-response authentication with the shared secret in this file")
+	authPasswd := flag.String("auth-passwd", "", "Require authentication against this user:password file")
+	tlsCert := flag.String("tls-cert", "", "Serve TLS using this PEM certificate file")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "Require client certificates signed by a CA in this PEM file (mutual TLS)")
+	perUser := flag.Bool("per-user", false, "Give each user a root showing only their own sessions")
+	admins := flag.String("admin", "", "Comma-separated users who may attach the admin tree with aname 'admin'")
+	trace := flag.String("trace", "", "Write every 9P message, decoded, to this file as JSON lines (see llm9p-replay)")
+	maxConns := flag.Int("max-conns", 0, "Maximum number of client connections (0 for no limit)")
+	maxFids := flag.Int("max-fids", 0, "Maximum number of fids per connection (0 for no limit)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Close connections idle for this long (0 to never)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "On SIGINT or SIGTERM, how long to let outstanding requests finish before cancelling them")
+	backend := flag.String("backend", "api", "Backend to use, or a comma-separated list whose first is the default and the rest answer models named 'backend/model'; 'list' shows the available backends and their options")
+	backendConfig := flag.String("backend-config", "", "Read the backend and its options from this YAML or JSON file")
+	backendOpts := make(optionList)
+	flag.Var(backendOpts, "backend-opt", "Set a backend option as name=value, or backend.name=value for a backend other than the default (repeatable; overrides -backend-config)")
 	flag.Parse()
 
-	var client llm.Backend
-
-	switch *backend {
-	case "cli":
-		// Check that claude CLI is available
-		if _, err := exec.LookPath("claude"); err != nil {
-			fmt.Fprintln(os.Stderr, "Error: 'claude' CLI not found in PATH")
-			fmt.Fprintln(os.Stderr, "Install Claude Code CLI or use -backend api with ANTHROPIC_API_KEY")
-			os.Exit(1)
-		}
-		client = llm.NewCLIClient()
-		log.Println("Using Claude Code CLI backend (Claude Max subscription)")
-
-	case "api":
-		// Get API key from environment
-		apiKey := os.Getenv("ANTHROPIC_API_KEY")
-		if apiKey == "" {
-			fmt.Fprintln(os.Stderr, "Error: ANTHROPIC_API_KEY environment variable not set")
-			fmt.Fprintln(os.Stderr, "Set ANTHROPIC_API_KEY or use -backend cli for Claude Max subscription")
-			os.Exit(1)
-		}
-		client = llm.NewClient(apiKey)
-		log.Println("Using Anthropic API backend")
+	if *backend == "list" {
+		listBackends(os.Stdout)
+		return
+	}
 
-	default:
-		fmt.Fprintf(os.Stderr, "Error: unknown backend '%s' (use 'api' or 'cli')\n", *backend)
+	backendSet := false
+	flag.Visit(func(f *flag.Flag) { backendSet = backendSet || f.Name == "backend" })
+	backends, backendNames, err := configureBackends(*backend, backendSet, *backendConfig, llm.BackendConfig(backendOpts))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Run 'llm9p -backend list' for the available backends and their options")
 		os.Exit(1)
 	}
+	client := backends[backendNames[0]]
+	log.Printf("Using %s backend (model %s)", backendNames[0], client.Model())
 
-	// Create session manager for per-fid isolation
+	// Create session manager for per-fid isolation. Sessions start with
+	// the default backend's model, which may not be a Claude one; a model
+	// named "backend/model" sends a session to another backend.
 	sm := llm.NewSessionManager(client)
+	for _, name := range backendNames {
+		sm.AddBackend(name, backends[name])
+		if name != backendNames[0] {
+			log.Printf("Routing %s/ models to the %s backend", name, name)
+		}
+	}
+	defaults := sm.Defaults()
+	defaults.Model = client.Model()
+	sm.SetDefaults(defaults)
 
 	// Create filesystem
 	root := llmfs.NewRoot(sm)
 
+	// Choose each client's root by user and attach name
+	ns := llmfs.NewNamespace(sm)
+	ns.SetPerUser(*perUser)
+	if *admins != "" {
+		ns.SetAdmins(strings.Split(*admins, ",")...)
+	}
+
 	// Create 9P server
 	server := protocol.NewServer(root)
+	server.SetNamespace(ns)
 	server.SetDebug(*debug)
+	server.SetMaxMsize(uint32(min(*msize, protocol.MaxMsizeLimit)))
+	server.SetMaxConns(*maxConns)
+	server.SetMaxFids(*maxFids)
+	server.SetIdleTimeout(*idleTimeout)
 
-	// Listen
-	listener, err := net.Listen("tcp", *addr)
+	if *trace != "" {
+		f, err := os.OpenFile(*trace, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("Failed to open trace file: %v", err)
+		}
+		defer f.Close()
+		server.SetTrace(f)
+		log.Printf("Tracing 9P messages to %s", *trace)
+	}
+
+	switch {
+	case *authSecret != "" && *authPasswd != "":
+		fmt.Fprintln(os.Stderr, "Error: use only one of -auth-secret and -auth-passwd")
+		os.Exit(1)
+
+	case *authSecret != "":
+		secret, err := os.ReadFile(*authSecret)
+		if err != nil {
+			log.Fatalf("Failed to read secret: %v", err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			log.Fatalf("Secret file %s is empty", *authSecret)
+		}
+		server.SetAuthenticator(protocol.NewSecretAuth(secret))
+		log.Println("Requiring shared-secret authentication")
+
+	case *authPasswd != "":
+		auth, err := protocol.LoadPasswordFile(*authPasswd)
+		if err != nil {
+			log.Fatalf("Failed to load password file: %v", err)
+		}
+		server.SetAuthenticator(auth)
+		log.Println("Requiring password authentication")
+	}
+
+	// Listen, preferring sockets passed by systemd socket activation
+	listeners, err := inheritedListeners(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), listenFDsStart)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+		log.Fatalf("Socket activation: %v", err)
 	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
 
-	log.Printf("llm9p listening on %s", *addr)
-	log.Printf("Mount with: 9pfuse %s /mnt/llm", listener.Addr())
+	if len(listeners) == 0 {
+		if len(addrs) == 0 {
+			addrs = addrList{":5640"}
+		}
+		for _, addr := range addrs {
+			l, err := listen(addr)
+			if err != nil {
+				closeAll(listeners)
+				log.Fatalf("Failed to listen on %s: %v", addr, err)
+			}
+			listeners = append(listeners, l)
+		}
+	}
 
-	// Handle shutdown gracefully
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+		config, err := loadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			closeAll(listeners)
+			log.Fatalf("TLS: %v", err)
+		}
+		for i, l := range listeners {
+			listeners[i] = tls.NewListener(l, config)
+		}
+		if config.ClientCAs != nil {
+			log.Println("Serving TLS; client certificates required")
+		} else {
+			log.Println("Serving TLS")
+		}
+	}
+
+	for _, l := range listeners {
+		log.Printf("llm9p listening on %s!%s", l.Addr().Network(), l.Addr())
+		log.Printf("Mount with: %s", mountHint(l))
+	}
 
+	// Serve
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			if err := server.Serve(ctx, l); err != nil && !errors.Is(err, protocol.ErrServerClosed) {
+				log.Printf("Server error on %s: %v", l.Addr(), err)
+			}
+		}(l)
+	}
+	served := make(chan struct{})
 	go func() {
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-		<-sigCh
-		log.Println("Shutting down...")
-		cancel()
-		listener.Close()
+		wg.Wait()
+		close(served)
 	}()
 
-	// Serve
-	if err := server.Serve(ctx, listener); err != nil && ctx.Err() == nil {
-		log.Fatalf("Server error: %v", err)
+	// Shut down gracefully: stop accepting, then give outstanding
+	// requests a while to finish. Closing a Unix listener also removes
+	// its socket file.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sigCh:
+	case <-served:
+		return
+	}
+	log.Println("Shutting down...")
+	signal.Stop(sigCh)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Cancelled outstanding requests after %v", *shutdownTimeout)
+	}
+	<-served
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
 	}
 }
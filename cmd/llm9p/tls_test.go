@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// testCA issues certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "llm9p test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns PEM certificate and key for cn
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startTLSServer serves an empty filesystem over mutual TLS
+func startTLSServer(t *testing.T, ca *testCA) string {
+	t.Helper()
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "llm9p", x509.ExtKeyUsageServerAuth)
+	config, err := loadTLSConfig(
+		writeFile(t, dir, "server.pem", certPEM),
+		writeFile(t, dir, "server.key", keyPEM),
+		writeFile(t, dir, "ca.pem", ca.pem),
+	)
+	if err != nil {
+		t.Fatalf("loadTLSConfig: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		listener.Close()
+	})
+	go protocol.NewServer(protocol.NewStaticDir("root")).Serve(ctx, tls.NewListener(listener, config))
+	return listener.Addr().String()
+}
+
+// attach dials addr with the given client certificate and attaches as
+// uname, returning the reply type
+func attach(t *testing.T, addr string, ca *testCA, cert *tls.Certificate, uname string) (uint8, error) {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	enc := protocol.NewEncoder(conn)
+	dec := protocol.NewDecoder(conn)
+	buf := make([]byte, protocol.MaxMessageSize)
+	for _, msg := range []protocol.Message{
+		&protocol.TversionMsg{Msize: protocol.MaxMessageSize, Version: protocol.Version},
+		&protocol.TattachMsg{Fid: 0, Afid: protocol.NoFid, Uname: uname},
+	} {
+		n := msg.Encode(buf)
+		if err := enc.WriteMessage(msg.Type(), 1, buf[:n]); err != nil {
+			return 0, err
+		}
+		msgType, _, _, err := dec.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if msg.Type() == protocol.Tattach {
+			return msgType, nil
+		}
+	}
+	return 0, nil
+}
+
+func TestMutualTLS_CommonNameIsUser(t *testing.T) {
+	ca := newTestCA(t)
+	addr := startTLSServer(t, ca)
+
+	certPEM, keyPEM := ca.issue(t, "glenda", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := attach(t, addr, ca, &cert, "glenda"); err != nil || got != protocol.Rattach {
+		t.Errorf("attach as certificate user = %s, %v; want Rattach", protocol.MessageName(got), err)
+	}
+	if got, err := attach(t, addr, ca, &cert, "bootes"); err != nil || got != protocol.Rerror {
+		t.Errorf("attach as another user = %s, %v; want Rerror", protocol.MessageName(got), err)
+	}
+}
+
+func TestMutualTLS_EmptyCommonName(t *testing.T) {
+	ca := newTestCA(t)
+	addr := startTLSServer(t, ca)
+
+	// A verified certificate naming no one authenticates no one.
+	certPEM, keyPEM := ca.issue(t, "", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uname := range []string{"", "glenda"} {
+		if got, err := attach(t, addr, ca, &cert, uname); err != nil || got != protocol.Rerror {
+			t.Errorf("attach as %q = %s, %v; want Rerror", uname, protocol.MessageName(got), err)
+		}
+	}
+}
+
+func TestMutualTLS_RequiresClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	addr := startTLSServer(t, ca)
+
+	if got, err := attach(t, addr, ca, nil, "glenda"); err == nil {
+		t.Errorf("attach without a client certificate = %s, want a TLS error", protocol.MessageName(got))
+	}
+
+	// A certificate from another CA is no better.
+	other := newTestCA(t)
+	certPEM, keyPEM := other.issue(t, "glenda", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := attach(t, addr, ca, &cert, "glenda"); err == nil {
+		t.Errorf("attach with an untrusted certificate = %s, want a TLS error", protocol.MessageName(got))
+	}
+}
+
+func TestLoadTLSConfig_Errors(t *testing.T) {
+	if _, err := loadTLSConfig("", "", "ca.pem"); err == nil {
+		t.Error("client CA without certificate should fail")
+	}
+	dir := t.TempDir()
+	if _, err := loadTLSConfig(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing.key"), ""); err == nil {
+		t.Error("missing certificate files should fail")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// withUmask runs fn. Systems without a umask rely on the chmod that
+// follows.
+func withUmask(mask int, fn func() error) error {
+	return fn()
+}
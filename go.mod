@@ -2,7 +2,10 @@ module github.com/NERVsystems/llm9p
 
 go 1.21
 
-require github.com/anthropics/anthropic-sdk-go v0.2.0-beta.3
+require (
+	github.com/anthropics/anthropic-sdk-go v0.2.0-beta.3
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/tidwall/gjson v1.14.4 // indirect
@@ -1,10 +1,14 @@
 // Package llm provides LLM backends for the 9P filesystem.
 package llm
 
-import "context"
+import (
+	"context"
+	"io"
+)
 
 // Backend defines the interface for LLM backends.
-// Both API and CLI clients implement this interface.
+// The API, CLI, Ollama, OpenAI-compatible and mock clients implement this
+// interface.
 type Backend interface {
 	// Model returns the current model name
 	Model() string
@@ -62,6 +66,40 @@ type Backend interface {
 	WaitStream()
 }
 
-// Verify that both clients implement Backend
+// ContextStreamReader is implemented by backends whose stream chunks can be
+// waited for with a context, so that a flushed read of stream/chunk does
+// not block until the next chunk. ReadStreamChunkContext returns io.EOF
+// when the stream is complete and ctx's error once ctx is done.
+type ContextStreamReader interface {
+	ReadStreamChunkContext(ctx context.Context) (string, error)
+}
+
+// readStreamChunk waits for the next chunk on a backend's stream channel,
+// which is nil if no stream was started, or for ctx to be done.
+func readStreamChunk(ctx context.Context, ch <-chan string) (string, error) {
+	if ch == nil {
+		return "", io.EOF
+	}
+	select {
+	case chunk, ok := <-ch:
+		if !ok {
+			return "", io.EOF
+		}
+		return chunk, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Verify that the clients implement Backend
 var _ Backend = (*Client)(nil)
 var _ Backend = (*CLIClient)(nil)
+var _ Backend = (*OllamaClient)(nil)
+var _ Backend = (*OpenAIClient)(nil)
+var _ Backend = (*MockClient)(nil)
+
+var _ ContextStreamReader = (*Client)(nil)
+var _ ContextStreamReader = (*CLIClient)(nil)
+var _ ContextStreamReader = (*OllamaClient)(nil)
+var _ ContextStreamReader = (*OpenAIClient)(nil)
+var _ ContextStreamReader = (*MockClient)(nil)
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
@@ -35,6 +36,24 @@ type cliResponse struct {
 	Result string `json:"result"`
 }
 
+func init() {
+	RegisterBackend(BackendFactory{
+		Name:        "cli",
+		Description: "Claude Code CLI, for a Claude Max subscription",
+		Options: []BackendOption{
+			{Name: "model", Usage: "Model for new sessions: opus, sonnet or haiku", Default: "sonnet"},
+		},
+		New: func(cfg BackendConfig) (Backend, error) {
+			if _, err := exec.LookPath("claude"); err != nil {
+				return nil, errors.New("'claude' CLI not found in PATH; install Claude Code CLI or use -backend api with ANTHROPIC_API_KEY")
+			}
+			c := NewCLIClient()
+			c.SetModel(cfg["model"])
+			return c, nil
+		},
+	})
+}
+
 // NewCLIClient creates a new CLI-based LLM client
 func NewCLIClient() *CLIClient {
 	return &CLIClient{
@@ -547,6 +566,14 @@ func (c *CLIClient) ReadStreamChunk() (string, bool) {
 	return chunk, ok
 }
 
+// ReadStreamChunkContext is ReadStreamChunk that gives up when ctx is done
+func (c *CLIClient) ReadStreamChunkContext(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+	return readStreamChunk(ctx, streamChan)
+}
+
 // IsStreaming returns whether a stream is currently in progress
 func (c *CLIClient) IsStreaming() bool {
 	c.mu.RLock()
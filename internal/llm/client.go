@@ -54,6 +54,22 @@ type Client struct {
 	streamDone     chan struct{}
 }
 
+func init() {
+	RegisterBackend(BackendFactory{
+		Name:        "api",
+		Description: "Anthropic API",
+		Options: []BackendOption{
+			{Name: "api-key", Usage: "Anthropic API key", Env: "ANTHROPIC_API_KEY", Required: true},
+			{Name: "model", Usage: "Model for new sessions", Default: "claude-sonnet-4-20250514"},
+		},
+		New: func(cfg BackendConfig) (Backend, error) {
+			c := NewClient(cfg["api-key"])
+			c.SetModel(cfg["model"])
+			return c, nil
+		},
+	})
+}
+
 // NewClient creates a new LLM client
 func NewClient(apiKey string) *Client {
 	client := anthropic.NewClient(option.WithAPIKey(apiKey))
@@ -497,6 +513,14 @@ func (c *Client) ReadStreamChunk() (string, bool) {
 	return chunk, ok
 }
 
+// ReadStreamChunkContext is ReadStreamChunk that gives up when ctx is done
+func (c *Client) ReadStreamChunkContext(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+	return readStreamChunk(ctx, streamChan)
+}
+
 // IsStreaming returns whether a stream is currently in progress
 func (c *Client) IsStreaming() bool {
 	c.mu.RLock()
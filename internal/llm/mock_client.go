@@ -0,0 +1,501 @@
+// Mock backend for tests, demos, CI and trace replay.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MockClient is a backend that needs no network or credentials. By default
+// it answers every prompt by echoing it back; given a script it answers
+// from that instead. Either way the prefill, if set, comes first, and the
+// same requests always get the same responses and token counts.
+type MockClient struct {
+	mu             sync.RWMutex
+	model          string
+	temperature    float64
+	systemPrompt   string
+	prefill        string
+	messages       []Message
+	lastTokens     int
+	totalTokens    int
+	thinkingTokens int
+	streaming      bool
+	streamChan     chan string
+	streamDone     chan struct{}
+
+	script     *MockScript   // nil to echo
+	latency    time.Duration // before each response, or its first chunk
+	chunkDelay time.Duration // between streamed chunks
+}
+
+// MockScript gives the responses of a scripted MockClient. The first rule
+// whose pattern matches the prompt supplies the response.
+//
+// In YAML:
+//
+//	responses:
+//	  - match: "(?i)^hello"
+//	    response: "Hi there!"
+//	  - match: "weather"
+//	    response: "It is always sunny in the mock."
+//	  - response: "I have no answer for that."  # no match: any prompt
+//
+// JSON with the same fields works too.
+type MockScript struct {
+	Responses []MockRule `yaml:"responses" json:"responses"`
+}
+
+// MockRule is one scripted response.
+type MockRule struct {
+	Match    string `yaml:"match" json:"match"` // regular expression; empty matches any prompt
+	Response string `yaml:"response" json:"response"`
+
+	re *regexp.Regexp
+}
+
+func init() {
+	RegisterBackend(BackendFactory{
+		Name:        "mock",
+		Description: "No LLM: echoed or scripted responses, for CI and client development",
+		Options: []BackendOption{
+			{Name: "script", Usage: "YAML or JSON script to answer from instead of echoing prompts"},
+			{Name: "latency", Usage: "Wait before each response, e.g. 500ms"},
+			{Name: "chunk-delay", Usage: "Wait between streamed chunks"},
+		},
+		New: func(cfg BackendConfig) (Backend, error) {
+			latency, err := cfg.Duration("latency")
+			if err != nil {
+				return nil, err
+			}
+			chunkDelay, err := cfg.Duration("chunk-delay")
+			if err != nil {
+				return nil, err
+			}
+			c := NewMockClient()
+			if cfg["script"] != "" {
+				script, err := LoadMockScript(cfg["script"])
+				if err != nil {
+					return nil, err
+				}
+				c.SetScript(script)
+			}
+			c.SetLatency(latency, chunkDelay)
+			return c, nil
+		},
+	})
+}
+
+// NewMockClient creates a mock backend.
+func NewMockClient() *MockClient {
+	return &MockClient{
+		model:       "mock",
+		temperature: 0.7,
+		messages:    make([]Message, 0),
+	}
+}
+
+// ParseMockScript parses a script in YAML or JSON and compiles its
+// patterns.
+func ParseMockScript(data []byte) (*MockScript, error) {
+	var script MockScript
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	if len(script.Responses) == 0 {
+		return nil, fmt.Errorf("no responses in script")
+	}
+	for i := range script.Responses {
+		re, err := regexp.Compile(script.Responses[i].Match)
+		if err != nil {
+			return nil, fmt.Errorf("response %d: %w", i+1, err)
+		}
+		script.Responses[i].re = re
+	}
+	return &script, nil
+}
+
+// LoadMockScript reads a script from a YAML or JSON file.
+func LoadMockScript(path string) (*MockScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script, err := ParseMockScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return script, nil
+}
+
+// respond returns the response of the first rule that matches prompt.
+func (s *MockScript) respond(prompt string) (string, error) {
+	for _, rule := range s.Responses {
+		if rule.re.MatchString(prompt) {
+			return rule.Response, nil
+		}
+	}
+	return "", fmt.Errorf("mock: no scripted response matches %q", prompt)
+}
+
+// SetScript makes the client answer from script; nil makes it echo.
+func (c *MockClient) SetScript(script *MockScript) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.script = script
+}
+
+// SetLatency makes the client wait latency before each response, or
+// before the first chunk of a stream, and chunkDelay between chunks, as a
+// slow backend would. Both may be cancelled through the request's context.
+func (c *MockClient) SetLatency(latency, chunkDelay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = latency
+	c.chunkDelay = chunkDelay
+}
+
+// reply returns the response to prompt, after prefill.
+func (c *MockClient) reply(prompt, prefill string) (string, error) {
+	c.mu.RLock()
+	script := c.script
+	c.mu.RUnlock()
+	if script == nil {
+		return prefill + prompt, nil
+	}
+	response, err := script.respond(prompt)
+	if err != nil {
+		return "", err
+	}
+	return prefill + response, nil
+}
+
+// mockTokens counts the tokens of a request and its response, estimated
+// from their lengths the way the CLI client does.
+func mockTokens(systemPrompt string, history []Message, prompt, response string) int {
+	tokens := estimateTokens(systemPrompt) + estimateTokens(prompt) + estimateTokens(response)
+	for _, msg := range history {
+		tokens += estimateTokens(msg.Content)
+	}
+	return tokens
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay waits out the client's latency.
+func (c *MockClient) delay(ctx context.Context) error {
+	c.mu.RLock()
+	latency := c.latency
+	c.mu.RUnlock()
+	return sleep(ctx, latency)
+}
+
+// Model returns the current model name
+func (c *MockClient) Model() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// SetModel sets the model for subsequent requests
+func (c *MockClient) SetModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = model
+}
+
+// Temperature returns the current temperature
+func (c *MockClient) Temperature() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.temperature
+}
+
+// SetTemperature sets the temperature for subsequent requests
+func (c *MockClient) SetTemperature(temp float64) error {
+	if temp < 0.0 || temp > 2.0 {
+		return fmt.Errorf("temperature must be between 0.0 and 2.0")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.temperature = temp
+	return nil
+}
+
+// ThinkingTokens returns the thinking token budget
+func (c *MockClient) ThinkingTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.thinkingTokens
+}
+
+// SetThinkingTokens sets the thinking token budget
+func (c *MockClient) SetThinkingTokens(tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.thinkingTokens = tokens
+}
+
+// Prefill returns the assistant response prefill string
+func (c *MockClient) Prefill() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.prefill
+}
+
+// SetPrefill sets a string to prefill the assistant response
+func (c *MockClient) SetPrefill(prefill string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefill = prefill
+}
+
+// SystemPrompt returns the current system prompt
+func (c *MockClient) SystemPrompt() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.systemPrompt
+}
+
+// SetSystemPrompt sets the system prompt for subsequent requests
+func (c *MockClient) SetSystemPrompt(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemPrompt = prompt
+}
+
+// LastTokens returns the token count from the last response
+func (c *MockClient) LastTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastTokens
+}
+
+// TotalTokens returns cumulative token count for this conversation
+func (c *MockClient) TotalTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalTokens
+}
+
+// ContextLimit returns the model's context window limit
+func (c *MockClient) ContextLimit() int {
+	return contextLimitForModel(c.Model())
+}
+
+// Compact replaces the conversation with a one-line summary
+func (c *MockClient) Compact(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.messages) < 4 {
+		return nil // Not enough to compact
+	}
+	summary := fmt.Sprintf("Summary of %d messages", len(c.messages))
+	c.messages = []Message{{Role: "system", Content: summary}}
+	c.totalTokens = estimateTokens(summary)
+	return nil
+}
+
+// Messages returns a copy of the conversation history
+func (c *MockClient) Messages() []Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]Message, len(c.messages))
+	copy(result, c.messages)
+	return result
+}
+
+// MessagesJSON returns the conversation history as JSON
+func (c *MockClient) MessagesJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.MarshalIndent(c.messages, "", "  ")
+}
+
+// AddSystemMessage adds a system message to the context
+func (c *MockClient) AddSystemMessage(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append([]Message{{Role: "system", Content: content}}, c.messages...)
+}
+
+// Reset clears the conversation history
+func (c *MockClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = make([]Message, 0)
+	c.lastTokens = 0
+	c.totalTokens = 0
+}
+
+// Ask answers the prompt and records both in the conversation history
+func (c *MockClient) Ask(ctx context.Context, prompt string) (string, error) {
+	if err := c.delay(ctx); err != nil {
+		return "", err
+	}
+	c.mu.RLock()
+	prefill := c.prefill
+	c.mu.RUnlock()
+	response, err := c.reply(prompt, prefill)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tokens := mockTokens(c.systemPrompt, c.messages, prompt, response)
+	c.messages = append(c.messages,
+		Message{Role: "user", Content: prompt},
+		Message{Role: "assistant", Content: response})
+	c.lastTokens = tokens
+	c.totalTokens += tokens
+	return response, nil
+}
+
+// AskWithHistory answers the prompt without touching the client's history
+func (c *MockClient) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	c.mu.RLock()
+	req := AskRequest{
+		Messages:     history,
+		Prompt:       prompt,
+		SystemPrompt: c.systemPrompt,
+		Prefill:      c.prefill,
+	}
+	c.mu.RUnlock()
+	return c.AskWithRequest(ctx, req)
+}
+
+// AskWithRequest answers the request's prompt after its prefill
+func (c *MockClient) AskWithRequest(ctx context.Context, req AskRequest) (string, int, error) {
+	if err := c.delay(ctx); err != nil {
+		return "", 0, err
+	}
+	response, err := c.reply(req.Prompt, req.Prefill)
+	if err != nil {
+		return "", 0, err
+	}
+	return response, mockTokens(req.SystemPrompt, req.Messages, req.Prompt, response), nil
+}
+
+// StartStream streams the response a word at a time
+func (c *MockClient) StartStream(ctx context.Context, prompt string) error {
+	c.mu.Lock()
+	if c.streaming {
+		c.mu.Unlock()
+		return fmt.Errorf("stream already in progress")
+	}
+	prefill := c.prefill
+	tokens := mockTokens(c.systemPrompt, c.messages, prompt, "")
+	latency, chunkDelay := c.latency, c.chunkDelay
+	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.streaming = true
+	c.streamChan = make(chan string, 100)
+	c.streamDone = make(chan struct{})
+	c.mu.Unlock()
+
+	go func() {
+		var response string
+		var finished bool
+		defer func() {
+			c.mu.Lock()
+			if finished {
+				c.messages = append(c.messages, Message{Role: "assistant", Content: response})
+				c.lastTokens = tokens + estimateTokens(response)
+				c.totalTokens += c.lastTokens
+			} else if len(c.messages) > 0 {
+				// Remove user message on error
+				c.messages = c.messages[:len(c.messages)-1]
+			}
+			c.streaming = false
+			close(c.streamChan)
+			close(c.streamDone)
+			c.mu.Unlock()
+		}()
+
+		full, err := c.reply(prompt, prefill)
+		if err == nil {
+			err = sleep(ctx, latency)
+		}
+		if err != nil {
+			select {
+			case c.streamChan <- fmt.Sprintf("\n[Error: %v]", err):
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		for i, word := range strings.SplitAfter(full, " ") {
+			if i > 0 && sleep(ctx, chunkDelay) != nil {
+				return
+			}
+			select {
+			case c.streamChan <- word:
+			case <-ctx.Done():
+				return
+			}
+		}
+		response, finished = full, true
+	}()
+	return nil
+}
+
+// ReadStreamChunk reads the next chunk from the stream
+func (c *MockClient) ReadStreamChunk() (string, bool) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+
+	if streamChan == nil {
+		return "", false
+	}
+
+	chunk, ok := <-streamChan
+	return chunk, ok
+}
+
+// ReadStreamChunkContext is ReadStreamChunk that gives up when ctx is done
+func (c *MockClient) ReadStreamChunkContext(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+	return readStreamChunk(ctx, streamChan)
+}
+
+// IsStreaming returns whether a stream is currently in progress
+func (c *MockClient) IsStreaming() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.streaming
+}
+
+// WaitStream waits for the current stream to complete
+func (c *MockClient) WaitStream() {
+	c.mu.RLock()
+	done := c.streamDone
+	c.mu.RUnlock()
+
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,184 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMockEcho(t *testing.T) {
+	c := NewMockClient()
+	req := AskRequest{
+		Messages: []Message{{Role: "user", Content: "earlier"}},
+		Prompt:   "hello world",
+		Prefill:  "[Bot] ",
+	}
+	resp, tokens, err := c.AskWithRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("AskWithRequest error: %v", err)
+	}
+	if resp != "[Bot] hello world" {
+		t.Errorf("response = %q, want prefill then prompt", resp)
+	}
+	// The same request always costs the same.
+	_, again, _ := c.AskWithRequest(context.Background(), req)
+	want := estimateTokens("earlier") + estimateTokens("hello world") + estimateTokens(resp)
+	if tokens != want || again != want {
+		t.Errorf("tokens = %d then %d, want %d", tokens, again, want)
+	}
+}
+
+func TestMockConversation(t *testing.T) {
+	c := NewMockClient()
+	ctx := context.Background()
+	for _, prompt := range []string{"one", "two"} {
+		if resp, err := c.Ask(ctx, prompt); err != nil || resp != prompt {
+			t.Fatalf("Ask(%q) = %q, %v; want an echo", prompt, resp, err)
+		}
+	}
+	if n := len(c.Messages()); n != 4 {
+		t.Fatalf("history has %d messages, want 4", n)
+	}
+	if c.LastTokens() == 0 || c.TotalTokens() <= c.LastTokens() {
+		t.Errorf("tokens last %d total %d, want both counted", c.LastTokens(), c.TotalTokens())
+	}
+
+	// AskWithRequest leaves the client's history alone.
+	if _, _, err := c.AskWithRequest(ctx, AskRequest{Prompt: "three"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Messages()); n != 4 {
+		t.Errorf("history has %d messages after AskWithRequest, want 4", n)
+	}
+
+	if err := c.Compact(ctx); err != nil {
+		t.Fatalf("Compact error: %v", err)
+	}
+	if msgs := c.Messages(); len(msgs) != 1 || msgs[0].Role != "system" {
+		t.Errorf("history after Compact = %+v, want one summary", msgs)
+	}
+	c.Reset()
+	if len(c.Messages()) != 0 || c.TotalTokens() != 0 {
+		t.Errorf("Reset left %d messages, %d tokens", len(c.Messages()), c.TotalTokens())
+	}
+
+	if err := c.SetTemperature(2.5); err == nil {
+		t.Error("SetTemperature(2.5) succeeded")
+	}
+	if err := c.SetTemperature(0.3); err != nil || c.Temperature() != 0.3 {
+		t.Errorf("SetTemperature(0.3) = %v, temperature %v", err, c.Temperature())
+	}
+}
+
+func TestMockScript(t *testing.T) {
+	yamlScript := `
+responses:
+  - match: "(?i)^hello"
+    response: "Hi there!"
+  - match: weather
+    response: |
+      It is always sunny
+      in the mock.
+`
+	jsonScript := `{"responses": [
+		{"match": "(?i)^hello", "response": "Hi there!"},
+		{"match": "weather", "response": "It is always sunny\nin the mock.\n"}
+	]}`
+
+	for name, text := range map[string]string{"yaml": yamlScript, "json": jsonScript} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "script."+name)
+			if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+				t.Fatal(err)
+			}
+			script, err := LoadMockScript(path)
+			if err != nil {
+				t.Fatalf("LoadMockScript error: %v", err)
+			}
+			c := NewMockClient()
+			c.SetScript(script)
+
+			for prompt, want := range map[string]string{
+				"HELLO, mock":           "Hi there!",
+				"what's the weather?":   "It is always sunny\nin the mock.\n",
+				"hello, how's weather?": "Hi there!", // first match wins
+			} {
+				got, err := c.Ask(context.Background(), prompt)
+				if err != nil || got != want {
+					t.Errorf("Ask(%q) = %q, %v; want %q", prompt, got, err, want)
+				}
+			}
+			if _, err := c.Ask(context.Background(), "something else"); err == nil {
+				t.Error("Ask with no matching rule succeeded")
+			}
+		})
+	}
+}
+
+func TestMockScriptErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"responses: []",
+		`responses: [{match: "(", response: x}]`,
+		"responses: {",
+	} {
+		if _, err := ParseMockScript([]byte(text)); err == nil {
+			t.Errorf("ParseMockScript(%q) succeeded", text)
+		}
+	}
+}
+
+func TestMockLatency(t *testing.T) {
+	c := NewMockClient()
+	c.SetLatency(50*time.Millisecond, 0)
+
+	start := time.Now()
+	if _, err := c.Ask(context.Background(), "slow"); err != nil {
+		t.Fatalf("Ask error: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Ask took %v, want at least the latency", d)
+	}
+
+	// The wait is cut short when the request is cancelled.
+	c.SetLatency(time.Hour, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Ask(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ask error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestMockStreamChunks(t *testing.T) {
+	c := NewMockClient()
+	c.SetLatency(10*time.Millisecond, 10*time.Millisecond)
+
+	start := time.Now()
+	if err := c.StartStream(context.Background(), "one two three"); err != nil {
+		t.Fatalf("StartStream error: %v", err)
+	}
+	var chunks []string
+	for {
+		chunk, ok := c.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	c.WaitStream()
+
+	if got := strings.Join(chunks, "|"); got != "one |two |three" {
+		t.Errorf("chunks = %q", got)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("stream took %v, want the latency and two chunk delays", d)
+	}
+	want := estimateTokens("one two three") * 2
+	if c.LastTokens() != want {
+		t.Errorf("LastTokens = %d, want %d", c.LastTokens(), want)
+	}
+}
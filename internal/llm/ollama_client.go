@@ -0,0 +1,525 @@
+// Ollama backend for local models via Ollama's HTTP API.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOllamaHost is where Ollama listens unless told otherwise.
+const DefaultOllamaHost = "http://localhost:11434"
+
+// DefaultOllamaModel is the model new Ollama clients use.
+const DefaultOllamaModel = "llama3.2"
+
+// ollamaContextLimit is the context window Ollama gives a model unless its
+// num_ctx is raised.
+const ollamaContextLimit = 4096
+
+// OllamaClient talks to a local or remote Ollama server through its
+// /api/chat endpoint. Token counts are Ollama's own.
+type OllamaClient struct {
+	host           string
+	httpClient     *http.Client
+	mu             sync.RWMutex
+	model          string
+	temperature    float64
+	systemPrompt   string
+	prefill        string // assistant response prefill for keeping model in character
+	messages       []Message
+	lastTokens     int
+	totalTokens    int // cumulative token count for context tracking
+	thinkingTokens int // stored for the Backend interface; Ollama does not use it
+	streaming      bool
+	streamChan     chan string
+	streamDone     chan struct{}
+}
+
+func init() {
+	RegisterBackend(BackendFactory{
+		Name:        "ollama",
+		Description: "Local models served by Ollama",
+		Options: []BackendOption{
+			{Name: "host", Usage: "Ollama server URL or host:port", Default: DefaultOllamaHost, Env: "OLLAMA_HOST"},
+			{Name: "model", Usage: "Model for new sessions", Default: DefaultOllamaModel, Env: "OLLAMA_MODEL"},
+		},
+		New: func(cfg BackendConfig) (Backend, error) {
+			c := NewOllamaClient(cfg["host"])
+			c.SetModel(cfg["model"])
+			return c, nil
+		},
+	})
+}
+
+// NewOllamaClient creates a client for the Ollama server at host, which
+// may be a URL or, as in OLLAMA_HOST, just host:port. An empty host means
+// DefaultOllamaHost.
+func NewOllamaClient(host string) *OllamaClient {
+	if host == "" {
+		host = DefaultOllamaHost
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return &OllamaClient{
+		host:        strings.TrimRight(host, "/"),
+		httpClient:  &http.Client{},
+		model:       DefaultOllamaModel,
+		temperature: 0.7,
+		messages:    make([]Message, 0),
+	}
+}
+
+// ollamaMessage is a chat message as Ollama sends and receives it.
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ollamaChatRequest is the body of a POST to /api/chat.
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+// ollamaOptions are the model parameters of a chat request.
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature"`
+}
+
+// ollamaChatResponse is a reply from /api/chat: the whole response, or
+// when streaming one line of it. Only the last line has Done set and the
+// token counts.
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaMessages builds the messages of a chat request. A prefill goes last
+// as a partial assistant message, which Ollama continues.
+func ollamaMessages(systemPrompt string, history []Message, prompt, prefill string) []ollamaMessage {
+	messages := make([]ollamaMessage, 0, len(history)+3)
+	if systemPrompt != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: systemPrompt})
+	}
+	for _, msg := range history {
+		messages = append(messages, ollamaMessage{Role: msg.Role, Content: msg.Content})
+	}
+	messages = append(messages, ollamaMessage{Role: "user", Content: prompt})
+	if prefill != "" {
+		messages = append(messages, ollamaMessage{Role: "assistant", Content: prefill})
+	}
+	return messages
+}
+
+// chat sends req to Ollama and returns the response text and token count.
+// If chunk is not nil the response is streamed, and chunk is called with
+// each piece as it arrives.
+func (c *OllamaClient) chat(ctx context.Context, req ollamaChatRequest, chunk func(string) error) (string, int, error) {
+	req.Stream = chunk != nil
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.host+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return "", 0, fmt.Errorf("ollama error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e ollamaChatResponse
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return "", 0, fmt.Errorf("ollama error: %s: %s", resp.Status, e.Error)
+	}
+
+	// A streamed response is one JSON object per line; an unstreamed one
+	// is a single object. The decoder reads either.
+	var text strings.Builder
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var r ollamaChatResponse
+		if err := dec.Decode(&r); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", 0, fmt.Errorf("ollama error: reading response: %w", err)
+		}
+		if r.Error != "" {
+			return "", 0, fmt.Errorf("ollama error: %s", r.Error)
+		}
+		text.WriteString(r.Message.Content)
+		if chunk != nil && r.Message.Content != "" {
+			if err := chunk(r.Message.Content); err != nil {
+				return "", 0, err
+			}
+		}
+		if r.Done {
+			RecordMetrics(r.PromptEvalCount, r.EvalCount, time.Since(startTime).Milliseconds())
+			return text.String(), r.PromptEvalCount + r.EvalCount, nil
+		}
+	}
+}
+
+// Model returns the current model name
+func (c *OllamaClient) Model() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// SetModel sets the model for subsequent requests
+func (c *OllamaClient) SetModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = model
+}
+
+// Temperature returns the current temperature
+func (c *OllamaClient) Temperature() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.temperature
+}
+
+// SetTemperature sets the temperature for subsequent requests
+func (c *OllamaClient) SetTemperature(temp float64) error {
+	if temp < 0.0 || temp > 2.0 {
+		return fmt.Errorf("temperature must be between 0.0 and 2.0")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.temperature = temp
+	return nil
+}
+
+// ThinkingTokens returns the thinking token budget
+// Note: Ollama backend does not use extended thinking
+func (c *OllamaClient) ThinkingTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.thinkingTokens
+}
+
+// SetThinkingTokens sets the thinking token budget
+// Note: Ollama backend does not use extended thinking
+func (c *OllamaClient) SetThinkingTokens(tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.thinkingTokens = tokens
+}
+
+// Prefill returns the assistant response prefill string
+func (c *OllamaClient) Prefill() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.prefill
+}
+
+// SetPrefill sets a string to prefill the assistant response
+func (c *OllamaClient) SetPrefill(prefill string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefill = prefill
+}
+
+// SystemPrompt returns the current system prompt
+func (c *OllamaClient) SystemPrompt() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.systemPrompt
+}
+
+// SetSystemPrompt sets the system prompt for subsequent requests
+func (c *OllamaClient) SetSystemPrompt(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemPrompt = prompt
+}
+
+// LastTokens returns the token count from the last response
+func (c *OllamaClient) LastTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastTokens
+}
+
+// TotalTokens returns cumulative token count for this conversation
+func (c *OllamaClient) TotalTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalTokens
+}
+
+// ContextLimit returns the model's context window limit
+func (c *OllamaClient) ContextLimit() int {
+	return ollamaContextLimit
+}
+
+// Messages returns a copy of the conversation history
+func (c *OllamaClient) Messages() []Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]Message, len(c.messages))
+	copy(result, c.messages)
+	return result
+}
+
+// MessagesJSON returns the conversation history as JSON
+func (c *OllamaClient) MessagesJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.MarshalIndent(c.messages, "", "  ")
+}
+
+// AddSystemMessage adds a system message to the context
+func (c *OllamaClient) AddSystemMessage(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append([]Message{{Role: "system", Content: content}}, c.messages...)
+}
+
+// Reset clears the conversation history
+func (c *OllamaClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = make([]Message, 0)
+	c.lastTokens = 0
+	c.totalTokens = 0
+}
+
+// Compact summarizes the conversation to reduce token usage
+func (c *OllamaClient) Compact(ctx context.Context) error {
+	c.mu.Lock()
+	if len(c.messages) < 4 {
+		c.mu.Unlock()
+		return nil // Not enough to compact
+	}
+
+	// Build conversation text for summarization
+	var conversationText string
+	for _, msg := range c.messages {
+		if msg.Role == "system" {
+			continue // Don't include system messages in summary
+		}
+		conversationText += fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content)
+	}
+	model := c.model
+	c.mu.Unlock()
+
+	summaryPrompt := "Summarize this conversation concisely, preserving key facts, decisions, and context needed to continue:\n\n" + conversationText
+	summary, tokens, err := c.chat(ctx, ollamaChatRequest{
+		Model:    model,
+		Messages: ollamaMessages("", nil, summaryPrompt, ""),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("compaction failed: %w", err)
+	}
+
+	// Replace conversation with summary
+	c.mu.Lock()
+	c.messages = []Message{{Role: "system", Content: "Previous conversation summary: " + summary}}
+	c.totalTokens = tokens
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Ask sends a prompt to the LLM and returns the response
+func (c *OllamaClient) Ask(ctx context.Context, prompt string) (string, error) {
+	c.mu.Lock()
+	req := ollamaChatRequest{
+		Model:    c.model,
+		Messages: ollamaMessages(c.systemPrompt, c.messages, prompt, ""),
+		Options:  ollamaOptions{Temperature: c.temperature},
+	}
+	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.mu.Unlock()
+
+	response, tokens, err := c.chat(ctx, req, nil)
+	if err != nil {
+		// Remove the user message on error
+		c.mu.Lock()
+		if len(c.messages) > 0 {
+			c.messages = c.messages[:len(c.messages)-1]
+		}
+		c.mu.Unlock()
+		return "", err
+	}
+
+	// Update state
+	c.mu.Lock()
+	c.messages = append(c.messages, Message{Role: "assistant", Content: response})
+	c.lastTokens = tokens
+	c.totalTokens += tokens
+	c.mu.Unlock()
+
+	return response, nil
+}
+
+// StartStream begins streaming a response for the given prompt
+func (c *OllamaClient) StartStream(ctx context.Context, prompt string) error {
+	c.mu.Lock()
+	if c.streaming {
+		c.mu.Unlock()
+		return fmt.Errorf("stream already in progress")
+	}
+
+	req := ollamaChatRequest{
+		Model:    c.model,
+		Messages: ollamaMessages(c.systemPrompt, c.messages, prompt, ""),
+		Options:  ollamaOptions{Temperature: c.temperature},
+	}
+	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+
+	c.streaming = true
+	c.streamChan = make(chan string, 100)
+	c.streamDone = make(chan struct{})
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			c.streaming = false
+			close(c.streamChan)
+			close(c.streamDone)
+			c.mu.Unlock()
+		}()
+
+		response, tokens, err := c.chat(ctx, req, func(chunk string) error {
+			select {
+			case c.streamChan <- chunk:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			// Send error as chunk
+			select {
+			case c.streamChan <- fmt.Sprintf("\n[Error: %v]", err):
+			case <-ctx.Done():
+			}
+			// Remove user message on error
+			c.mu.Lock()
+			if len(c.messages) > 0 {
+				c.messages = c.messages[:len(c.messages)-1]
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		// Update state with complete response
+		c.mu.Lock()
+		c.messages = append(c.messages, Message{Role: "assistant", Content: response})
+		c.lastTokens = tokens
+		c.totalTokens += tokens
+		c.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// ReadStreamChunk reads the next chunk from the stream, blocking until available
+// Returns empty string and false when stream is complete
+func (c *OllamaClient) ReadStreamChunk() (string, bool) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+
+	if streamChan == nil {
+		return "", false
+	}
+
+	chunk, ok := <-streamChan
+	return chunk, ok
+}
+
+// ReadStreamChunkContext is ReadStreamChunk that gives up when ctx is done
+func (c *OllamaClient) ReadStreamChunkContext(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+	return readStreamChunk(ctx, streamChan)
+}
+
+// IsStreaming returns whether a stream is currently in progress
+func (c *OllamaClient) IsStreaming() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.streaming
+}
+
+// WaitStream waits for the current stream to complete
+func (c *OllamaClient) WaitStream() {
+	c.mu.RLock()
+	done := c.streamDone
+	c.mu.RUnlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// AskWithHistory sends a prompt with explicit message history for per-fid isolation.
+// Unlike Ask(), this does not modify the client's internal messages state.
+func (c *OllamaClient) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	c.mu.RLock()
+	req := AskRequest{
+		Messages:     history,
+		Prompt:       prompt,
+		Model:        c.model,
+		Temperature:  c.temperature,
+		SystemPrompt: c.systemPrompt,
+		Prefill:      c.prefill,
+	}
+	c.mu.RUnlock()
+	return c.AskWithRequest(ctx, req)
+}
+
+// AskWithRequest sends a prompt with all settings from the request (CSP - no client state).
+// The system prompt, prefill and temperature map onto the chat request.
+func (c *OllamaClient) AskWithRequest(ctx context.Context, req AskRequest) (string, int, error) {
+	// Use model from request, or fall back to client default
+	model := req.Model
+	if model == "" {
+		model = c.Model()
+	}
+
+	response, tokens, err := c.chat(ctx, ollamaChatRequest{
+		Model:    model,
+		Messages: ollamaMessages(req.SystemPrompt, req.Messages, req.Prompt, req.Prefill),
+		Options:  ollamaOptions{Temperature: req.Temperature},
+	}, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Ollama returns only the continuation of a prefill
+	if req.Prefill != "" && !strings.HasPrefix(response, req.Prefill) {
+		response = req.Prefill + response
+	}
+	return response, tokens, nil
+}
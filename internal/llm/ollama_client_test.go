@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeOllama stands in for Ollama's /api/chat. It answers with the given
+// pieces, one NDJSON line each when the request asks to stream, and
+// records the last request.
+func fakeOllama(t *testing.T, pieces ...string) (*httptest.Server, *ollamaChatRequest) {
+	t.Helper()
+	var last ollamaChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&last); err != nil {
+			http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		if last.Model == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model 'missing' not found"}`)
+			return
+		}
+		enc := json.NewEncoder(w)
+		if !last.Stream {
+			enc.Encode(ollamaChatResponse{
+				Message:         ollamaMessage{Role: "assistant", Content: strings.Join(pieces, "")},
+				Done:            true,
+				PromptEvalCount: 12,
+				EvalCount:       34,
+			})
+			return
+		}
+		for _, p := range pieces {
+			enc.Encode(ollamaChatResponse{Message: ollamaMessage{Role: "assistant", Content: p}})
+			w.(http.Flusher).Flush()
+		}
+		enc.Encode(ollamaChatResponse{Done: true, PromptEvalCount: 12, EvalCount: 34})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last
+}
+
+func TestOllamaAskWithRequest(t *testing.T) {
+	srv, last := fakeOllama(t, "Ahoy, ", "matey")
+	c := NewOllamaClient(srv.URL)
+
+	resp, tokens, err := c.AskWithRequest(context.Background(), AskRequest{
+		Messages:     []Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+		Prompt:       "greet me",
+		Model:        "llama3.2",
+		Temperature:  0.3,
+		SystemPrompt: "You are a pirate.",
+		Prefill:      "[Pirate] ",
+	})
+	if err != nil {
+		t.Fatalf("AskWithRequest error: %v", err)
+	}
+	if resp != "[Pirate] Ahoy, matey" {
+		t.Errorf("response = %q, want prefill then reply", resp)
+	}
+	if tokens != 46 {
+		t.Errorf("tokens = %d, want prompt_eval_count + eval_count = 46", tokens)
+	}
+
+	if last.Model != "llama3.2" || last.Stream || last.Options.Temperature != 0.3 {
+		t.Errorf("request model %q stream %v temperature %v", last.Model, last.Stream, last.Options.Temperature)
+	}
+	want := []ollamaMessage{
+		{"system", "You are a pirate."},
+		{"user", "hi"},
+		{"assistant", "hello"},
+		{"user", "greet me"},
+		{"assistant", "[Pirate] "},
+	}
+	if fmt.Sprint(last.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %v, want %v", last.Messages, want)
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	srv, last := fakeOllama(t, "one ", "two ", "three")
+	c := NewOllamaClient(srv.URL)
+
+	if err := c.StartStream(context.Background(), "count"); err != nil {
+		t.Fatalf("StartStream error: %v", err)
+	}
+	var chunks []string
+	for {
+		chunk, ok := c.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	c.WaitStream()
+
+	if !last.Stream {
+		t.Error("StartStream did not ask Ollama to stream")
+	}
+	if got := strings.Join(chunks, "|"); got != "one |two |three" {
+		t.Errorf("chunks = %q", got)
+	}
+	if c.LastTokens() != 46 || c.TotalTokens() != 46 {
+		t.Errorf("tokens last %d total %d, want 46", c.LastTokens(), c.TotalTokens())
+	}
+	msgs := c.Messages()
+	if len(msgs) != 2 || msgs[1].Content != "one two three" {
+		t.Errorf("history = %v", msgs)
+	}
+}
+
+func TestOllamaError(t *testing.T) {
+	srv, _ := fakeOllama(t)
+	c := NewOllamaClient(srv.URL)
+	c.SetModel("missing")
+
+	_, err := c.Ask(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "model 'missing' not found") {
+		t.Errorf("Ask error = %v, want Ollama's message", err)
+	}
+	if n := len(c.Messages()); n != 0 {
+		t.Errorf("history has %d messages after a failed ask, want 0", n)
+	}
+}
+
+func TestNewOllamaClientHost(t *testing.T) {
+	tests := []struct{ host, want string }{
+		{"", DefaultOllamaHost},
+		{"127.0.0.1:11434", "http://127.0.0.1:11434"},
+		{"https://ollama.example/", "https://ollama.example"},
+	}
+	for _, tc := range tests {
+		if got := NewOllamaClient(tc.host).host; got != tc.want {
+			t.Errorf("NewOllamaClient(%q).host = %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,616 @@
+// OpenAI-compatible backend for servers that speak /v1/chat/completions,
+// such as vLLM and llama.cpp.
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOpenAIBaseURL is the base URL used when none is given.
+const DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// DefaultOpenAIModel is the model new OpenAI-compatible clients use.
+const DefaultOpenAIModel = "gpt-4o-mini"
+
+// openAIContextLimit is a conservative guess at the context window, which
+// self-hosted servers do not report.
+const openAIContextLimit = 8192
+
+// OpenAIClient talks to any server implementing the OpenAI chat
+// completions API. Token counts are the server's, where it reports them.
+type OpenAIClient struct {
+	baseURL        string
+	apiKey         string
+	httpClient     *http.Client
+	mu             sync.RWMutex
+	model          string
+	temperature    float64
+	maxTokens      int
+	systemPrompt   string
+	prefill        string // assistant response prefill for keeping model in character
+	messages       []Message
+	lastTokens     int
+	totalTokens    int // cumulative token count for context tracking
+	thinkingTokens int // stored for the Backend interface; not sent
+	streaming      bool
+	streamChan     chan string
+	streamDone     chan struct{}
+}
+
+func init() {
+	RegisterBackend(BackendFactory{
+		Name:        "openai",
+		Description: "OpenAI-compatible chat completions servers, such as vLLM and llama.cpp",
+		Options: []BackendOption{
+			{Name: "base-url", Usage: "Base URL of the API, e.g. http://localhost:8000/v1", Default: DefaultOpenAIBaseURL, Env: "OPENAI_BASE_URL"},
+			{Name: "api-key", Usage: "Bearer token, if the server wants one", Env: "OPENAI_API_KEY"},
+			{Name: "model", Usage: "Model for new sessions", Default: DefaultOpenAIModel, Env: "OPENAI_MODEL"},
+			{Name: "max-tokens", Usage: "Most tokens in a response (0 for the server's default)", Default: "4096"},
+		},
+		New: func(cfg BackendConfig) (Backend, error) {
+			maxTokens, err := cfg.Int("max-tokens")
+			if err != nil {
+				return nil, err
+			}
+			c := NewOpenAIClient(cfg["base-url"], cfg["api-key"])
+			c.SetModel(cfg["model"])
+			c.SetMaxTokens(maxTokens)
+			return c, nil
+		},
+	})
+}
+
+// NewOpenAIClient creates a client for the chat completions API at
+// baseURL, such as "http://localhost:8000/v1" for vLLM. An empty baseURL
+// means DefaultOpenAIBaseURL; an empty apiKey sends no Authorization
+// header, which is what most self-hosted servers expect.
+func NewOpenAIClient(baseURL, apiKey string) *OpenAIClient {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	return &OpenAIClient{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		apiKey:      apiKey,
+		httpClient:  &http.Client{},
+		model:       DefaultOpenAIModel,
+		temperature: 0.7,
+		maxTokens:   4096,
+		messages:    make([]Message, 0),
+	}
+}
+
+// openAIMessage is a chat message in a request or response.
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// openAIChatRequest is the body of a POST to /chat/completions.
+type openAIChatRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Temperature   float64              `json:"temperature"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// openAIStreamOptions asks for usage in the last chunk of a stream.
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIUsage is the token usage of a completion.
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// openAIError is the error object the API returns.
+type openAIError struct {
+	Message string `json:"message"`
+}
+
+// openAIChatResponse is a completion, or when streaming one chunk of it,
+// in which case each choice has a Delta rather than a Message.
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error *openAIError `json:"error"`
+}
+
+// openAIMessages builds the messages of a chat request: the system prompt,
+// then the history in order, including any system messages in it, then the
+// prompt.
+func openAIMessages(systemPrompt string, history []Message, prompt string) []openAIMessage {
+	messages := make([]openAIMessage, 0, len(history)+2)
+	if systemPrompt != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: systemPrompt})
+	}
+	for _, msg := range history {
+		messages = append(messages, openAIMessage{Role: msg.Role, Content: msg.Content})
+	}
+	return append(messages, openAIMessage{Role: "user", Content: prompt})
+}
+
+// chat sends req and returns the response text and token count. If chunk
+// is not nil the response is streamed as server-sent events, and chunk is
+// called with each piece as it arrives.
+func (c *OpenAIClient) chat(ctx context.Context, req openAIChatRequest, chunk func(string) error) (string, int, error) {
+	if chunk != nil {
+		req.Stream = true
+		req.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return "", 0, fmt.Errorf("API error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e openAIChatResponse
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil && e.Error != nil {
+			msg = e.Error.Message
+		}
+		return "", 0, fmt.Errorf("API error: %s: %s", resp.Status, msg)
+	}
+
+	var text strings.Builder
+	var usage *openAIUsage
+	if chunk == nil {
+		var r openAIChatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return "", 0, fmt.Errorf("API error: reading response: %w", err)
+		}
+		if r.Error != nil {
+			return "", 0, fmt.Errorf("API error: %s", r.Error.Message)
+		}
+		if len(r.Choices) > 0 {
+			text.WriteString(r.Choices[0].Message.Content)
+		}
+		usage = r.Usage
+	} else {
+		// Each event is a "data: {json}" line; "data: [DONE]" ends the stream.
+		sc := bufio.NewScanner(resp.Body)
+		sc.Buffer(nil, 1024*1024)
+		done := false
+		for !done && sc.Scan() {
+			data, ok := strings.CutPrefix(sc.Text(), "data:")
+			if !ok {
+				continue // blank separators, comments, event names
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				done = true
+				break
+			}
+			var r openAIChatResponse
+			if err := json.Unmarshal([]byte(data), &r); err != nil {
+				return "", 0, fmt.Errorf("API error: bad stream event: %w", err)
+			}
+			if r.Error != nil {
+				return "", 0, fmt.Errorf("API error: %s", r.Error.Message)
+			}
+			if r.Usage != nil {
+				usage = r.Usage
+			}
+			if len(r.Choices) > 0 && r.Choices[0].Delta.Content != "" {
+				piece := r.Choices[0].Delta.Content
+				text.WriteString(piece)
+				if err := chunk(piece); err != nil {
+					return "", 0, err
+				}
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return "", 0, fmt.Errorf("API error: reading stream: %w", err)
+		}
+		if !done {
+			return "", 0, fmt.Errorf("API error: reading stream: %w", io.ErrUnexpectedEOF)
+		}
+	}
+
+	// Not every server reports usage; estimate when it does not.
+	if usage == nil {
+		var prompt int
+		for _, m := range req.Messages {
+			prompt += estimateTokens(m.Content)
+		}
+		usage = &openAIUsage{PromptTokens: prompt, CompletionTokens: estimateTokens(text.String())}
+	}
+	RecordMetrics(usage.PromptTokens, usage.CompletionTokens, time.Since(startTime).Milliseconds())
+	return text.String(), usage.PromptTokens + usage.CompletionTokens, nil
+}
+
+// Model returns the current model name
+func (c *OpenAIClient) Model() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// SetModel sets the model for subsequent requests
+func (c *OpenAIClient) SetModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = model
+}
+
+// Temperature returns the current temperature
+func (c *OpenAIClient) Temperature() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.temperature
+}
+
+// SetTemperature sets the temperature for subsequent requests
+func (c *OpenAIClient) SetTemperature(temp float64) error {
+	if temp < 0.0 || temp > 2.0 {
+		return fmt.Errorf("temperature must be between 0.0 and 2.0")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.temperature = temp
+	return nil
+}
+
+// MaxTokens returns the most tokens a response may have
+func (c *OpenAIClient) MaxTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxTokens
+}
+
+// SetMaxTokens sets the most tokens a response may have (0 for the server's default)
+func (c *OpenAIClient) SetMaxTokens(tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxTokens = tokens
+}
+
+// ThinkingTokens returns the thinking token budget
+// Note: OpenAI-compatible backend does not use extended thinking
+func (c *OpenAIClient) ThinkingTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.thinkingTokens
+}
+
+// SetThinkingTokens sets the thinking token budget
+// Note: OpenAI-compatible backend does not use extended thinking
+func (c *OpenAIClient) SetThinkingTokens(tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.thinkingTokens = tokens
+}
+
+// Prefill returns the assistant response prefill string
+func (c *OpenAIClient) Prefill() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.prefill
+}
+
+// SetPrefill sets a string to prefill the assistant response
+func (c *OpenAIClient) SetPrefill(prefill string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefill = prefill
+}
+
+// SystemPrompt returns the current system prompt
+func (c *OpenAIClient) SystemPrompt() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.systemPrompt
+}
+
+// SetSystemPrompt sets the system prompt for subsequent requests
+func (c *OpenAIClient) SetSystemPrompt(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemPrompt = prompt
+}
+
+// LastTokens returns the token count from the last response
+func (c *OpenAIClient) LastTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastTokens
+}
+
+// TotalTokens returns cumulative token count for this conversation
+func (c *OpenAIClient) TotalTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalTokens
+}
+
+// ContextLimit returns the model's context window limit
+func (c *OpenAIClient) ContextLimit() int {
+	return openAIContextLimit
+}
+
+// Messages returns a copy of the conversation history
+func (c *OpenAIClient) Messages() []Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]Message, len(c.messages))
+	copy(result, c.messages)
+	return result
+}
+
+// MessagesJSON returns the conversation history as JSON
+func (c *OpenAIClient) MessagesJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.MarshalIndent(c.messages, "", "  ")
+}
+
+// AddSystemMessage adds a system message to the context
+func (c *OpenAIClient) AddSystemMessage(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append([]Message{{Role: "system", Content: content}}, c.messages...)
+}
+
+// Reset clears the conversation history
+func (c *OpenAIClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = make([]Message, 0)
+	c.lastTokens = 0
+	c.totalTokens = 0
+}
+
+// Compact summarizes the conversation to reduce token usage
+func (c *OpenAIClient) Compact(ctx context.Context) error {
+	c.mu.Lock()
+	if len(c.messages) < 4 {
+		c.mu.Unlock()
+		return nil // Not enough to compact
+	}
+
+	// Build conversation text for summarization
+	var conversationText string
+	for _, msg := range c.messages {
+		if msg.Role == "system" {
+			continue // Don't include system messages in summary
+		}
+		conversationText += fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content)
+	}
+	model := c.model
+	c.mu.Unlock()
+
+	summaryPrompt := "Summarize this conversation concisely, preserving key facts, decisions, and context needed to continue:\n\n" + conversationText
+	summary, tokens, err := c.chat(ctx, openAIChatRequest{
+		Model:     model,
+		Messages:  openAIMessages("", nil, summaryPrompt),
+		MaxTokens: 2048,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("compaction failed: %w", err)
+	}
+
+	// Replace conversation with summary
+	c.mu.Lock()
+	c.messages = []Message{{Role: "system", Content: "Previous conversation summary: " + summary}}
+	c.totalTokens = tokens
+	c.mu.Unlock()
+
+	return nil
+}
+
+// request builds a chat request from the client's settings and history.
+// It is called with c.mu held.
+func (c *OpenAIClient) request(prompt string) openAIChatRequest {
+	return openAIChatRequest{
+		Model:       c.model,
+		Messages:    openAIMessages(c.systemPrompt, c.messages, prompt),
+		Temperature: c.temperature,
+		MaxTokens:   c.maxTokens,
+	}
+}
+
+// Ask sends a prompt to the LLM and returns the response
+func (c *OpenAIClient) Ask(ctx context.Context, prompt string) (string, error) {
+	c.mu.Lock()
+	req := c.request(prompt)
+	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.mu.Unlock()
+
+	response, tokens, err := c.chat(ctx, req, nil)
+	if err != nil {
+		// Remove the user message on error
+		c.mu.Lock()
+		if len(c.messages) > 0 {
+			c.messages = c.messages[:len(c.messages)-1]
+		}
+		c.mu.Unlock()
+		return "", err
+	}
+
+	// Update state
+	c.mu.Lock()
+	c.messages = append(c.messages, Message{Role: "assistant", Content: response})
+	c.lastTokens = tokens
+	c.totalTokens += tokens
+	c.mu.Unlock()
+
+	return response, nil
+}
+
+// StartStream begins streaming a response for the given prompt
+func (c *OpenAIClient) StartStream(ctx context.Context, prompt string) error {
+	c.mu.Lock()
+	if c.streaming {
+		c.mu.Unlock()
+		return fmt.Errorf("stream already in progress")
+	}
+
+	req := c.request(prompt)
+	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+
+	c.streaming = true
+	c.streamChan = make(chan string, 100)
+	c.streamDone = make(chan struct{})
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			c.streaming = false
+			close(c.streamChan)
+			close(c.streamDone)
+			c.mu.Unlock()
+		}()
+
+		response, tokens, err := c.chat(ctx, req, func(chunk string) error {
+			select {
+			case c.streamChan <- chunk:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			// Send error as chunk
+			select {
+			case c.streamChan <- fmt.Sprintf("\n[Error: %v]", err):
+			case <-ctx.Done():
+			}
+			// Remove user message on error
+			c.mu.Lock()
+			if len(c.messages) > 0 {
+				c.messages = c.messages[:len(c.messages)-1]
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		// Update state with complete response
+		c.mu.Lock()
+		c.messages = append(c.messages, Message{Role: "assistant", Content: response})
+		c.lastTokens = tokens
+		c.totalTokens += tokens
+		c.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// ReadStreamChunk reads the next chunk from the stream, blocking until available
+// Returns empty string and false when stream is complete
+func (c *OpenAIClient) ReadStreamChunk() (string, bool) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+
+	if streamChan == nil {
+		return "", false
+	}
+
+	chunk, ok := <-streamChan
+	return chunk, ok
+}
+
+// ReadStreamChunkContext is ReadStreamChunk that gives up when ctx is done
+func (c *OpenAIClient) ReadStreamChunkContext(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+	return readStreamChunk(ctx, streamChan)
+}
+
+// IsStreaming returns whether a stream is currently in progress
+func (c *OpenAIClient) IsStreaming() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.streaming
+}
+
+// WaitStream waits for the current stream to complete
+func (c *OpenAIClient) WaitStream() {
+	c.mu.RLock()
+	done := c.streamDone
+	c.mu.RUnlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// AskWithHistory sends a prompt with explicit message history for per-fid isolation.
+// Unlike Ask(), this does not modify the client's internal messages state.
+func (c *OpenAIClient) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	c.mu.RLock()
+	req := AskRequest{
+		Messages:     history,
+		Prompt:       prompt,
+		Model:        c.model,
+		Temperature:  c.temperature,
+		SystemPrompt: c.systemPrompt,
+		Prefill:      c.prefill,
+	}
+	c.mu.RUnlock()
+	return c.AskWithRequest(ctx, req)
+}
+
+// AskWithRequest sends a prompt with all settings from the request (CSP - no client state).
+// The chat completions API has no portable way to continue a partial
+// assistant message, so a prefill is prepended to the response, as the
+// CLI client does.
+func (c *OpenAIClient) AskWithRequest(ctx context.Context, req AskRequest) (string, int, error) {
+	c.mu.RLock()
+	model := c.model
+	maxTokens := c.maxTokens
+	c.mu.RUnlock()
+
+	// Use model from request, or fall back to client default
+	if req.Model != "" {
+		model = req.Model
+	}
+
+	response, tokens, err := c.chat(ctx, openAIChatRequest{
+		Model:       model,
+		Messages:    openAIMessages(req.SystemPrompt, req.Messages, req.Prompt),
+		Temperature: req.Temperature,
+		MaxTokens:   maxTokens,
+	}, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if req.Prefill != "" && !strings.HasPrefix(response, req.Prefill) {
+		response = req.Prefill + response
+	}
+	return response, tokens, nil
+}
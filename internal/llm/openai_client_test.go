@@ -0,0 +1,167 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeOpenAI stands in for a chat completions server. It answers with the
+// given pieces, one server-sent event each when the request asks to
+// stream, and records the last request and its Authorization header.
+func fakeOpenAI(t *testing.T, usage bool, pieces ...string) (*httptest.Server, *openAIChatRequest, *string) {
+	t.Helper()
+	var last openAIChatRequest
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		last = openAIChatRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&last); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if last.Model == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"message":"The model 'missing' does not exist.","type":"NotFoundError"}}`)
+			return
+		}
+		u := `null`
+		if usage {
+			u = `{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}`
+		}
+		if !last.Stream {
+			content, _ := json.Marshal(strings.Join(pieces, ""))
+			fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":%s},"finish_reason":"stop"}],"usage":%s}`, content, u)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		for _, p := range pieces {
+			content, _ := json.Marshal(p)
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%s}}]}\n\n", content)
+			w.(http.Flusher).Flush()
+		}
+		if last.StreamOptions != nil && last.StreamOptions.IncludeUsage {
+			fmt.Fprintf(w, "data: {\"choices\":[],\"usage\":%s}\n\n", u)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last, &auth
+}
+
+func TestOpenAIAskWithRequest(t *testing.T) {
+	srv, last, auth := fakeOpenAI(t, true, "Ahoy, ", "matey")
+	c := NewOpenAIClient(srv.URL+"/v1/", "sk-test")
+	c.SetMaxTokens(256)
+
+	resp, tokens, err := c.AskWithRequest(context.Background(), AskRequest{
+		Messages: []Message{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+			{Role: "system", Content: "Previous conversation summary: greetings"},
+		},
+		Prompt:       "greet me",
+		Model:        "qwen2.5-7b",
+		Temperature:  0.3,
+		SystemPrompt: "You are a pirate.",
+		Prefill:      "[Pirate] ",
+	})
+	if err != nil {
+		t.Fatalf("AskWithRequest error: %v", err)
+	}
+	if resp != "[Pirate] Ahoy, matey" {
+		t.Errorf("response = %q, want prefill then reply", resp)
+	}
+	if tokens != 15 {
+		t.Errorf("tokens = %d, want 15 from usage", tokens)
+	}
+	if *auth != "Bearer sk-test" {
+		t.Errorf("Authorization = %q", *auth)
+	}
+	if last.Model != "qwen2.5-7b" || last.Temperature != 0.3 || last.MaxTokens != 256 || last.Stream {
+		t.Errorf("request model %q temperature %v max_tokens %d stream %v", last.Model, last.Temperature, last.MaxTokens, last.Stream)
+	}
+
+	// System messages from the history keep their place in it.
+	want := []openAIMessage{
+		{"system", "You are a pirate."},
+		{"user", "hi"},
+		{"assistant", "hello"},
+		{"system", "Previous conversation summary: greetings"},
+		{"user", "greet me"},
+	}
+	if fmt.Sprint(last.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %q, want %q", last.Messages, want)
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	srv, last, auth := fakeOpenAI(t, true, "one ", "two ", "three")
+	c := NewOpenAIClient(srv.URL+"/v1", "")
+
+	if err := c.StartStream(context.Background(), "count"); err != nil {
+		t.Fatalf("StartStream error: %v", err)
+	}
+	var chunks []string
+	for {
+		chunk, ok := c.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	c.WaitStream()
+
+	if !last.Stream {
+		t.Error("StartStream did not ask to stream")
+	}
+	if *auth != "" {
+		t.Errorf("Authorization = %q with no API key", *auth)
+	}
+	if got := strings.Join(chunks, "|"); got != "one |two |three" {
+		t.Errorf("chunks = %q", got)
+	}
+	if c.LastTokens() != 15 {
+		t.Errorf("LastTokens = %d, want 15 from usage", c.LastTokens())
+	}
+	msgs := c.Messages()
+	if len(msgs) != 2 || msgs[1].Content != "one two three" {
+		t.Errorf("history = %v", msgs)
+	}
+}
+
+func TestOpenAINoUsage(t *testing.T) {
+	srv, _, _ := fakeOpenAI(t, false, "twelve chars")
+	c := NewOpenAIClient(srv.URL+"/v1", "")
+
+	_, tokens, err := c.AskWithRequest(context.Background(), AskRequest{Prompt: "four"})
+	if err != nil {
+		t.Fatalf("AskWithRequest error: %v", err)
+	}
+	if want := estimateTokens("four") + estimateTokens("twelve chars"); tokens != want {
+		t.Errorf("tokens = %d, want estimate %d", tokens, want)
+	}
+}
+
+func TestOpenAIError(t *testing.T) {
+	srv, _, _ := fakeOpenAI(t, true)
+	c := NewOpenAIClient(srv.URL+"/v1", "")
+	c.SetModel("missing")
+
+	_, err := c.Ask(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "The model 'missing' does not exist.") {
+		t.Errorf("Ask error = %v, want the server's message", err)
+	}
+	if n := len(c.Messages()); n != 0 {
+		t.Errorf("history has %d messages after a failed ask, want 0", n)
+	}
+}
@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackendOption describes one setting a backend accepts.
+type BackendOption struct {
+	Name     string // as given in configuration, e.g. "host"
+	Usage    string
+	Default  string
+	Env      string // environment variable read when the option is not given
+	Required bool   // if set, the backend cannot be built without a value
+}
+
+// BackendConfig holds a backend's settings by option name.
+type BackendConfig map[string]string
+
+// BackendFactory describes a backend and builds it from its settings.
+type BackendFactory struct {
+	Name        string
+	Description string
+	Options     []BackendOption
+
+	// New builds the backend. The config it is given has a value for
+	// every option, from the caller, the environment or the default,
+	// possibly empty if the option is not required.
+	New func(cfg BackendConfig) (Backend, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]BackendFactory)
+)
+
+// RegisterBackend makes a backend available by name. Backends register
+// themselves from init functions; registering a name twice panics.
+func RegisterBackend(f BackendFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f.Name == "" || f.New == nil {
+		panic("llm: RegisterBackend needs a name and a constructor")
+	}
+	if _, dup := registry[f.Name]; dup {
+		panic("llm: RegisterBackend called twice for " + f.Name)
+	}
+	registry[f.Name] = f
+}
+
+// Backends returns the registered backends, sorted by name.
+func Backends() []BackendFactory {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]BackendFactory, 0, len(registry))
+	for _, f := range registry {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// LookupBackend returns the backend registered as name.
+func LookupBackend(name string) (BackendFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[name]
+	return f, ok
+}
+
+// NewBackend builds the backend registered as name. Options missing from
+// cfg are taken from their environment variables, then their defaults.
+// Unknown options and missing required ones are errors.
+func NewBackend(name string, cfg BackendConfig) (Backend, error) {
+	f, ok := LookupBackend(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	resolved, err := f.resolve(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", name, err)
+	}
+	b, err := f.New(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// resolve fills in cfg from the environment and defaults and checks it
+// against f's options.
+func (f BackendFactory) resolve(cfg BackendConfig) (BackendConfig, error) {
+	known := make(map[string]bool, len(f.Options))
+	resolved := make(BackendConfig, len(f.Options))
+	for _, opt := range f.Options {
+		known[opt.Name] = true
+		v, ok := cfg[opt.Name]
+		if !ok && opt.Env != "" {
+			v, ok = os.LookupEnv(opt.Env)
+		}
+		if !ok || (v == "" && opt.Default != "") {
+			v = opt.Default
+		}
+		if v == "" && opt.Required {
+			if opt.Env != "" {
+				return nil, fmt.Errorf("%s is required (set it or %s)", opt.Name, opt.Env)
+			}
+			return nil, fmt.Errorf("%s is required", opt.Name)
+		}
+		resolved[opt.Name] = v
+	}
+	for name := range cfg {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	return resolved, nil
+}
+
+// Int returns the option name as an integer, or 0 if it is empty.
+func (c BackendConfig) Int(name string) (int, error) {
+	if c[name] == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(c[name]))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", name, c[name])
+	}
+	return n, nil
+}
+
+// Duration returns the option name as a duration such as "500ms", or 0
+// if it is empty.
+func (c BackendConfig) Duration(name string) (time.Duration, error) {
+	if c[name] == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(c[name]))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a duration", name, c[name])
+	}
+	return d, nil
+}
@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBackends(t *testing.T) {
+	var names []string
+	for _, f := range Backends() {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "api cli mock ollama openai" {
+		t.Errorf("Backends() = %s", got)
+	}
+}
+
+func TestNewBackend_Options(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "envhost:1234")
+	t.Setenv("OLLAMA_MODEL", "")
+
+	// The environment fills in what the config leaves out; an empty
+	// variable means the default.
+	b, err := NewBackend("ollama", nil)
+	if err != nil {
+		t.Fatalf("NewBackend error: %v", err)
+	}
+	c := b.(*OllamaClient)
+	if c.host != "http://envhost:1234" || c.Model() != DefaultOllamaModel {
+		t.Errorf("host %q model %q, want envhost and the default model", c.host, c.Model())
+	}
+
+	// The config wins over the environment.
+	b, err = NewBackend("ollama", BackendConfig{"host": "cfghost:1", "model": "qwen2.5:7b"})
+	if err != nil {
+		t.Fatalf("NewBackend error: %v", err)
+	}
+	if c := b.(*OllamaClient); c.host != "http://cfghost:1" || c.Model() != "qwen2.5:7b" {
+		t.Errorf("host %q model %q, want the configured ones", c.host, c.Model())
+	}
+}
+
+func TestNewBackend_Errors(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	tests := []struct {
+		name string
+		cfg  BackendConfig
+		want string
+	}{
+		{"nonesuch", nil, "unknown backend"},
+		{"api", nil, "api-key is required"},
+		{"mock", BackendConfig{"colour": "blue"}, `unknown option "colour"`},
+		{"mock", BackendConfig{"latency": "soon"}, "not a duration"},
+		{"openai", BackendConfig{"max-tokens": "lots"}, "not a number"},
+	}
+	for _, tc := range tests {
+		_, err := NewBackend(tc.name, tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("NewBackend(%s, %v) error = %v, want %q", tc.name, tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestRegisterBackend_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering mock twice did not panic")
+		}
+	}()
+	RegisterBackend(BackendFactory{Name: "mock", New: func(BackendConfig) (Backend, error) { return nil, nil }})
+}
@@ -4,7 +4,10 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // SessionDefaults are copied to new sessions at creation time.
@@ -31,6 +34,8 @@ func DefaultSessionDefaults() SessionDefaults {
 // Each session is a complete, isolated unit with no shared mutable state.
 type Session struct {
 	ID           int
+	name         string // set for named sessions; empty otherwise
+	owner        string // user the session belongs to; empty if shared
 	messages     []Message
 	lastResponse string
 	lastTokens   int
@@ -45,6 +50,16 @@ type Session struct {
 
 	mu     sync.RWMutex
 	closed bool
+
+	// version counts changes to the session's state, and modified is
+	// when the last one happened, so files can tell clients that cache
+	// them when their contents change.
+	version  uint32
+	modified time.Time
+
+	// askSem is held while a request is in flight, so asks on one
+	// session run one at a time and each sees the history of the last.
+	askSem chan struct{}
 }
 
 // NewSession creates a new session with the given ID and defaults.
@@ -57,9 +72,53 @@ func NewSession(id int, defaults SessionDefaults) *Session {
 		systemPrompt:   defaults.SystemPrompt,
 		thinkingTokens: defaults.ThinkingTokens,
 		prefill:        defaults.Prefill,
+		modified:       time.Now(),
+		askSem:         make(chan struct{}, 1),
+	}
+}
+
+// changed records a change to the session's state. It is called with
+// s.mu held.
+func (s *Session) changed() {
+	s.version++
+	s.modified = time.Now()
+}
+
+// Version returns a number that changes whenever the session's history,
+// response or settings change, and the time of the latest change.
+func (s *Session) Version() (uint32, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version, s.modified
+}
+
+// acquire waits until no other request is in flight on the session.
+func (s *Session) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case s.askSem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+func (s *Session) release() {
+	<-s.askSem
+}
+
+// WaitIdle blocks until any in-flight request on the session has finished,
+// so that LastResponse reflects the most recent prompt.
+func (s *Session) WaitIdle(ctx context.Context) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	s.release()
+	return nil
+}
+
 // Messages returns a copy of the session's conversation history.
 func (s *Session) Messages() []Message {
 	s.mu.RLock()
@@ -81,6 +140,7 @@ func (s *Session) AddMessage(role, content string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.messages = append(s.messages, Message{Role: role, Content: content})
+	s.changed()
 }
 
 // SetLastResponse sets the last response for this session.
@@ -88,6 +148,7 @@ func (s *Session) SetLastResponse(response string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.lastResponse = response
+	s.changed()
 }
 
 // LastResponse returns the last response for this session.
@@ -110,6 +171,7 @@ func (s *Session) AddTokens(tokens int) {
 	defer s.mu.Unlock()
 	s.lastTokens = tokens
 	s.totalTokens += tokens
+	s.changed()
 }
 
 // Reset clears the session's conversation history but keeps settings.
@@ -120,6 +182,22 @@ func (s *Session) Reset() {
 	s.lastResponse = ""
 	s.lastTokens = 0
 	s.totalTokens = 0
+	s.changed()
+}
+
+// Name returns the session's name, or "" if it is known only by its ID.
+func (s *Session) Name() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.name
+}
+
+// Owner returns the user the session was created for, or "" if it was
+// created outside any user's namespace.
+func (s *Session) Owner() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.owner
 }
 
 // Model returns the session's model setting.
@@ -134,6 +212,7 @@ func (s *Session) SetModel(model string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.model = model
+	s.changed()
 }
 
 // Temperature returns the session's temperature setting.
@@ -148,6 +227,7 @@ func (s *Session) SetTemperature(temp float64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.temperature = temp
+	s.changed()
 }
 
 // SystemPrompt returns the session's system prompt.
@@ -162,6 +242,7 @@ func (s *Session) SetSystemPrompt(prompt string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.systemPrompt = prompt
+	s.changed()
 }
 
 // ThinkingTokens returns the session's thinking token budget.
@@ -176,6 +257,7 @@ func (s *Session) SetThinkingTokens(tokens int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.thinkingTokens = tokens
+	s.changed()
 }
 
 // Prefill returns the session's prefill string.
@@ -190,6 +272,7 @@ func (s *Session) SetPrefill(prefill string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.prefill = prefill
+	s.changed()
 }
 
 // IsClosed returns whether the session has been closed.
@@ -203,20 +286,108 @@ func (s *Session) IsClosed() bool {
 // The APIClient is stateless - all conversation state is in sessions.
 type SessionManager struct {
 	sessions  map[int]*Session
+	names     map[sessionKey]int // named sessions
 	nextID    int
-	apiClient Backend         // Stateless API caller
-	defaults  SessionDefaults // Defaults for new sessions
+	apiClient Backend            // Stateless API caller, for models without a backend prefix
+	backends  map[string]Backend // More backends, for models named "backend/model"
+	defaults  SessionDefaults    // Defaults for new sessions
 	mu        sync.RWMutex
+
+	// listed counts sessions created, renamed and closed, overall and
+	// for each owner, so that directories listing them can tell clients
+	// that cache them when the listing changes.
+	listed        listVersion
+	listedByOwner map[string]listVersion
+}
+
+// listVersion is a count of changes and the time of the latest.
+type listVersion struct {
+	version  uint32
+	modified time.Time
+}
+
+// sessionKey identifies a named session. Each owner has names of their
+// own, so one user's names neither clash with nor reveal another's.
+type sessionKey struct {
+	owner string
+	name  string
 }
 
 // NewSessionManager creates a new session manager.
 func NewSessionManager(apiClient Backend) *SessionManager {
 	return &SessionManager{
 		sessions:  make(map[int]*Session),
+		names:     make(map[sessionKey]int),
 		nextID:    0,
 		apiClient: apiClient,
+		backends:  make(map[string]Backend),
 		defaults:  DefaultSessionDefaults(),
+
+		listed:        listVersion{modified: time.Now()},
+		listedByOwner: make(map[string]listVersion),
+	}
+}
+
+// listChanged records that one of owner's sessions was created, renamed
+// or closed. It is called with sm.mu held.
+func (sm *SessionManager) listChanged(owner string) {
+	now := time.Now()
+	sm.listed = listVersion{sm.listed.version + 1, now}
+	sm.listedByOwner[owner] = listVersion{sm.listedByOwner[owner].version + 1, now}
+}
+
+// ListVersion returns a number that changes whenever a session is
+// created, renamed or closed, and the time of the latest change.
+func (sm *SessionManager) ListVersion() (uint32, time.Time) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.listed.version, sm.listed.modified
+}
+
+// ListVersionFor is ListVersion for the sessions belonging to owner. The
+// time is zero if owner has never had a session.
+func (sm *SessionManager) ListVersionFor(owner string) (uint32, time.Time) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	v := sm.listedByOwner[owner]
+	return v.version, v.modified
+}
+
+// AddBackend makes b available as name, so that a session whose model is
+// "name/model" asks b for model.
+func (sm *SessionManager) AddBackend(name string, b Backend) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.backends[name] = b
+}
+
+// SetDefaultBackend makes the backend added as name the one that answers
+// sessions whose model has no backend prefix.
+func (sm *SessionManager) SetDefaultBackend(name string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	b, ok := sm.backends[name]
+	if !ok {
+		return ErrUnknownBackend
 	}
+	sm.apiClient = b
+	return nil
+}
+
+// Route returns the backend that answers for model and the model to ask it
+// for. "name/model" goes to the backend added as name, which is asked for
+// model, or for its own model if that is empty. Any other model goes
+// unchanged to the default backend, including names with a slash whose
+// prefix names no backend, such as "Qwen/Qwen2.5-7B-Instruct".
+func (sm *SessionManager) Route(model string) (Backend, string) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if name, rest, ok := strings.Cut(model, "/"); ok {
+		if b, ok := sm.backends[name]; ok {
+			return b, rest
+		}
+	}
+	return sm.apiClient, model
 }
 
 // SetDefaults sets the defaults for new sessions.
@@ -226,18 +397,167 @@ func (sm *SessionManager) SetDefaults(defaults SessionDefaults) {
 	sm.defaults = defaults
 }
 
+// Defaults returns the settings given to new sessions.
+func (sm *SessionManager) Defaults() SessionDefaults {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.defaults
+}
+
 // Create creates a new session and returns its ID.
 func (sm *SessionManager) Create() int {
+	return sm.CreateFor("")
+}
+
+// CreateFor creates a new session belonging to owner and returns its ID.
+func (sm *SessionManager) CreateFor(owner string) int {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	id := sm.nextID
 	sm.nextID++
 
-	sm.sessions[id] = NewSession(id, sm.defaults)
+	session := NewSession(id, sm.defaults)
+	session.owner = owner
+	sm.sessions[id] = session
+	sm.listChanged(owner)
 	return id
 }
 
+// CreateNamed creates a new session called name and returns its ID. Named
+// sessions share the ID space with numbered ones, so both kinds can be
+// used side by side.
+func (sm *SessionManager) CreateNamed(name string) (int, error) {
+	return sm.CreateNamedFor(name, "")
+}
+
+// CreateNamedFor is CreateNamed for a session belonging to owner. The name
+// need only be unique among owner's sessions.
+func (sm *SessionManager) CreateNamedFor(name, owner string) (int, error) {
+	if !ValidSessionName(name) {
+		return 0, ErrBadSessionName
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	key := sessionKey{owner, name}
+	if _, exists := sm.names[key]; exists {
+		return 0, ErrSessionExists
+	}
+
+	id := sm.nextID
+	sm.nextID++
+
+	session := NewSession(id, sm.defaults)
+	session.name = name
+	session.owner = owner
+	sm.sessions[id] = session
+	sm.names[key] = id
+	sm.listChanged(owner)
+	return id, nil
+}
+
+// ValidSessionName reports whether name may be given to a session. Names
+// must not look like session IDs, collide with the new file, or contain a
+// slash.
+func ValidSessionName(name string) bool {
+	if name == "" || name == "." || name == ".." || name == "new" {
+		return false
+	}
+	if strings.ContainsAny(name, "/\x00") {
+		return false
+	}
+	return strings.Trim(name, "0123456789") != ""
+}
+
+// Rename gives the session id a new name, which must be unique among its
+// owner's sessions. A numbered session renamed this way becomes a named
+// one.
+func (sm *SessionManager) Rename(id int, name string) error {
+	if !ValidSessionName(name) {
+		return ErrBadSessionName
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	old, owner := session.Name(), session.Owner()
+	if old == name {
+		return nil
+	}
+	if _, exists := sm.names[sessionKey{owner, name}]; exists {
+		return ErrSessionExists
+	}
+
+	session.mu.Lock()
+	session.name = name
+	session.changed()
+	session.mu.Unlock()
+	if old != "" {
+		delete(sm.names, sessionKey{owner, old})
+	}
+	sm.names[sessionKey{owner, name}] = id
+	sm.listChanged(owner)
+	return nil
+}
+
+// Lookup returns the ID of the session called name, whoever owns it, for
+// views of every session such as the admin tree. The name is either a
+// session's name or, for an unnamed session, its decimal ID. If several
+// owners have a session of that name, the one without an owner is found,
+// or none.
+func (sm *SessionManager) Lookup(name string) (int, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if id, ok := sm.names[sessionKey{"", name}]; ok {
+		return id, true
+	}
+	found, n := 0, 0
+	for key, id := range sm.names {
+		if key.name == name {
+			found = id
+			n++
+		}
+	}
+	if n > 0 {
+		return found, n == 1
+	}
+	return sm.lookupID(name)
+}
+
+// LookupFor is Lookup among the sessions belonging to owner. An unnamed
+// session's decimal ID is found whoever owns it, so the caller must check
+// the owner.
+func (sm *SessionManager) LookupFor(name, owner string) (int, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if id, ok := sm.names[sessionKey{owner, name}]; ok {
+		return id, true
+	}
+	return sm.lookupID(name)
+}
+
+// lookupID returns the ID of the unnamed session whose decimal ID is name.
+// The caller must hold sm.mu.
+func (sm *SessionManager) lookupID(name string) (int, bool) {
+	id, err := strconv.Atoi(name)
+	if err != nil || strconv.Itoa(id) != name {
+		return 0, false
+	}
+	session, ok := sm.sessions[id]
+	if !ok || session.Name() != "" {
+		return 0, false
+	}
+	return id, true
+}
+
 // Get returns the session with the given ID, or nil if not found.
 func (sm *SessionManager) Get(id int) *Session {
 	sm.mu.RLock()
@@ -260,6 +580,10 @@ func (sm *SessionManager) Close(id int) error {
 	session.mu.Unlock()
 
 	delete(sm.sessions, id)
+	if name := session.Name(); name != "" {
+		delete(sm.names, sessionKey{session.Owner(), name})
+	}
+	sm.listChanged(session.Owner())
 	return nil
 }
 
@@ -285,6 +609,11 @@ func (sm *SessionManager) Ask(ctx context.Context, id int, prompt string) (strin
 		return "", ErrSessionClosed
 	}
 
+	if err := session.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer session.release()
+
 	// Get session settings
 	session.mu.RLock()
 	history := make([]Message, len(session.messages))
@@ -307,8 +636,10 @@ func (sm *SessionManager) Ask(ctx context.Context, id int, prompt string) (strin
 		Prefill:        prefill,
 	}
 
-	// Make API call (stateless)
-	response, tokens, err := sm.apiClient.AskWithRequest(ctx, req)
+	// Make API call (stateless), to the backend the model names
+	backend, model := sm.Route(model)
+	req.Model = model
+	response, tokens, err := backend.AskWithRequest(ctx, req)
 	if err != nil {
 		session.SetLastResponse("Error: " + err.Error())
 		return "", err
@@ -354,4 +685,7 @@ func (e SessionError) Error() string { return string(e) }
 const (
 	ErrSessionNotFound SessionError = "session not found"
 	ErrSessionClosed   SessionError = "session closed"
+	ErrSessionExists   SessionError = "session exists"
+	ErrBadSessionName  SessionError = "bad session name"
+	ErrUnknownBackend  SessionError = "unknown backend"
 )
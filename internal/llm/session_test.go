@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"testing"
+)
+
+// scriptedMock is a mock backend that answers every prompt with reply.
+func scriptedMock(t *testing.T, reply string) *MockClient {
+	t.Helper()
+	script, err := ParseMockScript([]byte(`responses: [{match: ".", response: "` + reply + `"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMockClient()
+	c.SetScript(script)
+	return c
+}
+
+func TestSessionManager_Route(t *testing.T) {
+	api := scriptedMock(t, "from api")
+	ollama := scriptedMock(t, "from ollama")
+	sm := NewSessionManager(api)
+	sm.AddBackend("api", api)
+	sm.AddBackend("ollama", ollama)
+
+	for _, tc := range []struct {
+		model     string
+		backend   Backend
+		wantModel string
+	}{
+		{"claude-sonnet-4-20250514", api, "claude-sonnet-4-20250514"},
+		{"ollama/llama3", ollama, "llama3"},
+		{"ollama/", ollama, ""},
+		{"api/claude-opus-4-20250514", api, "claude-opus-4-20250514"},
+		{"Qwen/Qwen2.5-7B-Instruct", api, "Qwen/Qwen2.5-7B-Instruct"},
+	} {
+		b, model := sm.Route(tc.model)
+		if b != tc.backend || model != tc.wantModel {
+			t.Errorf("Route(%q) = %p, %q; want %p, %q", tc.model, b, model, tc.backend, tc.wantModel)
+		}
+	}
+
+	// Sessions on different backends are answered by their own.
+	id := sm.Create()
+	other := sm.Create()
+	sm.Get(other).SetModel("ollama/llama3")
+	for _, tc := range []struct {
+		id   int
+		want string
+	}{{id, "from api"}, {other, "from ollama"}} {
+		got, err := sm.Ask(context.Background(), tc.id, "hello")
+		if err != nil {
+			t.Fatalf("Ask(%d) error: %v", tc.id, err)
+		}
+		if got != tc.want {
+			t.Errorf("Ask(%d) = %q, want %q", tc.id, got, tc.want)
+		}
+	}
+
+	if err := sm.SetDefaultBackend("nosuch"); err != ErrUnknownBackend {
+		t.Errorf("SetDefaultBackend(nosuch) = %v, want ErrUnknownBackend", err)
+	}
+	if err := sm.SetDefaultBackend("ollama"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := sm.Route("llama3"); b != ollama {
+		t.Error("unprefixed model not routed to the new default backend")
+	}
+}
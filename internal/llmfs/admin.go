@@ -0,0 +1,150 @@
+package llmfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// NewAdminRoot creates the administrative tree, which sees and controls
+// every session whoever owns it:
+//
+//	/n/llm-admin/
+//	├── ctl        # close NAME, reset NAME, model NAME
+//	├── status     # one line per session
+//	└── sessions/  # every session, laid out as in NewRoot
+func NewAdminRoot(sm *llm.SessionManager) protocol.Dir {
+	sessions := NewSessionsDir(sm)
+	sessions.Name_ = "sessions"
+
+	root := protocol.NewStaticDir("admin")
+	root.AddChild(NewAdminCtlFile(sm))
+	root.AddChild(NewAdminStatusFile(sm))
+	root.AddChild(sessions)
+	return root
+}
+
+// AdminCtlFile is the server-wide control file: /n/llm-admin/ctl
+// Supports commands: "close NAME" and "reset NAME" for any session, by
+// name or ID, and "model NAME" to set the model given to new sessions.
+// A name several owners have given sessions must be given as the ID.
+type AdminCtlFile struct {
+	*protocol.BaseFile
+	sm *llm.SessionManager
+}
+
+// NewAdminCtlFile creates the admin ctl file.
+func NewAdminCtlFile(sm *llm.SessionManager) *AdminCtlFile {
+	return &AdminCtlFile{
+		BaseFile: protocol.NewBaseFile("ctl", 0222),
+		sm:       sm,
+	}
+}
+
+// Read returns empty for the control file.
+func (f *AdminCtlFile) Read(p []byte, offset int64) (int, error) {
+	return 0, io.EOF
+}
+
+// Write processes control commands.
+func (f *AdminCtlFile) Write(p []byte, offset int64) (int, error) {
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(string(p)), " ")
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0, protocol.Errorf(protocol.EINVAL, "usage: close|reset|model NAME")
+	}
+
+	switch cmd {
+	case "close", "reset":
+		id, ok := f.sm.Lookup(arg)
+		if !ok {
+			// Any session's ID will do, named or not.
+			n, err := strconv.Atoi(arg)
+			if err != nil || f.sm.Get(n) == nil {
+				return 0, protocol.ErrNotFound
+			}
+			id = n
+		}
+		if cmd == "close" {
+			f.sm.Close(id)
+		} else {
+			f.sm.Reset(id)
+		}
+	case "model":
+		defaults := f.sm.Defaults()
+		defaults.Model = arg
+		f.sm.SetDefaults(defaults)
+	default:
+		return 0, protocol.Errorf(protocol.EINVAL, "unknown command: %s", cmd)
+	}
+
+	return len(p), nil
+}
+
+// AdminStatusFile lists every session: /n/llm-admin/status
+// Each line has the session's ID, name, owner, model and total tokens,
+// with "-" for a missing name or owner.
+type AdminStatusFile struct {
+	*protocol.BaseFile
+	sm *llm.SessionManager
+}
+
+// NewAdminStatusFile creates the admin status file.
+func NewAdminStatusFile(sm *llm.SessionManager) *AdminStatusFile {
+	return &AdminStatusFile{
+		BaseFile: protocol.NewBaseFile("status", 0444),
+		sm:       sm,
+	}
+}
+
+func (f *AdminStatusFile) content() (string, error) {
+	ids := f.sm.ListSessions()
+	sort.Ints(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		session := f.sm.Get(id)
+		if session == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%d %s %s %s %d\n", id, orDash(session.Name()), orDash(session.Owner()),
+			session.Model(), session.TotalTokens())
+	}
+	return b.String(), nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Open captures the listing for this fid.
+func (f *AdminStatusFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSnapshot(f, f.content)
+}
+
+// Read returns the session listing.
+func (f *AdminStatusFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Write is not supported.
+func (f *AdminStatusFile) Write(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+// Stat returns the file's metadata.
+func (f *AdminStatusFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	content, _ := f.content()
+	s.Length = uint64(len(content))
+	return s
+}
@@ -43,13 +43,18 @@ func (f *CompactFile) Read(p []byte, offset int64) (int, error) {
 }
 
 func (f *CompactFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// WriteContext triggers compaction, abandoning it if ctx is cancelled.
+func (f *CompactFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
 	cmd := strings.TrimSpace(string(p))
 	if cmd == "" {
 		return len(p), nil
 	}
 
 	// Trigger compaction
-	err := f.client.Compact(context.Background())
+	err := f.client.Compact(ctx)
 
 	f.mu.Lock()
 	if err != nil {
@@ -0,0 +1,128 @@
+package llmfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// contentFunc generates the current content of a session file.
+type contentFunc func() (string, error)
+
+// openSnapshot captures the content of f for a newly opened fid, so that
+// every read on the fid sees the same version even if the session changes
+// between Treads.
+func openSnapshot(f protocol.File, content contentFunc) (protocol.Handle, error) {
+	s, err := content()
+	if err != nil {
+		return nil, err
+	}
+	return protocol.NewSnapshotHandle(f, []byte(s)), nil
+}
+
+// readContent serves a read at offset from freshly generated content.
+// It is used when a file is read directly rather than through a fid.
+func readContent(content contentFunc, p []byte, offset int64) (int, error) {
+	s, err := content()
+	if err != nil {
+		return 0, err
+	}
+	if offset >= int64(len(s)) {
+		return 0, io.EOF
+	}
+	return copy(p, s[offset:]), nil
+}
+
+// openSetting captures the content of a session setting for a newly opened
+// fid, like openSnapshot, and gathers the fid's writes. A value larger than
+// one 9P message arrives as several Twrites at increasing offsets, so the
+// value is set from all of them, by f's Write at offset 0, when the fid is
+// clunked.
+func openSetting(f protocol.File, content contentFunc) (protocol.Handle, error) {
+	s, err := content()
+	if err != nil {
+		return nil, err
+	}
+	return &settingHandle{
+		SnapshotHandle: protocol.NewSnapshotHandle(f, []byte(s)),
+		file:           f,
+	}, nil
+}
+
+// settingValidator is implemented by settings that reject some values, so
+// that a bad value fails its Twrite rather than the Tclunk after it, whose
+// error clients such as 9pfuse and v9fs ignore. Such values are short, so a
+// valid one always arrives in a single Twrite.
+type settingValidator interface {
+	validate(value []byte) error
+}
+
+// settingHandle is one open fid on a session setting: the value captured
+// at open and the new value written so far.
+type settingHandle struct {
+	*protocol.SnapshotHandle
+	file protocol.File
+
+	mu  sync.Mutex
+	buf []byte // new value, not yet set
+}
+
+// Write places p at offset in the new value. Writes may overwrite or
+// extend what has been written but not leave a gap, and a write that
+// leaves a value the setting rejects fails and is discarded.
+func (h *settingHandle) Write(ctx context.Context, p []byte, offset int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if offset < 0 || offset > int64(len(h.buf)) {
+		return 0, protocol.Errorf(protocol.EINVAL, "non-contiguous write: offset %d, expected at most %d", offset, len(h.buf))
+	}
+	buf := h.buf
+	if end := offset + int64(len(p)); end > int64(len(buf)) {
+		buf = append(bytes.Clone(buf), make([]byte, end-int64(len(buf)))...)
+	} else {
+		buf = bytes.Clone(buf)
+	}
+	copy(buf[offset:], p)
+	if v, ok := h.file.(settingValidator); ok {
+		if err := v.validate(buf); err != nil {
+			return 0, err
+		}
+	}
+	h.buf = buf
+	return len(p), nil
+}
+
+// Close sets the written value, if any, when the fid is clunked. If ctx is
+// already cancelled, because the connection has gone or the Tclunk was
+// flushed, the value is discarded.
+func (h *settingHandle) Close(ctx context.Context) error {
+	h.mu.Lock()
+	data := h.buf
+	h.buf = nil
+	h.mu.Unlock()
+	return h.set(ctx, data)
+}
+
+// Sync sets the value written so far, for Tfsync. It stays buffered, so
+// later writes carry on from it and the clunk sets it again.
+func (h *settingHandle) Sync(ctx context.Context) error {
+	h.mu.Lock()
+	data := bytes.Clone(h.buf)
+	h.mu.Unlock()
+	return h.set(ctx, data)
+}
+
+// set sets the setting to data, unless data is empty or ctx is cancelled.
+func (h *settingHandle) set(ctx context.Context, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := h.file.Write(data, 0)
+	return err
+}
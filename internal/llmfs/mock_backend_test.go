@@ -104,6 +104,9 @@ func (m *MockBackend) AskWithHistory(ctx context.Context, history []llm.Message,
 }
 
 func (m *MockBackend) AskWithRequest(ctx context.Context, req llm.AskRequest) (string, int, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
 	if m.askError != nil {
 		return "", 0, m.askError
 	}
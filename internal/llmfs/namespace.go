@@ -0,0 +1,92 @@
+package llmfs
+
+import (
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// AdminAname is the attach name of the administrative tree.
+const AdminAname = "admin"
+
+// Namespace chooses a client's root by attach name and user, so that one
+// server can host several tenants:
+//
+//	aname ""        the sessions tree; with per-user roots, only the
+//	                user's own sessions
+//	aname "admin"   the tree made by NewAdminRoot, for admin users only
+//	any other       a read-only view of the sessions tree, without new
+//
+// Users are those named in Tattach, so per-user roots and admin access
+// only keep tenants apart when the server requires authentication.
+type Namespace struct {
+	sm      *llm.SessionManager
+	shared  *SessionsDir
+	admin   protocol.Dir
+	perUser bool
+	admins  map[string]bool
+
+	mu    sync.Mutex
+	users map[string]*SessionsDir
+}
+
+// NewNamespace creates a namespace over sm, with no admin users and the
+// sessions tree shared by everyone.
+func NewNamespace(sm *llm.SessionManager) *Namespace {
+	return &Namespace{
+		sm:     sm,
+		shared: NewSessionsDir(sm),
+		admin:  NewAdminRoot(sm),
+		admins: make(map[string]bool),
+		users:  make(map[string]*SessionsDir),
+	}
+}
+
+// SetPerUser gives each user a root of their own, in which they see and
+// create only their own sessions.
+func (ns *Namespace) SetPerUser(on bool) {
+	ns.perUser = on
+}
+
+// SetAdmins sets the users who may attach the admin tree.
+func (ns *Namespace) SetAdmins(users ...string) {
+	ns.admins = make(map[string]bool)
+	for _, u := range users {
+		ns.admins[u] = true
+	}
+}
+
+// Root returns the root for uname attaching to aname.
+func (ns *Namespace) Root(uname, aname string) (protocol.Dir, error) {
+	switch aname {
+	case "":
+		return ns.sessions(uname), nil
+	case AdminAname:
+		if !ns.admins[uname] {
+			return nil, protocol.ErrPermission
+		}
+		return ns.admin, nil
+	default:
+		// Opening new makes a session, so leave it out.
+		d := *ns.sessions(uname)
+		d.newFile = nil
+		return protocol.ReadOnly(&d), nil
+	}
+}
+
+// sessions returns the sessions tree uname sees. Each user keeps the
+// same root across attaches.
+func (ns *Namespace) sessions(uname string) *SessionsDir {
+	if !ns.perUser {
+		return ns.shared
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	root, ok := ns.users[uname]
+	if !ok {
+		root = NewUserSessionsDir(ns.sm, uname)
+		ns.users[uname] = root
+	}
+	return root
+}
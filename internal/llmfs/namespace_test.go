@@ -0,0 +1,149 @@
+package llmfs
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestNamespace_PerUser(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	ns := NewNamespace(sm)
+	ns.SetPerUser(true)
+
+	alice, _ := ns.Root("alice", "")
+	bob, _ := ns.Root("bob", "")
+	if again, _ := ns.Root("alice", ""); again != alice {
+		t.Error("alice got a different root on her second attach")
+	}
+
+	if _, err := alice.Create("notes", protocol.DMDIR|0755); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	h, err := bob.(*SessionsDir).newFile.Open(context.Background(), protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open new: %v", err)
+	}
+	h.Close(context.Background())
+
+	id, _ := sm.Lookup("notes")
+	if owner := sm.Get(id).Owner(); owner != "alice" {
+		t.Errorf("notes owner = %q, want alice", owner)
+	}
+	if _, err := bob.Lookup("notes"); err != protocol.ErrNotFound {
+		t.Errorf("bob Lookup(notes) error = %v, want %v", err, protocol.ErrNotFound)
+	}
+	if n := len(alice.Children()); n != 2 {
+		t.Errorf("alice sees %d entries, want new and notes", n)
+	}
+	if n := len(bob.Children()); n != 2 {
+		t.Errorf("bob sees %d entries, want new and his session", n)
+	}
+}
+
+func TestNamespace_PerUserNames(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	ns := NewNamespace(sm)
+	ns.SetPerUser(true)
+	ns.SetAdmins("root")
+	alice, _ := ns.Root("alice", "")
+	bob, _ := ns.Root("bob", "")
+
+	// Each user has names of their own, so bob's mkdir tells him nothing
+	// about alice's sessions.
+	if _, err := alice.Create("notes", protocol.DMDIR|0755); err != nil {
+		t.Fatalf("alice Create: %v", err)
+	}
+	if _, err := bob.Create("notes", protocol.DMDIR|0755); err != nil {
+		t.Fatalf("bob Create(notes) error: %v, want his own session", err)
+	}
+	if _, err := alice.Create("notes", protocol.DMDIR|0755); err != protocol.ErrExists {
+		t.Errorf("alice second Create error = %v, want %v", err, protocol.ErrExists)
+	}
+	a, _ := alice.Lookup("notes")
+	b, _ := bob.Lookup("notes")
+	aid, bid := a.(*SessionDir).id, b.(*SessionDir).id
+	if aid == bid || sm.Get(aid).Owner() != "alice" || sm.Get(bid).Owner() != "bob" {
+		t.Errorf("notes is session %d for alice and %d for bob, want each their own", aid, bid)
+	}
+
+	// Renaming is checked against the owner's names only.
+	if _, err := bob.Create("scratch", protocol.DMDIR|0755); err != nil {
+		t.Fatalf("bob Create(scratch): %v", err)
+	}
+	if err := sm.Rename(aid, "scratch"); err != nil {
+		t.Errorf("alice Rename to bob's name: %v", err)
+	}
+
+	// The admin tree cannot tell the two by name, but can by ID.
+	admin, _ := ns.Root("root", AdminAname)
+	ctl, _ := admin.Lookup("ctl")
+	if _, err := ctl.Write([]byte("close scratch"), 0); err != protocol.ErrNotFound {
+		t.Errorf("close of an ambiguous name error = %v, want %v", err, protocol.ErrNotFound)
+	}
+	if _, err := ctl.Write([]byte("close "+strconv.Itoa(aid)), 0); err != nil {
+		t.Fatalf("close by ID: %v", err)
+	}
+	if sm.Get(aid) != nil || sm.Get(bid) == nil {
+		t.Error("close by ID closed the wrong session")
+	}
+	if _, err := alice.Create("scratch", protocol.DMDIR|0755); err != nil {
+		t.Errorf("Create after close: %v", err)
+	}
+}
+
+func TestNamespace_Admin(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	ns := NewNamespace(sm)
+	ns.SetAdmins("root")
+
+	if _, err := ns.Root("alice", AdminAname); err != protocol.ErrPermission {
+		t.Errorf("non-admin attach error = %v, want %v", err, protocol.ErrPermission)
+	}
+	admin, err := ns.Root("root", AdminAname)
+	if err != nil {
+		t.Fatalf("admin attach: %v", err)
+	}
+
+	id, _ := sm.CreateNamedFor("scratch", "alice")
+	status, _ := admin.Lookup("status")
+	buf := make([]byte, 1024)
+	n, _ := status.Read(buf, 0)
+	if got := string(buf[:n]); !strings.HasPrefix(got, "0 scratch alice ") {
+		t.Errorf("status = %q, want a line for scratch owned by alice", got)
+	}
+
+	ctl, _ := admin.Lookup("ctl")
+	if _, err := ctl.Write([]byte("close scratch"), 0); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if sm.Get(id) != nil {
+		t.Error("session still open after admin close")
+	}
+	if _, err := ctl.Write([]byte("close scratch"), 0); err != protocol.ErrNotFound {
+		t.Errorf("second close error = %v, want %v", err, protocol.ErrNotFound)
+	}
+}
+
+func TestNamespace_UnknownAnameReadOnly(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	ns := NewNamespace(sm)
+
+	root, err := ns.Root("alice", "whatever")
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	if _, err := root.Create("notes", protocol.DMDIR|0755); err != protocol.ErrPermission {
+		t.Errorf("Create error = %v, want %v", err, protocol.ErrPermission)
+	}
+	if _, ok := sm.Lookup("notes"); ok {
+		t.Error("session created through a read-only root")
+	}
+	if _, err := root.Lookup("new"); err != protocol.ErrNotFound {
+		t.Errorf("Lookup(new) error = %v, want %v", err, protocol.ErrNotFound)
+	}
+}
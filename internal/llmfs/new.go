@@ -1,6 +1,7 @@
 package llmfs
 
 import (
+	"context"
 	"fmt"
 	"io"
 
@@ -13,7 +14,8 @@ import (
 // This follows the Plan 9 clone pattern (like /net/tcp/clone, Acme windows).
 type NewFile struct {
 	*protocol.BaseFile
-	sm *llm.SessionManager
+	sm    *llm.SessionManager
+	owner string // owner of the sessions it creates
 }
 
 // NewNewFile creates the new file (session factory).
@@ -24,6 +26,13 @@ func NewNewFile(sm *llm.SessionManager) *NewFile {
 	}
 }
 
+// Open creates a new session and captures its ID for this fid, like
+// opening /net/tcp/clone. Reading the fid returns the ID.
+func (f *NewFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	id := f.sm.CreateFor(f.owner)
+	return protocol.NewSnapshotHandle(f, []byte(fmt.Sprintf("%d\n", id))), nil
+}
+
 // Read creates a new session and returns its ID.
 // Each read creates a fresh session with default settings.
 func (f *NewFile) Read(p []byte, offset int64) (int, error) {
@@ -34,7 +43,7 @@ func (f *NewFile) Read(p []byte, offset int64) (int, error) {
 	}
 
 	// Create new session
-	id := f.sm.Create()
+	id := f.sm.CreateFor(f.owner)
 
 	// Return session ID
 	content := fmt.Sprintf("%d\n", id)
@@ -21,6 +21,7 @@ import (
 //	│   ├── thinking
 //	│   └── prefill
 //	├── 1/               # Session 1 (fully independent)
+//	├── reviewbot/       # Named session, created by mkdir
 //	└── ...
 //
 // No global files. Each session is isolated with its own settings.
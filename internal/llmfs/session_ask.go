@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 
 	"github.com/NERVsystems/llm9p/internal/llm"
 	"github.com/NERVsystems/llm9p/internal/protocol"
@@ -12,6 +13,11 @@ import (
 
 // SessionAskFile is the ask file for a specific session: /n/llm/N/ask
 // Write a prompt, read the response.
+//
+// A prompt larger than one 9P message arrives as several Twrites at
+// increasing offsets, so each open fid gets an askHandle that accumulates
+// the writes and sends them as a single prompt when the fid is clunked, or
+// when a zero-length write marks the end of the prompt.
 type SessionAskFile struct {
 	*protocol.BaseFile
 	sm *llm.SessionManager
@@ -21,58 +27,169 @@ type SessionAskFile struct {
 // NewSessionAskFile creates an ask file for the given session.
 func NewSessionAskFile(sm *llm.SessionManager, id int) *SessionAskFile {
 	return &SessionAskFile{
-		BaseFile: protocol.NewBaseFile("ask", 0666),
+		BaseFile: newSessionBase(id, kindAsk, "ask", 0666),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the last response from this session.
-func (f *SessionAskFile) Read(p []byte, offset int64) (int, error) {
+// Open returns a handle holding this fid's prompt buffer. When opened for
+// reading it first waits for any prompt still in flight, so that a reader
+// racing the writer's clunk sees the new response, and then captures that
+// response for the fid.
+func (f *SessionAskFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return nil, protocol.ErrNotFound
+	}
+
+	h := &askHandle{file: f}
+	if mode&3 != protocol.OWRITE {
+		if err := session.WaitIdle(ctx); err != nil {
+			return nil, err
+		}
+		content, err := f.content()
+		if err != nil {
+			return nil, err
+		}
+		h.response = []byte(content)
+	}
+	return h, nil
+}
+
+func (f *SessionAskFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
 
 	content := session.LastResponse()
 	if content != "" && !strings.HasSuffix(content, "\n") {
 		content += "\n"
 	}
+	return content, nil
+}
 
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
-
-	n := copy(p, content[offset:])
-	return n, nil
+// Read returns the last response from this session.
+func (f *SessionAskFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
 }
 
-// Write sends a prompt to the LLM using this session's settings.
+// Write sends p as a complete prompt. Writes through an open fid are
+// buffered by its askHandle instead.
 func (f *SessionAskFile) Write(p []byte, offset int64) (int, error) {
-	log.Printf("llm9p: SessionAskFile.Write session=%d len=%d", f.id, len(p))
+	if err := f.ask(context.Background(), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
 
-	prompt := strings.TrimSpace(string(p))
+// ask sends a prompt to the LLM using this session's settings.
+func (f *SessionAskFile) ask(ctx context.Context, data []byte) error {
+	log.Printf("llm9p: SessionAskFile.ask session=%d len=%d", f.id, len(data))
+
+	prompt := strings.TrimSpace(string(data))
 	if prompt == "" {
-		return len(p), nil // Empty write is a no-op
+		return nil // Empty prompt is a no-op
 	}
 
-	log.Printf("llm9p: SessionAskFile.Write prompt: %s", prompt[:min(len(prompt), 50)])
+	log.Printf("llm9p: SessionAskFile.ask prompt: %s", prompt[:min(len(prompt), 50)])
 
-	ctx := context.Background()
 	response, err := f.sm.Ask(ctx, f.id, prompt)
 	if err != nil {
-		log.Printf("llm9p: SessionAskFile.Write error: %v", err)
+		log.Printf("llm9p: SessionAskFile.ask error: %v", err)
 		// Error is stored in session.LastResponse by SessionManager
-		return len(p), nil // Return success so client knows write completed
+		return nil // Report success so the client sees the write complete
 	}
 
-	log.Printf("llm9p: SessionAskFile.Write success, response len=%d", len(response))
+	log.Printf("llm9p: SessionAskFile.ask success, response len=%d", len(response))
+	return nil
+}
+
+// askHandle is one open fid on an ask file: the response captured at open
+// and the prompt written so far.
+type askHandle struct {
+	file     *SessionAskFile
+	response []byte
+
+	mu   sync.Mutex
+	buf  []byte // prompt bytes not yet sent
+	base int64  // file offset at which buf starts
+}
+
+// Read returns the response captured when the fid was opened.
+func (h *askHandle) Read(ctx context.Context, p []byte, offset int64) (int, error) {
+	if offset >= int64(len(h.response)) {
+		return 0, io.EOF
+	}
+	return copy(p, h.response[offset:]), nil
+}
+
+// Write buffers part of a prompt. Writes must be contiguous; a write at
+// offset 0 with nothing buffered starts a new prompt. A zero-length write
+// sends the buffered prompt immediately, abandoning the request if ctx is
+// cancelled (for example by Tflush).
+func (h *askHandle) Write(ctx context.Context, p []byte, offset int64) (int, error) {
+	h.mu.Lock()
+	if len(h.buf) == 0 && offset == 0 {
+		h.base = 0
+	}
+	if want := h.base + int64(len(h.buf)); offset != want {
+		h.mu.Unlock()
+		return 0, protocol.Errorf(protocol.EINVAL, "non-contiguous write to ask: offset %d, expected %d", offset, want)
+	}
+	h.buf = append(h.buf, p...)
+	h.mu.Unlock()
+
+	if len(p) == 0 {
+		return 0, h.commit(ctx)
+	}
 	return len(p), nil
 }
 
+// Close sends any buffered prompt when the fid is clunked.
+func (h *askHandle) Close(ctx context.Context) error {
+	return h.commit(ctx)
+}
+
+// Sync sends any buffered prompt for Tfsync, as a zero-length write does.
+func (h *askHandle) Sync(ctx context.Context) error {
+	return h.commit(ctx)
+}
+
+// commit sends the buffered prompt.
+func (h *askHandle) commit(ctx context.Context) error {
+	h.mu.Lock()
+	data := h.buf
+	h.base += int64(len(h.buf))
+	h.buf = nil
+	h.mu.Unlock()
+
+	return h.file.ask(ctx, data)
+}
+
+// Truncate does nothing. Each open of ask starts a new prompt, so shells
+// that open it with OTRUNC need not be refused.
+func (f *SessionAskFile) Truncate(length uint64) error {
+	if f.sm.Get(f.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return nil
+}
+
+// Remove clears the last response. The conversation history is kept.
+func (f *SessionAskFile) Remove() error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	session.SetLastResponse("")
+	return nil
+}
+
 // Stat returns the file's metadata.
 func (f *SessionAskFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	// Length is dynamic based on last response
 	session := f.sm.Get(f.id)
 	if session != nil {
@@ -0,0 +1,159 @@
+package llmfs
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// recordingBackend records the prompts it is asked
+type recordingBackend struct {
+	*MockBackend
+	prompts []string
+}
+
+func (b *recordingBackend) AskWithRequest(ctx context.Context, req llm.AskRequest) (string, int, error) {
+	b.prompts = append(b.prompts, req.Prompt)
+	return b.MockBackend.AskWithRequest(ctx, req)
+}
+
+func newAskTest(t *testing.T) (*recordingBackend, *llm.SessionManager, int) {
+	t.Helper()
+	b := &recordingBackend{MockBackend: NewMockBackend()}
+	b.askResponse = "4"
+	sm := llm.NewSessionManager(b)
+	return b, sm, sm.Create()
+}
+
+// openAsk opens the session's ask file for writing, as a Topen would
+func openAsk(t *testing.T, sm *llm.SessionManager, id int) protocol.Handle {
+	t.Helper()
+	h, err := NewSessionAskFile(sm, id).Open(context.Background(), protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	return h
+}
+
+func TestSessionAskFile_CommitOnClose(t *testing.T) {
+	b, sm, id := newAskTest(t)
+	ctx := context.Background()
+
+	ask := openAsk(t, sm, id)
+	if _, err := ask.Write(ctx, []byte("What is 2+2?\n"), 0); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if len(b.prompts) != 0 {
+		t.Fatalf("prompt sent before clunk: %q", b.prompts)
+	}
+
+	if err := ask.Close(ctx); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if len(b.prompts) != 1 || b.prompts[0] != "What is 2+2?" {
+		t.Errorf("prompts = %q, want [\"What is 2+2?\"]", b.prompts)
+	}
+
+	buf := make([]byte, 100)
+	n, err := NewSessionAskFile(sm, id).Read(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if got := string(buf[:n]); got != "4\n" {
+		t.Errorf("Read() = %q, want %q", got, "4\n")
+	}
+}
+
+func TestSessionAskFile_MultipleWritesOnePrompt(t *testing.T) {
+	b, sm, id := newAskTest(t)
+	ctx := context.Background()
+
+	ask := openAsk(t, sm, id)
+	ask.Write(ctx, []byte("first half, "), 0)
+	ask.Write(ctx, []byte("second half"), 12)
+	ask.Close(ctx)
+
+	if len(b.prompts) != 1 || b.prompts[0] != "first half, second half" {
+		t.Errorf("prompts = %q, want one joined prompt", b.prompts)
+	}
+}
+
+func TestSessionAskFile_NonContiguousWrite(t *testing.T) {
+	b, sm, id := newAskTest(t)
+	ctx := context.Background()
+
+	ask := openAsk(t, sm, id)
+	ask.Write(ctx, []byte("hello"), 0)
+	if _, err := ask.Write(ctx, []byte("world"), 100); err == nil {
+		t.Error("Write() at a gap should fail")
+	}
+	if _, err := ask.Write(ctx, []byte("again"), 0); err == nil {
+		t.Error("Write() rewinding over a buffered prompt should fail")
+	}
+
+	ask.Close(ctx)
+	if len(b.prompts) != 1 || b.prompts[0] != "hello" {
+		t.Errorf("prompts = %q, want [\"hello\"]", b.prompts)
+	}
+}
+
+func TestSessionAskFile_ZeroLengthWriteCommits(t *testing.T) {
+	b, sm, id := newAskTest(t)
+	ctx := context.Background()
+
+	ask := openAsk(t, sm, id)
+	ask.Write(ctx, []byte("one"), 0)
+	if _, err := ask.Write(ctx, nil, 3); err != nil {
+		t.Fatalf("Write(nil) error: %v", err)
+	}
+	ask.Write(ctx, []byte("two"), 3)
+	ask.Write(ctx, nil, 6)
+	ask.Close(ctx)
+
+	if len(b.prompts) != 2 || b.prompts[0] != "one" || b.prompts[1] != "two" {
+		t.Errorf("prompts = %q, want [\"one\" \"two\"]", b.prompts)
+	}
+}
+
+func TestSessionAskFile_SyncCommits(t *testing.T) {
+	b, sm, id := newAskTest(t)
+	ctx := context.Background()
+
+	ask := openAsk(t, sm, id)
+	ask.Write(ctx, []byte("one"), 0)
+	if err := ask.(protocol.Syncer).Sync(ctx); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if len(b.prompts) != 1 || b.prompts[0] != "one" {
+		t.Fatalf("prompts after Sync = %q, want [\"one\"]", b.prompts)
+	}
+	ask.Write(ctx, []byte("two"), 3)
+	ask.Close(ctx)
+
+	if len(b.prompts) != 2 || b.prompts[1] != "two" {
+		t.Errorf("prompts = %q, want [\"one\" \"two\"]", b.prompts)
+	}
+}
+
+func TestSessionAskFile_CloseCancelled(t *testing.T) {
+	b, sm, id := newAskTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ask := openAsk(t, sm, id)
+	ask.Write(ctx, []byte("What is 2+2?\n"), 0)
+	if err := ask.Close(ctx); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if len(b.prompts) != 0 {
+		t.Errorf("cancelled commit reached the backend: %q", b.prompts)
+	}
+	if n := len(sm.Get(id).Messages()); n != 0 {
+		t.Errorf("cancelled ask added %d messages to history, want 0", n)
+	}
+}
@@ -1,7 +1,7 @@
 package llmfs
 
 import (
-	"io"
+	"context"
 
 	"github.com/NERVsystems/llm9p/internal/llm"
 	"github.com/NERVsystems/llm9p/internal/protocol"
@@ -18,37 +18,48 @@ type SessionContextFile struct {
 // NewSessionContextFile creates a context file for the given session.
 func NewSessionContextFile(sm *llm.SessionManager, id int) *SessionContextFile {
 	return &SessionContextFile{
-		BaseFile: protocol.NewBaseFile("context", 0444),
+		BaseFile: newSessionBase(id, kindContext, "context", 0444),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the conversation history as JSON.
-func (f *SessionContextFile) Read(p []byte, offset int64) (int, error) {
+func (f *SessionContextFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
 
 	content, err := session.MessagesJSON()
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 	// Add newline
-	content = append(content, '\n')
+	return string(content) + "\n", nil
+}
 
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
+// Open captures the conversation history for this fid, so a long history
+// read over several Treads is not torn by a concurrent ask.
+func (f *SessionContextFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSnapshot(f, f.content)
+}
 
-	n := copy(p, content[offset:])
-	return n, nil
+// Read returns the conversation history as JSON.
+func (f *SessionContextFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Remove clears the conversation history, like writing "reset" to ctl.
+func (f *SessionContextFile) Remove() error {
+	if f.sm.Get(f.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return f.sm.Reset(f.id)
 }
 
 // Stat returns the file's metadata.
 func (f *SessionContextFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	session := f.sm.Get(f.id)
 	if session != nil {
 		content, err := session.MessagesJSON()
@@ -1,6 +1,7 @@
 package llmfs
 
 import (
+	"context"
 	"io"
 	"strings"
 
@@ -9,7 +10,8 @@ import (
 )
 
 // SessionCtlFile is the control file for a session: /n/llm/N/ctl
-// Supports commands: "reset" (clear history), "close" (remove session)
+// Supports commands: "reset" (clear history), "close" (remove session),
+// "ephemeral" (close the session when the writer's connection ends)
 type SessionCtlFile struct {
 	*protocol.BaseFile
 	sm *llm.SessionManager
@@ -19,7 +21,7 @@ type SessionCtlFile struct {
 // NewSessionCtlFile creates a ctl file for the given session.
 func NewSessionCtlFile(sm *llm.SessionManager, id int) *SessionCtlFile {
 	return &SessionCtlFile{
-		BaseFile: protocol.NewBaseFile("ctl", 0222),
+		BaseFile: newSessionBase(id, kindCtl, "ctl", 0222),
 		sm:       sm,
 		id:       id,
 	}
@@ -32,6 +34,12 @@ func (f *SessionCtlFile) Read(p []byte, offset int64) (int, error) {
 
 // Write processes control commands.
 func (f *SessionCtlFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// WriteContext processes control commands. The context identifies the
+// connection for "ephemeral".
+func (f *SessionCtlFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
 	cmd := strings.TrimSpace(string(p))
 
 	switch cmd {
@@ -39,14 +47,32 @@ func (f *SessionCtlFile) Write(p []byte, offset int64) (int, error) {
 		f.sm.Reset(f.id)
 	case "close":
 		f.sm.Close(f.id)
+	case "ephemeral":
+		conn := protocol.ConnFromContext(ctx)
+		if conn == nil {
+			return 0, protocol.Errorf(protocol.EINVAL, "ephemeral needs a client connection")
+		}
+		if f.sm.Get(f.id) == nil {
+			return 0, protocol.ErrNotFound
+		}
+		conn.OnClose(func() { f.sm.Close(f.id) })
 	default:
-		return 0, protocol.Error("unknown command: " + cmd)
+		return 0, protocol.Errorf(protocol.EINVAL, "unknown command: %s", cmd)
 	}
 
 	return len(p), nil
 }
 
+// Remove succeeds without doing anything: ctl holds no state, and
+// refusing would stop "rm -r" from removing the session.
+func (f *SessionCtlFile) Remove() error {
+	if f.sm.Get(f.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return nil
+}
+
 // Stat returns the file's metadata.
 func (f *SessionCtlFile) Stat() protocol.Stat {
-	return f.BaseFile.Stat()
+	return sessionStat(f.BaseFile, f.sm, f.id)
 }
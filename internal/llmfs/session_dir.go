@@ -1,9 +1,11 @@
 package llmfs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 
 	"github.com/NERVsystems/llm9p/internal/llm"
 	"github.com/NERVsystems/llm9p/internal/protocol"
@@ -17,10 +19,15 @@ type SessionDir struct {
 	id int
 }
 
-// NewSessionDir creates a session directory for the given session ID.
+// NewSessionDir creates a session directory for the given session ID. The
+// directory is called by the session's name if it has one.
 func NewSessionDir(sm *llm.SessionManager, id int) *SessionDir {
+	name := strconv.Itoa(id)
+	if session := sm.Get(id); session != nil && session.Name() != "" {
+		name = session.Name()
+	}
 	return &SessionDir{
-		BaseFile: protocol.NewBaseFile(strconv.Itoa(id), protocol.DMDIR|0555),
+		BaseFile: newSessionBase(id, kindSessionDir, name, protocol.DMDIR|0755),
 		sm:       sm,
 		id:       id,
 	}
@@ -74,14 +81,34 @@ func (d *SessionDir) Lookup(name string) (protocol.File, error) {
 	}
 }
 
+// Create is not permitted; every session has the same files.
+func (d *SessionDir) Create(name string, perm uint32) (protocol.File, error) {
+	return nil, protocol.ErrPermission
+}
+
+// Remove closes the session, so "rm -r /n/llm/N" works like writing
+// "close" to ctl.
+func (d *SessionDir) Remove() error {
+	if d.sm.Get(d.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return d.sm.Close(d.id)
+}
+
+// Rename renames the session, so "mv /n/llm/3 /n/llm/reviewbot" names a
+// numbered session. The naming rules are those of mkdir.
+func (d *SessionDir) Rename(name string) error {
+	return sessionError(d.sm.Rename(d.id, name), name)
+}
+
 // Read returns directory listing as packed stat entries.
 func (d *SessionDir) Read(p []byte, offset int64) (int, error) {
 	var buf []byte
 	for _, f := range d.Children() {
 		stat := f.Stat()
-		entry := make([]byte, 256)
-		n := stat.Encode(entry)
-		buf = append(buf, entry[:n]...)
+		entry := make([]byte, stat.EncodedLen())
+		stat.Encode(entry)
+		buf = append(buf, entry...)
 	}
 
 	if offset >= int64(len(buf)) {
@@ -94,36 +121,62 @@ func (d *SessionDir) Read(p []byte, offset int64) (int, error) {
 
 // Stat returns the directory's metadata.
 func (d *SessionDir) Stat() protocol.Stat {
-	s := d.BaseFile.Stat()
+	s := sessionStat(d.BaseFile, d.sm, d.id)
 	s.Qid.Type = protocol.QTDIR
 	return s
 }
 
 // SessionsDir is the root /n/llm directory.
-// Contains only the "new" file plus dynamically created session directories.
+// Contains only the "new" file plus dynamically created session directories,
+// numbered (from reading new) or named (from mkdir).
 type SessionsDir struct {
 	*protocol.BaseFile
 	sm      *llm.SessionManager
-	newFile *NewFile
+	newFile *NewFile // nil if the directory cannot create sessions
+	owner   string   // if set, only this user's sessions are visible
 }
 
 // NewSessionsDir creates the root LLM directory.
 func NewSessionsDir(sm *llm.SessionManager) *SessionsDir {
 	return &SessionsDir{
-		BaseFile: protocol.NewBaseFile("llm", protocol.DMDIR|0555),
+		BaseFile: protocol.NewBaseFile("llm", protocol.DMDIR|0755),
 		sm:       sm,
 		newFile:  NewNewFile(sm),
 	}
 }
 
+// NewUserSessionsDir creates a root LLM directory for owner. It lists
+// only sessions owned by owner, and sessions made in it belong to owner.
+func NewUserSessionsDir(sm *llm.SessionManager, owner string) *SessionsDir {
+	d := NewSessionsDir(sm)
+	d.owner = owner
+	d.newFile.owner = owner
+	d.Uid_ = owner
+	return d
+}
+
+// visible reports whether session id may be seen in this directory.
+func (d *SessionsDir) visible(id int) bool {
+	if d.owner == "" {
+		return true
+	}
+	session := d.sm.Get(id)
+	return session != nil && session.Owner() == d.owner
+}
+
 // Children returns the files in the root directory.
 // This includes "new" plus all active session directories.
 func (d *SessionsDir) Children() []protocol.File {
-	children := []protocol.File{d.newFile}
+	var children []protocol.File
+	if d.newFile != nil {
+		children = append(children, d.newFile)
+	}
 
 	// Add session directories for all active sessions
 	for _, id := range d.sm.ListSessions() {
-		children = append(children, NewSessionDir(d.sm, id))
+		if d.visible(id) {
+			children = append(children, NewSessionDir(d.sm, id))
+		}
 	}
 
 	return children
@@ -133,32 +186,64 @@ func (d *SessionsDir) Children() []protocol.File {
 func (d *SessionsDir) Lookup(name string) (protocol.File, error) {
 	// Check for "new" file
 	if name == "new" {
+		if d.newFile == nil {
+			return nil, protocol.ErrNotFound
+		}
 		return d.newFile, nil
 	}
 
-	// Try to parse as session ID
-	id, err := strconv.Atoi(name)
-	if err != nil {
+	// Session ID or name, among the owner's sessions if there is one
+	var id int
+	var ok bool
+	if d.owner == "" {
+		id, ok = d.sm.Lookup(name)
+	} else {
+		id, ok = d.sm.LookupFor(name, d.owner)
+	}
+	if !ok || !d.visible(id) {
 		return nil, protocol.ErrNotFound
 	}
 
-	// Check if session exists
-	session := d.sm.Get(id)
-	if session == nil {
-		return nil, protocol.ErrNotFound
+	return NewSessionDir(d.sm, id), nil
+}
+
+// Create makes a named session with default settings, so that
+// "mkdir /n/llm/reviewbot" gives a session scripts can refer to by name.
+// Only directories can be created.
+func (d *SessionsDir) Create(name string, perm uint32) (protocol.File, error) {
+	if perm&protocol.DMDIR == 0 {
+		return nil, protocol.ErrPermission
 	}
 
+	id, err := d.sm.CreateNamedFor(name, d.owner)
+	if err != nil {
+		return nil, sessionError(err, name)
+	}
 	return NewSessionDir(d.sm, id), nil
 }
 
+// sessionError converts an error from naming a session to the error the
+// client sees.
+func sessionError(err error, name string) error {
+	switch {
+	case errors.Is(err, llm.ErrSessionExists):
+		return protocol.ErrExists
+	case errors.Is(err, llm.ErrBadSessionName):
+		return protocol.Errorf(protocol.EINVAL, "bad session name %q: names may not be numbers or \"new\"", name)
+	case errors.Is(err, llm.ErrSessionNotFound):
+		return protocol.ErrNotFound
+	}
+	return err
+}
+
 // Read returns directory listing as packed stat entries.
 func (d *SessionsDir) Read(p []byte, offset int64) (int, error) {
 	var buf []byte
 	for _, f := range d.Children() {
 		stat := f.Stat()
-		entry := make([]byte, 256)
-		n := stat.Encode(entry)
-		buf = append(buf, entry[:n]...)
+		entry := make([]byte, stat.EncodedLen())
+		stat.Encode(entry)
+		buf = append(buf, entry...)
 	}
 
 	if offset >= int64(len(buf)) {
@@ -169,10 +254,24 @@ func (d *SessionsDir) Read(p []byte, offset int64) (int, error) {
 	return n, nil
 }
 
-// Stat returns the directory's metadata.
+// Stat returns the directory's metadata. The qid version and times change
+// whenever a session it lists is created, renamed or closed.
 func (d *SessionsDir) Stat() protocol.Stat {
 	s := d.BaseFile.Stat()
 	s.Qid.Type = protocol.QTDIR
+
+	var version uint32
+	var modified time.Time
+	if d.owner == "" {
+		version, modified = d.sm.ListVersion()
+	} else {
+		version, modified = d.sm.ListVersionFor(d.owner)
+	}
+	s.Qid.Version = version
+	if !modified.IsZero() {
+		s.Atime = uint32(modified.Unix())
+		s.Mtime = uint32(modified.Unix())
+	}
 	return s
 }
 
@@ -0,0 +1,265 @@
+package llmfs
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestSessionsDir_CreateNamed(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	root := NewSessionsDir(sm)
+	numbered := sm.Create()
+
+	f, err := root.Create("reviewbot", protocol.DMDIR|0755)
+	if err != nil {
+		t.Fatalf("Create(reviewbot) error: %v", err)
+	}
+	if name := f.Stat().Name; name != "reviewbot" {
+		t.Errorf("created dir name = %q, want reviewbot", name)
+	}
+
+	// Named and numbered sessions live side by side.
+	if _, err := root.Lookup("reviewbot"); err != nil {
+		t.Errorf("Lookup(reviewbot) error: %v", err)
+	}
+	if _, err := root.Lookup(strconv.Itoa(numbered)); err != nil {
+		t.Errorf("Lookup(%d) error: %v", numbered, err)
+	}
+	names := map[string]bool{}
+	for _, child := range root.Children() {
+		names[child.Stat().Name] = true
+	}
+	if !names["reviewbot"] || !names[strconv.Itoa(numbered)] || !names["new"] {
+		t.Errorf("children = %v, want new, %d and reviewbot", names, numbered)
+	}
+
+	// The named session has its own ID but is only reachable by name.
+	id, _ := sm.Lookup("reviewbot")
+	if _, err := root.Lookup(strconv.Itoa(id)); err == nil {
+		t.Errorf("named session reachable as %d", id)
+	}
+
+	if _, err := root.Create("reviewbot", protocol.DMDIR|0755); protocol.ErrnoOf(err) != protocol.EEXIST {
+		t.Errorf("second Create(reviewbot) error = %v, want EEXIST", err)
+	}
+
+	sm.Close(id)
+	if _, err := root.Lookup("reviewbot"); err == nil {
+		t.Error("closed session still found by name")
+	}
+	if _, err := root.Create("reviewbot", protocol.DMDIR|0755); err != nil {
+		t.Errorf("Create after close error: %v", err)
+	}
+}
+
+func TestSessionsDir_CreateRejects(t *testing.T) {
+	root := NewSessionsDir(llm.NewSessionManager(NewMockBackend()))
+
+	for _, name := range []string{"new", "42", "007"} {
+		if _, err := root.Create(name, protocol.DMDIR|0755); protocol.ErrnoOf(err) != protocol.EINVAL {
+			t.Errorf("Create(%q) error = %v, want EINVAL", name, err)
+		}
+	}
+	if _, err := root.Create("notes", 0644); err != protocol.ErrPermission {
+		t.Errorf("Create of a plain file error = %v, want %v", err, protocol.ErrPermission)
+	}
+}
+
+func TestSessionDir_RemoveLikeRmR(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	defaults := sm.Defaults()
+	id := sm.Create()
+	session := sm.Get(id)
+	session.SetModel("other-model")
+	session.SetTemperature(1.5)
+	session.SetSystemPrompt("be terse")
+	session.SetThinkingTokens(2048)
+	session.SetPrefill("{")
+	session.AddMessage("user", "hello")
+	session.SetLastResponse("hi")
+
+	// rm -r removes every file before the directory itself.
+	dir := NewSessionDir(sm, id)
+	for _, f := range dir.Children() {
+		r, ok := f.(protocol.Remover)
+		if !ok {
+			t.Fatalf("%s cannot be removed", f.Stat().Name)
+		}
+		if err := r.Remove(); err != nil {
+			t.Fatalf("remove %s: %v", f.Stat().Name, err)
+		}
+	}
+
+	if got := session.Model(); got != defaults.Model {
+		t.Errorf("model = %q, want default %q", got, defaults.Model)
+	}
+	if got := session.Temperature(); got != defaults.Temperature {
+		t.Errorf("temperature = %v, want default %v", got, defaults.Temperature)
+	}
+	if got := session.SystemPrompt(); got != defaults.SystemPrompt {
+		t.Errorf("system = %q, want default %q", got, defaults.SystemPrompt)
+	}
+	if got := session.ThinkingTokens(); got != defaults.ThinkingTokens {
+		t.Errorf("thinking = %d, want default %d", got, defaults.ThinkingTokens)
+	}
+	if got := session.Prefill(); got != defaults.Prefill {
+		t.Errorf("prefill = %q, want default %q", got, defaults.Prefill)
+	}
+	if len(session.Messages()) != 0 || session.LastResponse() != "" {
+		t.Error("conversation not cleared")
+	}
+
+	if err := dir.Remove(); err != nil {
+		t.Fatalf("remove session dir: %v", err)
+	}
+	if sm.Get(id) != nil || !session.IsClosed() {
+		t.Error("session still open after removing its directory")
+	}
+	if err := dir.Remove(); err != protocol.ErrNotFound {
+		t.Errorf("second remove error = %v, want %v", err, protocol.ErrNotFound)
+	}
+}
+
+func TestSessionDir_Rename(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	root := NewSessionsDir(sm)
+	id := sm.Create()
+	if _, err := sm.CreateNamed("taken"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := NewSessionDir(sm, id)
+	if err := dir.Rename("reviewbot"); err != nil {
+		t.Fatalf("Rename(reviewbot) error: %v", err)
+	}
+	if _, err := root.Lookup(strconv.Itoa(id)); err == nil {
+		t.Error("renamed session still found by number")
+	}
+	f, err := root.Lookup("reviewbot")
+	if err != nil {
+		t.Fatalf("Lookup(reviewbot) error: %v", err)
+	}
+	if name := f.Stat().Name; name != "reviewbot" {
+		t.Errorf("renamed dir name = %q, want reviewbot", name)
+	}
+
+	if err := dir.Rename("taken"); protocol.ErrnoOf(err) != protocol.EEXIST {
+		t.Errorf("Rename to an existing name error = %v, want EEXIST", err)
+	}
+	if err := dir.Rename("7"); protocol.ErrnoOf(err) != protocol.EINVAL {
+		t.Errorf("Rename to a number error = %v, want EINVAL", err)
+	}
+
+	if err := dir.Rename("second"); err != nil {
+		t.Fatalf("second Rename error: %v", err)
+	}
+	if _, err := root.Lookup("reviewbot"); err == nil {
+		t.Error("old name still found after rename")
+	}
+}
+
+func TestSessionDir_StableQids(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	root := NewSessionsDir(sm)
+	id := sm.Create()
+	name := strconv.Itoa(id)
+
+	walk := func(file string) protocol.Qid {
+		t.Helper()
+		d, err := root.Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup(%s) error: %v", name, err)
+		}
+		f, err := d.(protocol.Dir).Lookup(file)
+		if err != nil {
+			t.Fatalf("Lookup(%s) error: %v", file, err)
+		}
+		return f.Stat().Qid
+	}
+
+	// The same file has the same path on every walk, and no two files
+	// share one.
+	paths := map[uint64]string{}
+	for _, file := range []string{"ask", "context", "ctl", "model", "temperature", "system", "thinking", "prefill"} {
+		q := walk(file)
+		if again := walk(file); again != q {
+			t.Errorf("%s: qid %+v, then %+v", file, q, again)
+		}
+		if other, dup := paths[q.Path]; dup {
+			t.Errorf("%s and %s share qid path %#x", file, other, q.Path)
+		}
+		paths[q.Path] = file
+	}
+	other := sm.Create()
+	d, _ := root.Lookup(strconv.Itoa(other))
+	f, _ := d.(protocol.Dir).Lookup("model")
+	if f.Stat().Qid.Path == walk("model").Path {
+		t.Error("two sessions' model files share a qid path")
+	}
+
+	// Changing the session changes the version.
+	before := walk("model")
+	d, _ = root.Lookup(name)
+	f, _ = d.(protocol.Dir).Lookup("model")
+	if _, err := f.Write([]byte("other-model\n"), 0); err != nil {
+		t.Fatalf("Write(model) error: %v", err)
+	}
+	after := walk("model")
+	if after.Path != before.Path || after.Version == before.Version {
+		t.Errorf("qid after write = %+v, before = %+v; want same path, new version", after, before)
+	}
+	if v := walk("model").Version; v != after.Version {
+		t.Errorf("version changed from %d to %d without a write", after.Version, v)
+	}
+}
+
+func TestSessionsDir_VersionChanges(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	root := NewSessionsDir(sm)
+	alice := NewUserSessionsDir(sm, "alice")
+
+	// Each step changes the listing, and so the root's qid version.
+	version := root.Stat().Qid.Version
+	step := func(what string) {
+		t.Helper()
+		v := root.Stat().Qid.Version
+		if v == version {
+			t.Errorf("root version still %d after %s", v, what)
+		}
+		version = v
+	}
+
+	id := sm.Create()
+	step("Create")
+	if _, err := root.Create("reviewbot", protocol.DMDIR|0755); err != nil {
+		t.Fatalf("Create(reviewbot) error: %v", err)
+	}
+	step("mkdir")
+	if err := sm.Rename(id, "scratch"); err != nil {
+		t.Fatalf("Rename error: %v", err)
+	}
+	step("Rename")
+	sm.Close(id)
+	step("Close")
+
+	// A change within a session leaves the listing alone.
+	other, _ := sm.Lookup("reviewbot")
+	sm.Get(other).AddMessage("user", "hello")
+	if v := root.Stat().Qid.Version; v != version {
+		t.Errorf("root version changed from %d to %d without a session created or removed", version, v)
+	}
+
+	// A user's root changes only with their own sessions.
+	before := alice.Stat().Qid.Version
+	sm.CreateFor("bob")
+	if v := alice.Stat().Qid.Version; v != before {
+		t.Errorf("alice's root version changed from %d to %d for bob's session", before, v)
+	}
+	sm.CreateFor("alice")
+	if v := alice.Stat().Qid.Version; v == before {
+		t.Errorf("alice's root version still %d after her own session", v)
+	}
+}
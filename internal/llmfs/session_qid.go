@@ -0,0 +1,52 @@
+package llmfs
+
+import (
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// The files of a session are made afresh on every walk, so their qid paths
+// are derived from the session ID and the kind of file rather than taken
+// from protocol.NextPath. Caching clients then see the same file each time.
+const (
+	kindSessionDir = iota
+	kindAsk
+	kindContext
+	kindCtl
+	kindModel
+	kindTemperature
+	kindSystem
+	kindThinking
+	kindPrefill
+)
+
+// sessionPathBit keeps session qid paths clear of those from NextPath,
+// which count up from 1.
+const sessionPathBit = 1 << 63
+
+// sessionPath returns the qid path of the file of the given kind in
+// session id. Session IDs are never reused, so neither are paths.
+func sessionPath(id, kind int) uint64 {
+	return sessionPathBit | uint64(id)<<8 | uint64(kind)
+}
+
+// newSessionBase creates the BaseFile for the file of the given kind in
+// session id.
+func newSessionBase(id, kind int, name string, mode uint32) *protocol.BaseFile {
+	b := protocol.NewBaseFile(name, mode)
+	b.Qid_.Path = sessionPath(id, kind)
+	return b
+}
+
+// sessionStat returns base's metadata with the qid version and times of
+// session id, so that they change whenever the session does.
+func sessionStat(base *protocol.BaseFile, sm *llm.SessionManager, id int) protocol.Stat {
+	s := base.Stat()
+	if session := sm.Get(id); session != nil {
+		version, modified := session.Version()
+		s.Qid.Version = version
+		s.Atime = uint32(modified.Unix())
+		s.Mtime = uint32(modified.Unix())
+	}
+	return s
+}
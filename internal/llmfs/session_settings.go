@@ -1,8 +1,8 @@
 package llmfs
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"strconv"
 	"strings"
 
@@ -10,6 +10,10 @@ import (
 	"github.com/NERVsystems/llm9p/internal/protocol"
 )
 
+// errPartialSetting is returned for a direct write into the middle of a
+// setting, which is only ever set whole.
+var errPartialSetting = protocol.Errorf(protocol.EINVAL, "settings are written whole, at offset 0")
+
 // SessionModelFile controls the model for a session: /n/llm/N/model
 type SessionModelFile struct {
 	*protocol.BaseFile
@@ -20,32 +24,40 @@ type SessionModelFile struct {
 // NewSessionModelFile creates a model file for the given session.
 func NewSessionModelFile(sm *llm.SessionManager, id int) *SessionModelFile {
 	return &SessionModelFile{
-		BaseFile: protocol.NewBaseFile("model", 0666),
+		BaseFile: newSessionBase(id, kindModel, "model", 0666),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the current model name.
-func (f *SessionModelFile) Read(p []byte, offset int64) (int, error) {
+func (f *SessionModelFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
+	return session.Model() + "\n", nil
+}
 
-	content := session.Model() + "\n"
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
-	return copy(p, content[offset:]), nil
+// Open captures the current model name for this fid.
+func (f *SessionModelFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSetting(f, f.content)
 }
 
-// Write sets the model name.
+// Read returns the current model name.
+func (f *SessionModelFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Write sets the model name to p. Writes through an open fid are
+// gathered by its settingHandle and set here when the fid is clunked.
 func (f *SessionModelFile) Write(p []byte, offset int64) (int, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
 		return 0, protocol.ErrNotFound
 	}
+	if offset != 0 {
+		return 0, errPartialSetting
+	}
 
 	model := strings.TrimSpace(string(p))
 	if model != "" {
@@ -54,9 +66,28 @@ func (f *SessionModelFile) Write(p []byte, offset int64) (int, error) {
 	return len(p), nil
 }
 
+// Truncate does nothing: the model name cannot be empty, so a truncating
+// open is accepted and the write that follows sets the new value.
+func (f *SessionModelFile) Truncate(length uint64) error {
+	if f.sm.Get(f.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return nil
+}
+
+// Remove resets the model to the default for new sessions.
+func (f *SessionModelFile) Remove() error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	session.SetModel(f.sm.Defaults().Model)
+	return nil
+}
+
 // Stat returns the file's metadata.
 func (f *SessionModelFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	session := f.sm.Get(f.id)
 	if session != nil {
 		s.Length = uint64(len(session.Model()) + 1)
@@ -74,47 +105,89 @@ type SessionTemperatureFile struct {
 // NewSessionTemperatureFile creates a temperature file for the given session.
 func NewSessionTemperatureFile(sm *llm.SessionManager, id int) *SessionTemperatureFile {
 	return &SessionTemperatureFile{
-		BaseFile: protocol.NewBaseFile("temperature", 0666),
+		BaseFile: newSessionBase(id, kindTemperature, "temperature", 0666),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the current temperature.
-func (f *SessionTemperatureFile) Read(p []byte, offset int64) (int, error) {
+func (f *SessionTemperatureFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
+	return fmt.Sprintf("%.2f\n", session.Temperature()), nil
+}
 
-	content := fmt.Sprintf("%.2f\n", session.Temperature())
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
-	return copy(p, content[offset:]), nil
+// Open captures the current temperature for this fid.
+func (f *SessionTemperatureFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSetting(f, f.content)
 }
 
-// Write sets the temperature.
+// Read returns the current temperature.
+func (f *SessionTemperatureFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Write sets the temperature to p. Writes through an open fid are
+// gathered by its settingHandle and set here when the fid is clunked.
 func (f *SessionTemperatureFile) Write(p []byte, offset int64) (int, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
 		return 0, protocol.ErrNotFound
 	}
+	if offset != 0 {
+		return 0, errPartialSetting
+	}
 
+	temp, err := parseTemperature(p)
+	if err != nil {
+		return 0, err
+	}
+	session.SetTemperature(temp)
+	return len(p), nil
+}
+
+// validate checks a temperature written through an open fid.
+func (f *SessionTemperatureFile) validate(value []byte) error {
+	_, err := parseTemperature(value)
+	return err
+}
+
+// parseTemperature parses a temperature between 0.0 and 2.0.
+func parseTemperature(p []byte) (float64, error) {
 	temp, err := strconv.ParseFloat(strings.TrimSpace(string(p)), 64)
 	if err != nil {
-		return 0, protocol.Error("invalid temperature: " + err.Error())
+		return 0, protocol.Errorf(protocol.EINVAL, "invalid temperature: %v", err)
 	}
 	if temp < 0.0 || temp > 2.0 {
-		return 0, protocol.Error("temperature must be between 0.0 and 2.0")
+		return 0, protocol.Errorf(protocol.EINVAL, "temperature must be between 0.0 and 2.0")
 	}
-	session.SetTemperature(temp)
-	return len(p), nil
+	return temp, nil
+}
+
+// Truncate does nothing: the temperature cannot be empty, so a truncating
+// open is accepted and the write that follows sets the new value.
+func (f *SessionTemperatureFile) Truncate(length uint64) error {
+	if f.sm.Get(f.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return nil
+}
+
+// Remove resets the temperature to the default for new sessions.
+func (f *SessionTemperatureFile) Remove() error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	session.SetTemperature(f.sm.Defaults().Temperature)
+	return nil
 }
 
 // Stat returns the file's metadata.
 func (f *SessionTemperatureFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	session := f.sm.Get(f.id)
 	if session != nil {
 		s.Length = uint64(len(fmt.Sprintf("%.2f\n", session.Temperature())))
@@ -132,44 +205,76 @@ type SessionSystemFile struct {
 // NewSessionSystemFile creates a system file for the given session.
 func NewSessionSystemFile(sm *llm.SessionManager, id int) *SessionSystemFile {
 	return &SessionSystemFile{
-		BaseFile: protocol.NewBaseFile("system", 0666),
+		BaseFile: newSessionBase(id, kindSystem, "system", 0666),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the current system prompt.
-func (f *SessionSystemFile) Read(p []byte, offset int64) (int, error) {
+func (f *SessionSystemFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
 
 	content := session.SystemPrompt()
 	if content != "" && !strings.HasSuffix(content, "\n") {
 		content += "\n"
 	}
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
-	return copy(p, content[offset:]), nil
+	return content, nil
+}
+
+// Open captures the current system prompt for this fid.
+func (f *SessionSystemFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSetting(f, f.content)
 }
 
-// Write sets the system prompt.
+// Read returns the current system prompt.
+func (f *SessionSystemFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Write sets the system prompt to p. Writes through an open fid are
+// gathered by its settingHandle and set here when the fid is clunked.
 func (f *SessionSystemFile) Write(p []byte, offset int64) (int, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
 		return 0, protocol.ErrNotFound
 	}
+	if offset != 0 {
+		return 0, errPartialSetting
+	}
 
 	prompt := strings.TrimSpace(string(p))
 	session.SetSystemPrompt(prompt)
 	return len(p), nil
 }
 
+// Truncate shortens the system prompt; truncating to zero clears it.
+func (f *SessionSystemFile) Truncate(length uint64) error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	if prompt := session.SystemPrompt(); length < uint64(len(prompt)) {
+		session.SetSystemPrompt(prompt[:length])
+	}
+	return nil
+}
+
+// Remove resets the system prompt to the default for new sessions.
+func (f *SessionSystemFile) Remove() error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	session.SetSystemPrompt(f.sm.Defaults().SystemPrompt)
+	return nil
+}
+
 // Stat returns the file's metadata.
 func (f *SessionSystemFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	session := f.sm.Get(f.id)
 	if session != nil {
 		content := session.SystemPrompt()
@@ -190,62 +295,103 @@ type SessionThinkingFile struct {
 // NewSessionThinkingFile creates a thinking file for the given session.
 func NewSessionThinkingFile(sm *llm.SessionManager, id int) *SessionThinkingFile {
 	return &SessionThinkingFile{
-		BaseFile: protocol.NewBaseFile("thinking", 0666),
+		BaseFile: newSessionBase(id, kindThinking, "thinking", 0666),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the current thinking token budget.
-func (f *SessionThinkingFile) Read(p []byte, offset int64) (int, error) {
+func (f *SessionThinkingFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
 
 	tokens := session.ThinkingTokens()
-	var content string
 	switch {
 	case tokens < 0:
-		content = "max\n"
+		return "max\n", nil
 	case tokens == 0:
-		content = "disabled\n"
+		return "disabled\n", nil
 	default:
-		content = fmt.Sprintf("%d\n", tokens)
+		return fmt.Sprintf("%d\n", tokens), nil
 	}
+}
 
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
-	return copy(p, content[offset:]), nil
+// Open captures the current thinking token budget for this fid.
+func (f *SessionThinkingFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSetting(f, f.content)
 }
 
-// Write sets the thinking token budget.
+// Read returns the current thinking token budget.
+func (f *SessionThinkingFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Write sets the thinking token budget to p. Writes through an open fid are
+// gathered by its settingHandle and set here when the fid is clunked.
 func (f *SessionThinkingFile) Write(p []byte, offset int64) (int, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
 		return 0, protocol.ErrNotFound
 	}
+	if offset != 0 {
+		return 0, errPartialSetting
+	}
+
+	tokens, err := parseThinking(p)
+	if err != nil {
+		return 0, err
+	}
+	session.SetThinkingTokens(tokens)
+	return len(p), nil
+}
 
-	value := strings.TrimSpace(string(p))
-	switch value {
+// validate checks a thinking budget written through an open fid.
+func (f *SessionThinkingFile) validate(value []byte) error {
+	_, err := parseThinking(value)
+	return err
+}
+
+// parseThinking parses a thinking budget: a number of tokens, "max" or
+// "disabled".
+func parseThinking(p []byte) (int, error) {
+	switch value := strings.TrimSpace(string(p)); value {
 	case "max", "-1":
-		session.SetThinkingTokens(-1)
+		return -1, nil
 	case "disabled", "off", "0":
-		session.SetThinkingTokens(0)
+		return 0, nil
 	default:
 		tokens, err := strconv.Atoi(value)
 		if err != nil {
-			return 0, protocol.Error("invalid thinking budget: " + err.Error())
+			return 0, protocol.Errorf(protocol.EINVAL, "invalid thinking budget: %v", err)
 		}
-		session.SetThinkingTokens(tokens)
+		return tokens, nil
 	}
-	return len(p), nil
+}
+
+// Truncate does nothing: the thinking budget cannot be empty, so a truncating
+// open is accepted and the write that follows sets the new value.
+func (f *SessionThinkingFile) Truncate(length uint64) error {
+	if f.sm.Get(f.id) == nil {
+		return protocol.ErrNotFound
+	}
+	return nil
+}
+
+// Remove resets the thinking budget to the default for new sessions.
+func (f *SessionThinkingFile) Remove() error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	session.SetThinkingTokens(f.sm.Defaults().ThinkingTokens)
+	return nil
 }
 
 // Stat returns the file's metadata.
 func (f *SessionThinkingFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	// Estimate length
 	s.Length = 16
 	return s
@@ -261,35 +407,45 @@ type SessionPrefillFile struct {
 // NewSessionPrefillFile creates a prefill file for the given session.
 func NewSessionPrefillFile(sm *llm.SessionManager, id int) *SessionPrefillFile {
 	return &SessionPrefillFile{
-		BaseFile: protocol.NewBaseFile("prefill", 0666),
+		BaseFile: newSessionBase(id, kindPrefill, "prefill", 0666),
 		sm:       sm,
 		id:       id,
 	}
 }
 
-// Read returns the current prefill string.
-func (f *SessionPrefillFile) Read(p []byte, offset int64) (int, error) {
+func (f *SessionPrefillFile) content() (string, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
-		return 0, protocol.ErrNotFound
+		return "", protocol.ErrNotFound
 	}
 
 	content := session.Prefill()
 	if content != "" && !strings.HasSuffix(content, "\n") {
 		content += "\n"
 	}
-	if offset >= int64(len(content)) {
-		return 0, io.EOF
-	}
-	return copy(p, content[offset:]), nil
+	return content, nil
 }
 
-// Write sets the prefill string.
+// Open captures the current prefill string for this fid.
+func (f *SessionPrefillFile) Open(ctx context.Context, mode uint8) (protocol.Handle, error) {
+	return openSetting(f, f.content)
+}
+
+// Read returns the current prefill string.
+func (f *SessionPrefillFile) Read(p []byte, offset int64) (int, error) {
+	return readContent(f.content, p, offset)
+}
+
+// Write sets the prefill string to p. Writes through an open fid are
+// gathered by its settingHandle and set here when the fid is clunked.
 func (f *SessionPrefillFile) Write(p []byte, offset int64) (int, error) {
 	session := f.sm.Get(f.id)
 	if session == nil {
 		return 0, protocol.ErrNotFound
 	}
+	if offset != 0 {
+		return 0, errPartialSetting
+	}
 
 	// Don't trim - prefill may have intentional trailing space
 	prefill := string(p)
@@ -299,9 +455,31 @@ func (f *SessionPrefillFile) Write(p []byte, offset int64) (int, error) {
 	return len(p), nil
 }
 
+// Truncate shortens the prefill; truncating to zero clears it.
+func (f *SessionPrefillFile) Truncate(length uint64) error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	if prefill := session.Prefill(); length < uint64(len(prefill)) {
+		session.SetPrefill(prefill[:length])
+	}
+	return nil
+}
+
+// Remove resets the prefill to the default for new sessions.
+func (f *SessionPrefillFile) Remove() error {
+	session := f.sm.Get(f.id)
+	if session == nil {
+		return protocol.ErrNotFound
+	}
+	session.SetPrefill(f.sm.Defaults().Prefill)
+	return nil
+}
+
 // Stat returns the file's metadata.
 func (f *SessionPrefillFile) Stat() protocol.Stat {
-	s := f.BaseFile.Stat()
+	s := sessionStat(f.BaseFile, f.sm, f.id)
 	session := f.sm.Get(f.id)
 	if session != nil {
 		content := session.Prefill()
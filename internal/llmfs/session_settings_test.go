@@ -0,0 +1,160 @@
+package llmfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestSessionTemperatureFile_InvalidErrno(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	id := sm.Create()
+
+	f := NewSessionTemperatureFile(sm, id)
+	for _, input := range []string{"hot\n", "5.0\n"} {
+		_, err := f.Write([]byte(input), 0)
+		if err == nil {
+			t.Fatalf("Write(%q) succeeded, want error", input)
+		}
+		if errno := protocol.ErrnoOf(err); errno != protocol.EINVAL {
+			t.Errorf("Write(%q) errno = %d, want EINVAL", input, errno)
+		}
+	}
+}
+
+func TestSessionModelFile_OpenSnapshot(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	id := sm.Create()
+	sm.Get(id).SetModel("first-model")
+
+	f := NewSessionModelFile(sm, id)
+	h, err := f.Open(context.Background(), protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	sm.Get(id).SetModel("second-model")
+
+	buf := make([]byte, 100)
+	n, _ := h.Read(context.Background(), buf, 0)
+	if got := string(buf[:n]); got != "first-model\n" {
+		t.Errorf("Read() after change = %q, want the model at open time", got)
+	}
+}
+
+func TestSessionSettings_Truncate(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	id := sm.Create()
+	session := sm.Get(id)
+	session.SetSystemPrompt("be terse")
+	session.SetPrefill("{")
+	session.SetModel("some-model")
+
+	for _, f := range []protocol.Truncater{
+		NewSessionSystemFile(sm, id),
+		NewSessionPrefillFile(sm, id),
+		NewSessionModelFile(sm, id),
+		NewSessionTemperatureFile(sm, id),
+		NewSessionThinkingFile(sm, id),
+		NewSessionAskFile(sm, id),
+	} {
+		if err := f.Truncate(0); err != nil {
+			t.Errorf("%T.Truncate(0) error: %v", f, err)
+		}
+	}
+
+	if got := session.SystemPrompt(); got != "" {
+		t.Errorf("system after truncate = %q, want empty", got)
+	}
+	if got := session.Prefill(); got != "" {
+		t.Errorf("prefill after truncate = %q, want empty", got)
+	}
+	// Truncation leaves values that cannot be empty alone; the write
+	// that follows sets them.
+	if got := session.Model(); got != "some-model" {
+		t.Errorf("model after truncate = %q, want unchanged", got)
+	}
+}
+
+func TestSessionSettings_ChunkedWrite(t *testing.T) {
+	sm := llm.NewSessionManager(NewMockBackend())
+	id := sm.Create()
+	session := sm.Get(id)
+	ctx := context.Background()
+
+	// A value larger than one message arrives in several Twrites; it is
+	// set whole when the fid is clunked.
+	f := NewSessionSystemFile(sm, id)
+	h, err := f.Open(ctx, protocol.OWRITE|protocol.OTRUNC)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	first, second := "You are a careful reviewer. ", "Answer in one line."
+	if _, err := h.Write(ctx, []byte(first), 0); err != nil {
+		t.Fatalf("Write at 0 error: %v", err)
+	}
+	if _, err := h.Write(ctx, []byte(second+"\n"), int64(len(first))); err != nil {
+		t.Fatalf("Write at %d error: %v", len(first), err)
+	}
+	if got := session.SystemPrompt(); got != "" {
+		t.Errorf("system before clunk = %q, want unset", got)
+	}
+	if err := h.Close(ctx); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if got, want := session.SystemPrompt(), first+second; got != want {
+		t.Errorf("system = %q, want %q", got, want)
+	}
+
+	// Writes may not leave a gap.
+	h, _ = f.Open(ctx, protocol.OWRITE)
+	if _, err := h.Write(ctx, []byte("x"), 5); protocol.ErrnoOf(err) != protocol.EINVAL {
+		t.Errorf("Write past the end error = %v, want EINVAL", err)
+	}
+	h.Close(ctx)
+
+	// An invalid value fails its Twrite, since clients ignore errors from
+	// Tclunk, and is not set.
+	for _, f := range []protocol.File{NewSessionTemperatureFile(sm, id), NewSessionThinkingFile(sm, id)} {
+		h, _ = f.Open(ctx, protocol.OWRITE)
+		if _, err := h.Write(ctx, []byte("banana\n"), 0); protocol.ErrnoOf(err) != protocol.EINVAL {
+			t.Errorf("%T: Write(banana) error = %v, want EINVAL", f, err)
+		}
+		if err := h.Close(ctx); err != nil {
+			t.Errorf("%T: Close() after a failed write error = %v", f, err)
+		}
+	}
+
+	// A fid closed with a cancelled context, as when the connection drops,
+	// sets nothing.
+	h, _ = f.Open(ctx, protocol.OWRITE)
+	h.Write(ctx, []byte("half a prompt"), 0)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	h.Close(cancelled)
+	if got, want := session.SystemPrompt(), first+second; got != want {
+		t.Errorf("system after dropped fid = %q, want %q kept", got, want)
+	}
+
+	// Tfsync sets what has been written so far; later writes carry on
+	// from it.
+	h, _ = f.Open(ctx, protocol.OWRITE|protocol.OTRUNC)
+	h.Write(ctx, []byte("Be brief."), 0)
+	if err := h.(protocol.Syncer).Sync(ctx); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if got := session.SystemPrompt(); got != "Be brief." {
+		t.Errorf("system after Sync = %q, want %q", got, "Be brief.")
+	}
+	h.Write(ctx, []byte(" Very."), 9)
+	h.Close(ctx)
+	if got := session.SystemPrompt(); got != "Be brief. Very." {
+		t.Errorf("system after Close = %q, want %q", got, "Be brief. Very.")
+	}
+
+	// Writing a file directly sets the whole value, so only offset 0 is valid.
+	if _, err := f.Write([]byte("tail"), 3); protocol.ErrnoOf(err) != protocol.EINVAL {
+		t.Errorf("direct Write at offset 3 error = %v, want EINVAL", err)
+	}
+}
@@ -36,7 +36,7 @@ func (f *ModelFile) Read(p []byte, offset int64) (int, error) {
 func (f *ModelFile) Write(p []byte, offset int64) (int, error) {
 	model := strings.TrimSpace(string(p))
 	if model == "" {
-		return 0, fmt.Errorf("model name cannot be empty")
+		return 0, protocol.Errorf(protocol.EINVAL, "model name cannot be empty")
 	}
 	f.client.SetModel(model)
 	return len(p), nil
@@ -75,7 +75,7 @@ func (f *TemperatureFile) Write(p []byte, offset int64) (int, error) {
 	tempStr := strings.TrimSpace(string(p))
 	temp, err := strconv.ParseFloat(tempStr, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid temperature: %w", err)
+		return 0, protocol.Errorf(protocol.EINVAL, "invalid temperature: %v", err)
 	}
 	if err := f.client.SetTemperature(temp); err != nil {
 		return 0, err
@@ -43,6 +43,22 @@ func (f *ChunkFile) Read(p []byte, offset int64) (int, error) {
 	return n, nil
 }
 
+// ReadContext is Read that gives up when the read is flushed, for backends
+// whose stream can be waited for with a context. Chunks still buffered when
+// the stream finishes are returned before EOF.
+func (f *ChunkFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	cr, ok := f.client.(llm.ContextStreamReader)
+	if !ok {
+		return f.Read(p, offset)
+	}
+
+	chunk, err := cr.ReadStreamChunkContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, chunk), nil
+}
+
 func (f *ChunkFile) Write(p []byte, offset int64) (int, error) {
 	return 0, protocol.ErrPermission
 }
@@ -74,14 +90,26 @@ func (f *StreamAskFile) Read(p []byte, offset int64) (int, error) {
 }
 
 func (f *StreamAskFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// WriteContext starts a stream. The stream outlives the write, so it runs
+// on its own context; it is only tied to ctx until it has started, which
+// lets a flushed write abandon a stream that is still being set up.
+func (f *StreamAskFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
 	prompt := strings.TrimSpace(string(p))
 	if prompt == "" {
 		return len(p), nil
 	}
 
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+
 	// Start streaming - chunks will be available via stream/chunk
-	err := f.client.StartStream(context.Background(), prompt)
+	err := f.client.StartStream(streamCtx, prompt)
+	stop()
 	if err != nil {
+		cancel()
 		// Return error to indicate stream failed to start
 		return 0, err
 	}
@@ -0,0 +1,50 @@
+package llmfs
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+)
+
+func TestChunkFile_ReadContext(t *testing.T) {
+	ctx := context.Background()
+	b := llm.NewMockClient()
+	ask, chunk := NewStreamAskFile(b), NewChunkFile(b)
+
+	// A read waiting for a slow first chunk gives up when it is flushed.
+	b.SetLatency(5*time.Second, 0)
+	if _, err := ask.WriteContext(ctx, []byte("hello"), 0); err != nil {
+		t.Fatalf("starting the stream: %v", err)
+	}
+	flushed, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	buf := make([]byte, 100)
+	if _, err := chunk.ReadContext(flushed, buf, 0); err != context.DeadlineExceeded {
+		t.Errorf("ReadContext error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Without the wait, the chunks arrive and then EOF.
+	b = llm.NewMockClient()
+	ask, chunk = NewStreamAskFile(b), NewChunkFile(b)
+	if _, err := ask.WriteContext(ctx, []byte("hello world"), 0); err != nil {
+		t.Fatalf("starting the stream: %v", err)
+	}
+	var got strings.Builder
+	for {
+		n, err := chunk.ReadContext(ctx, buf, 0)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadContext error: %v", err)
+		}
+	}
+	if got.String() != "hello world" {
+		t.Errorf("streamed %q, want %q", got.String(), "hello world")
+	}
+}
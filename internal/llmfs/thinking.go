@@ -58,7 +58,7 @@ func (f *ThinkingFile) Write(p []byte, offset int64) (int, error) {
 		var err error
 		tokens, err = strconv.Atoi(input)
 		if err != nil {
-			return 0, fmt.Errorf("invalid thinking value: use 'max', 'off', or a number")
+			return 0, protocol.Errorf(protocol.EINVAL, "invalid thinking value: use 'max', 'off', or a number")
 		}
 		if tokens < 0 {
 			tokens = -1 // Treat any negative as max
@@ -36,7 +36,7 @@ func (f *UsageFile) Read(p []byte, offset int64) (int, error) {
 }
 
 func (f *UsageFile) Write(p []byte, offset int64) (int, error) {
-	return 0, fmt.Errorf("usage is read-only")
+	return 0, protocol.Errorf(protocol.EACCES, "usage is read-only")
 }
 
 func (f *UsageFile) Stat() protocol.Stat {
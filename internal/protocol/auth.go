@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the
+// TLS handshake before its first message.
+const tlsHandshakeTimeout = 30 * time.Second
+
+// Authenticator decides who may attach to the server. For each Tauth the
+// server calls Start, then the client reads and writes the afid to carry
+// out whatever exchange the conversation defines. A later Tattach naming
+// that afid succeeds only once the conversation reports the same user.
+type Authenticator interface {
+	// Start begins an authentication exchange for uname attaching to
+	// aname.
+	Start(uname, aname string) (AuthConversation, error)
+}
+
+// AuthConversation is one authentication exchange carried out over an
+// afid. Reads and writes on the afid go to the conversation in order,
+// without offsets.
+type AuthConversation interface {
+	// Read returns the next message for the client, such as a challenge,
+	// or io.EOF if the client has nothing to read.
+	Read(p []byte) (int, error)
+
+	// Write accepts the client's next message, such as a response or a
+	// password.
+	Write(p []byte) (int, error)
+
+	// User returns the authenticated user once the exchange has
+	// succeeded.
+	User() (string, bool)
+}
+
+// authFile is the file behind an afid.
+type authFile struct {
+	*BaseFile
+	conv AuthConversation
+}
+
+func newAuthFile(conv AuthConversation) *authFile {
+	f := &authFile{
+		BaseFile: NewBaseFile("auth", DMAUTH|0600),
+		conv:     conv,
+	}
+	f.Qid_.Type = QTAUTH
+	return f
+}
+
+func (f *authFile) Read(p []byte, offset int64) (int, error) {
+	return f.conv.Read(p)
+}
+
+func (f *authFile) Write(p []byte, offset int64) (int, error) {
+	return f.conv.Write(p)
+}
+
+// SetAuthenticator requires clients to authenticate with a before they
+// attach. With no authenticator (the default) Tauth is refused and any
+// Tattach is accepted.
+func (s *Server) SetAuthenticator(a Authenticator) {
+	s.auth = a
+}
+
+func (s *Server) handleAuth(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTauth(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	if s.auth == nil {
+		return s.errorResponse(state, buf, ErrNoAuth)
+	}
+
+	conv, err := s.auth.Start(msg.Uname, msg.Aname)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	// The client reads and writes the afid without opening it.
+	f := newAuthFile(conv)
+	if err := state.addFid(msg.Afid, f); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+	if err := state.setOpen(msg.Afid, f, NewFileHandle(f)); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RauthMsg{Aqid: f.Stat().Qid}
+	n := resp.Encode(buf)
+	return buf[:n], Rauth
+}
+
+// verifiedTLSUser completes the TLS handshake on conn, if it is a TLS
+// connection, and reports whether a client certificate was presented and
+// verified, and if so its common name, which may be empty.
+func verifiedTLSUser(conn net.Conn) (string, bool, error) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return "", false, err
+	}
+
+	chains := tc.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", false, nil
+	}
+	return chains[0][0].Subject.CommonName, true, nil
+}
+
+// checkAuth reports whether the client may attach as uname. A verified
+// TLS client certificate authenticates its common name and no other user,
+// and one without a common name authenticates no one; otherwise afid must
+// have completed authentication for uname.
+func (s *Server) checkAuth(state *clientState, afid uint32, uname string) error {
+	if state.tlsCert {
+		if state.tlsUser == "" || uname != state.tlsUser {
+			return ErrAuthFailed
+		}
+		return nil
+	}
+	if s.auth == nil {
+		return nil
+	}
+	if afid == NoFid {
+		return ErrAuthRequired
+	}
+
+	f, exists := state.fid(afid)
+	if !exists {
+		return ErrBadFid
+	}
+	af, ok := f.(*authFile)
+	if !ok {
+		return ErrAuthRequired
+	}
+	if user, ok := af.conv.User(); !ok || user != uname {
+		return ErrAuthFailed
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PasswordAuth authenticates users against a static table of passwords.
+// The client writes its password to the afid; there is nothing to read.
+//
+// The password travels in the clear, so use it over a trusted network or
+// TLS.
+type PasswordAuth struct {
+	users map[string][32]byte // user -> SHA-256 of password
+}
+
+// NewPasswordAuth returns an authenticator for the given user/password
+// pairs.
+func NewPasswordAuth(passwords map[string]string) *PasswordAuth {
+	a := &PasswordAuth{users: make(map[string][32]byte, len(passwords))}
+	for user, password := range passwords {
+		a.users[user] = sha256.Sum256([]byte(password))
+	}
+	return a
+}
+
+// LoadPasswordFile reads a password file. Each line is "user:password",
+// or "user:sha256:<hex>" to avoid storing the password itself. Blank lines
+// and lines starting with '#' are ignored.
+func LoadPasswordFile(path string) (*PasswordAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &PasswordAuth{users: make(map[string][32]byte)}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, password, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: want user:password", path, lineNo)
+		}
+		if digest, ok := strings.CutPrefix(password, "sha256:"); ok {
+			sum, err := hex.DecodeString(digest)
+			if err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("%s:%d: bad sha256 digest", path, lineNo)
+			}
+			a.users[user] = [32]byte(sum)
+			continue
+		}
+		a.users[user] = sha256.Sum256([]byte(password))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Start begins a password exchange for uname. Unknown users are not
+// rejected until they write a password, so the exchange does not reveal
+// which users exist.
+func (a *PasswordAuth) Start(uname, aname string) (AuthConversation, error) {
+	want, known := a.users[uname]
+	return &passwordConv{uname: uname, want: want, known: known}, nil
+}
+
+type passwordConv struct {
+	uname string
+	want  [32]byte
+	known bool
+
+	mu       sync.Mutex
+	answered bool
+	ok       bool
+}
+
+func (c *passwordConv) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *passwordConv) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.answered {
+		return 0, ErrAuthFailed
+	}
+	c.answered = true
+
+	got := sha256.Sum256([]byte(strings.TrimRight(string(p), "\r\n")))
+	if subtle.ConstantTimeCompare(got[:], c.want[:]) != 1 || !c.known {
+		return 0, ErrAuthFailed
+	}
+	c.ok = true
+	return len(p), nil
+}
+
+func (c *passwordConv) User() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.uname, c.ok
+}
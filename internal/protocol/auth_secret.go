@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+)
+
+// SecretAuth is a shared-secret challenge-response authenticator.
+//
+// Reading the afid returns a random hex challenge followed by a newline.
+// The client writes back the hex HMAC-SHA256, keyed by the secret, of the
+// challenge and the user name (see SecretResponse). The secret itself
+// never crosses the wire, and a response is good for one challenge and one
+// user only.
+type SecretAuth struct {
+	secret []byte
+}
+
+// NewSecretAuth returns an authenticator for clients that know secret.
+func NewSecretAuth(secret []byte) *SecretAuth {
+	return &SecretAuth{secret: secret}
+}
+
+// Start issues a fresh challenge for uname.
+func (a *SecretAuth) Start(uname, aname string) (AuthConversation, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &secretConv{
+		secret:    a.secret,
+		uname:     uname,
+		challenge: hex.EncodeToString(nonce),
+	}, nil
+}
+
+// SecretResponse computes the response a client writes to the afid for
+// the given challenge (without its trailing newline).
+func SecretResponse(secret []byte, uname, challenge string) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, challenge)
+	mac.Write([]byte{0})
+	io.WriteString(mac, uname)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type secretConv struct {
+	secret    []byte
+	uname     string
+	challenge string
+
+	mu       sync.Mutex
+	sent     int // bytes of the challenge line already read
+	answered bool
+	ok       bool
+}
+
+func (c *secretConv) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line := c.challenge + "\n"
+	if c.sent >= len(line) {
+		return 0, io.EOF
+	}
+	n := copy(p, line[c.sent:])
+	c.sent += n
+	return n, nil
+}
+
+func (c *secretConv) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// One attempt per challenge.
+	if c.answered {
+		return 0, ErrAuthFailed
+	}
+	c.answered = true
+
+	want := SecretResponse(c.secret, c.uname, c.challenge)
+	got := strings.TrimSpace(string(p))
+	if !hmac.Equal([]byte(got), []byte(want)) {
+		return 0, ErrAuthFailed
+	}
+	c.ok = true
+	return len(p), nil
+}
+
+func (c *secretConv) User() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.uname, c.ok
+}
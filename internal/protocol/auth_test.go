@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newAuthTestConn(t *testing.T, a Authenticator) *testConn {
+	srv := NewServer(NewStaticDir("root"))
+	srv.SetAuthenticator(a)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	return c
+}
+
+func expectError(t *testing.T, payload []byte, want error) {
+	t.Helper()
+	if ename, _ := DecodeString(payload); ename != want.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, want.Error())
+	}
+}
+
+func TestAuth_SecretChallengeResponse(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	c := newAuthTestConn(t, NewSecretAuth(secret))
+
+	c.rpc(1, &TauthMsg{Afid: 100, Uname: "glenda"}, Rauth)
+	payload := c.rpc(1, &TreadMsg{Fid: 100, Count: 200}, Rread)
+	challenge := strings.TrimSpace(string(payload[4:]))
+	if len(challenge) != 64 {
+		t.Fatalf("challenge = %q, want 64 hex digits", challenge)
+	}
+
+	// Attaching before answering the challenge is refused.
+	payload = c.rpc(1, &TattachMsg{Fid: 0, Afid: 100, Uname: "glenda"}, Rerror)
+	expectError(t, payload, ErrAuthFailed)
+
+	resp := SecretResponse(secret, "glenda", challenge)
+	c.rpc(1, &TwriteMsg{Fid: 100, Data: []byte(resp + "\n")}, Rwrite)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: 100, Uname: "glenda"}, Rattach)
+
+	// The afid only vouches for the user it was started for.
+	payload = c.rpc(1, &TattachMsg{Fid: 1, Afid: 100, Uname: "bootes"}, Rerror)
+	expectError(t, payload, ErrAuthFailed)
+}
+
+func TestAuth_SecretWrongResponse(t *testing.T) {
+	c := newAuthTestConn(t, NewSecretAuth([]byte("secret")))
+
+	c.rpc(1, &TauthMsg{Afid: 100, Uname: "glenda"}, Rauth)
+	payload := c.rpc(1, &TreadMsg{Fid: 100, Count: 200}, Rread)
+	challenge := strings.TrimSpace(string(payload[4:]))
+
+	resp := SecretResponse([]byte("guess"), "glenda", challenge)
+	payload = c.rpc(1, &TwriteMsg{Fid: 100, Data: []byte(resp)}, Rerror)
+	expectError(t, payload, ErrAuthFailed)
+
+	// No second attempt on the same challenge.
+	resp = SecretResponse([]byte("secret"), "glenda", challenge)
+	c.rpc(1, &TwriteMsg{Fid: 100, Data: []byte(resp)}, Rerror)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: 100, Uname: "glenda"}, Rerror)
+}
+
+func TestAuth_PasswordFile(t *testing.T) {
+	sum := sha256.Sum256([]byte("hunter2"))
+	path := filepath.Join(t.TempDir(), "passwd")
+	content := "# users\nglenda:plan9\n\nbootes:sha256:" + hex.EncodeToString(sum[:]) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadPasswordFile(path)
+	if err != nil {
+		t.Fatalf("LoadPasswordFile: %v", err)
+	}
+
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"glenda", "plan9", true},
+		{"bootes", "hunter2", true},
+		{"glenda", "hunter2", false},
+		{"nobody", "plan9", false},
+	}
+	for _, tt := range tests {
+		c := newAuthTestConn(t, a)
+		c.rpc(1, &TauthMsg{Afid: 100, Uname: tt.user}, Rauth)
+		if tt.ok {
+			c.rpc(1, &TwriteMsg{Fid: 100, Data: []byte(tt.password + "\n")}, Rwrite)
+			c.rpc(1, &TattachMsg{Fid: 0, Afid: 100, Uname: tt.user}, Rattach)
+		} else {
+			c.rpc(1, &TwriteMsg{Fid: 100, Data: []byte(tt.password + "\n")}, Rerror)
+			c.rpc(1, &TattachMsg{Fid: 0, Afid: 100, Uname: tt.user}, Rerror)
+		}
+	}
+}
+
+func TestAuth_AttachWithoutAfid(t *testing.T) {
+	c := newAuthTestConn(t, NewPasswordAuth(map[string]string{"glenda": "plan9"}))
+	payload := c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda"}, Rerror)
+	expectError(t, payload, ErrAuthRequired)
+}
+
+func TestAuth_NotRequired(t *testing.T) {
+	c := newTestConn(t, NewServer(NewStaticDir("root")))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+
+	payload := c.rpc(1, &TauthMsg{Afid: 100, Uname: "glenda"}, Rerror)
+	expectError(t, payload, ErrNoAuth)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda"}, Rattach)
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// minBufferClass is the smallest pooled buffer size, as a power of two.
+const minBufferClass = 9 // 512 bytes
+
+// bufferPools holds message buffers by size class. Connections negotiate
+// their own msize, so buffers are pooled in power-of-two classes to keep
+// the number of pools bounded however many distinct sizes clients ask for.
+var bufferPools [bits.UintSize]sync.Pool
+
+// bufferClass returns the pool index for buffers of size bytes.
+func bufferClass(size int) int {
+	if size <= 1<<minBufferClass {
+		return minBufferClass
+	}
+	return bits.Len(uint(size - 1))
+}
+
+// getBuffer returns a buffer of size bytes from the pool. Release it with
+// putBuffer once nothing refers to it.
+func getBuffer(size uint32) *[]byte {
+	class := bufferClass(int(size))
+	if b, ok := bufferPools[class].Get().(*[]byte); ok {
+		*b = (*b)[:size]
+		return b
+	}
+	b := make([]byte, size, 1<<class)
+	return &b
+}
+
+// putBuffer returns a buffer obtained from getBuffer to the pool.
+func putBuffer(b *[]byte) {
+	c := cap(*b)
+	class := bufferClass(c)
+	if c != 1<<class {
+		return // not one of ours
+	}
+	bufferPools[class].Put(b)
+}
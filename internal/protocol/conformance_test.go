@@ -0,0 +1,270 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// step is one T-message in a conformance script and the reply it must get
+type step struct {
+	tag   uint16
+	msg   Message
+	want  uint8
+	check func(t *testing.T, payload []byte)
+}
+
+// runScript sends each step in turn over a fresh connection to a server
+// for the conformance tree
+func runScript(t *testing.T, steps []step) {
+	t.Helper()
+	root := NewStaticDir("root")
+	sub := NewStaticDir("sub")
+	sub.AddChild(NewStaticFile("deep", []byte("deep file")))
+	root.AddChild(sub)
+	root.AddChild(NewStaticFile("hello", []byte("hello, world")))
+	root.AddChild(newMemFile("notes", ""))
+
+	c := newTestConn(t, NewServer(root))
+	for _, s := range steps {
+		payload := c.rpc(s.tag, s.msg, s.want)
+		if s.check != nil {
+			s.check(t, payload)
+		}
+	}
+}
+
+// session is the usual start of a script: version, then attach as fid 0
+var session = []step{
+	{NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion, nil},
+	{1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda"}, Rattach, nil},
+}
+
+func script(steps ...step) []step {
+	return append(append([]step(nil), session...), steps...)
+}
+
+func wantVersion(msize uint32, version string) func(*testing.T, []byte) {
+	return func(t *testing.T, payload []byte) {
+		r, err := DecodeRversion(payload)
+		if err != nil || r.Msize != msize || r.Version != version {
+			t.Errorf("Rversion = %+v, %v; want msize %d version %q", r, err, msize, version)
+		}
+	}
+}
+
+func wantQids(n int) func(*testing.T, []byte) {
+	return func(t *testing.T, payload []byte) {
+		r, err := DecodeRwalk(payload)
+		if err != nil || len(r.Qids) != n {
+			t.Errorf("Rwalk = %+v, %v; want %d qids", r, err, n)
+		}
+	}
+}
+
+func wantData(data string) func(*testing.T, []byte) {
+	return func(t *testing.T, payload []byte) {
+		r, err := DecodeRread(payload)
+		if err != nil || string(r.Data) != data {
+			t.Errorf("Rread = %q, %v; want %q", r.Data, err, data)
+		}
+	}
+}
+
+func TestConformance_Version(t *testing.T) {
+	runScript(t, []step{
+		{NoTag, &TversionMsg{Msize: MaxMessageSize, Version: "9P2000"}, Rversion, wantVersion(MaxMessageSize, "9P2000")},
+		{NoTag, &TversionMsg{Msize: MaxMessageSize, Version: "9P2000.x"}, Rversion, wantVersion(MaxMessageSize, "9P2000")},
+		{NoTag, &TversionMsg{Msize: MaxMessageSize, Version: "9P1999"}, Rversion, wantVersion(MaxMessageSize, "unknown")},
+		{NoTag, &TversionMsg{Msize: MinMessageSize - 1, Version: "9P2000"}, Rerror, nil},
+		{NoTag, &TversionMsg{Msize: 1 << 30, Version: "9P2000"}, Rversion, wantVersion(MaxMessageSize, "9P2000")},
+	})
+}
+
+func TestConformance_VersionClunksFids(t *testing.T) {
+	c := newTestConn(t, NewServer(NewStaticDir("root")))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1}, Rwalk)
+
+	// A second Tversion clunks the fids of the session before it.
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	for _, fid := range []uint32{0, 1} {
+		payload := c.rpc(1, &TgetattrMsg{Fid: fid, RequestMask: GetattrBasic}, Rlerror)
+		if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != EBADF {
+			t.Errorf("getattr fid %d after Tversion: errno = %d, want EBADF", fid, errno)
+		}
+	}
+
+	// The fid numbers are free again.
+	c.rpc(1, &TattachMsg{Fid: 1, Afid: NoFid}, Rattach)
+}
+
+func TestConformance_Walk(t *testing.T) {
+	runScript(t, script(
+		// No names clones the fid.
+		step{1, &TwalkMsg{Fid: 0, Newfid: 1}, Rwalk, wantQids(0)},
+		step{1, &TstatMsg{Fid: 1}, Rstat, nil},
+		step{1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"sub", "deep"}}, Rwalk, wantQids(2)},
+
+		// A failed first element is an error; a later one is a partial
+		// walk. Either way newfid is not created.
+		step{1, &TwalkMsg{Fid: 0, Newfid: 3, Names: []string{"missing"}}, Rerror, nil},
+		step{1, &TwalkMsg{Fid: 0, Newfid: 3, Names: []string{"sub", "missing"}}, Rwalk, wantQids(1)},
+		step{1, &TstatMsg{Fid: 3}, Rerror, nil},
+		step{1, &TwalkMsg{Fid: 0, Newfid: 3, Names: []string{"hello", "x"}}, Rwalk, wantQids(1)},
+		step{1, &TwalkMsg{Fid: 2, Newfid: 3, Names: []string{"x"}}, Rerror, nil},
+
+		step{1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"hello"}}, Rerror, nil}, // newfid in use
+		step{1, &TwalkMsg{Fid: 9, Newfid: 3}, Rerror, nil},                           // unknown fid
+		step{1, &TwalkMsg{Fid: 0, Newfid: 3, Names: make([]string, MaxWalkElem+1)}, Rerror, nil},
+
+		// Walking a fid to itself moves it.
+		step{1, &TwalkMsg{Fid: 1, Newfid: 1, Names: []string{"sub"}}, Rwalk, wantQids(1)},
+		step{1, &TwalkMsg{Fid: 1, Newfid: 1, Names: []string{"deep"}}, Rwalk, wantQids(1)},
+	))
+}
+
+func TestConformance_OpenReadWriteClunk(t *testing.T) {
+	runScript(t, script(
+		step{1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"hello"}}, Rwalk, nil},
+		step{1, &TreadMsg{Fid: 1, Count: 100}, Rerror, nil}, // not open
+		step{1, &TopenMsg{Fid: 1, Mode: OREAD}, Ropen, nil},
+		step{1, &TopenMsg{Fid: 1, Mode: OREAD}, Rerror, nil}, // already open
+		step{1, &TreadMsg{Fid: 1, Count: 5}, Rread, wantData("hello")},
+		step{1, &TreadMsg{Fid: 1, Offset: 7, Count: 100}, Rread, wantData("world")},
+		step{1, &TreadMsg{Fid: 1, Offset: 12, Count: 100}, Rread, wantData("")},
+		step{1, &TreadMsg{Fid: 1, Offset: 1000, Count: 100}, Rread, wantData("")},
+		step{1, &TwriteMsg{Fid: 1, Data: []byte("x")}, Rerror, nil}, // read-only file
+		step{1, &TclunkMsg{Fid: 1}, Rclunk, nil},
+		step{1, &TclunkMsg{Fid: 1}, Rerror, nil},
+		step{1, &TreadMsg{Fid: 1, Count: 100}, Rerror, nil},
+
+		step{1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"notes"}}, Rwalk, nil},
+		step{1, &TopenMsg{Fid: 2, Mode: ORDWR}, Ropen, nil},
+		step{1, &TwriteMsg{Fid: 2, Data: []byte("written")}, Rwrite, func(t *testing.T, payload []byte) {
+			if r, err := DecodeRwrite(payload); err != nil || r.Count != 7 {
+				t.Errorf("Rwrite = %+v, %v; want count 7", r, err)
+			}
+		}},
+		step{1, &TreadMsg{Fid: 2, Count: 100}, Rread, wantData("written")},
+		step{1, &TclunkMsg{Fid: 2}, Rclunk, nil},
+
+		// Directories cannot be written, and reads return whole entries.
+		step{1, &TwalkMsg{Fid: 0, Newfid: 3}, Rwalk, nil},
+		step{1, &TopenMsg{Fid: 3, Mode: OREAD}, Ropen, func(t *testing.T, payload []byte) {
+			if r, _ := DecodeRopen(payload); r.Qid.Type&QTDIR == 0 {
+				t.Errorf("Ropen qid type = %#x, want QTDIR", r.Qid.Type)
+			}
+		}},
+		step{1, &TreadMsg{Fid: 3, Count: MaxMessageSize}, Rread, func(t *testing.T, payload []byte) {
+			r, _ := DecodeRread(payload)
+			var names []string
+			for data := r.Data; len(data) > 0; {
+				st, n := DecodeStat(data)
+				if n == 0 {
+					t.Fatalf("bad entry after %v", names)
+				}
+				names = append(names, st.Name)
+				data = data[n:]
+			}
+			if len(names) != 3 {
+				t.Errorf("entries = %v, want sub, hello and notes", names)
+			}
+		}},
+		step{1, &TwriteMsg{Fid: 3, Data: []byte("x")}, Rerror, nil},
+	))
+}
+
+func TestConformance_Stat(t *testing.T) {
+	runScript(t, script(
+		step{1, &TstatMsg{Fid: 0}, Rstat, func(t *testing.T, payload []byte) {
+			r, err := DecodeRstat(payload)
+			if err != nil || r.Stat.Mode&DMDIR == 0 || r.Stat.Qid.Type&QTDIR == 0 {
+				t.Errorf("root Rstat = %+v, %v; want a directory", r, err)
+			}
+		}},
+		step{1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"hello"}}, Rwalk, nil},
+		step{1, &TstatMsg{Fid: 1}, Rstat, func(t *testing.T, payload []byte) {
+			// The stat is preceded by its own length, as in Rstat's
+			// stat[n] field.
+			if n := binary.LittleEndian.Uint16(payload[0:2]); int(n) != len(payload)-2 {
+				t.Errorf("Rstat stat[n] = %d, want %d", n, len(payload)-2)
+			}
+			r, err := DecodeRstat(payload)
+			if err != nil || r.Stat.Name != "hello" || r.Stat.Length != 12 {
+				t.Errorf("Rstat = %+v, %v; want hello of length 12", r, err)
+			}
+		}},
+		step{1, &TstatMsg{Fid: 9}, Rerror, nil},
+		step{1, &TwstatMsg{Fid: 9, Stat: NullStat()}, Rerror, nil},
+	))
+}
+
+func TestConformance_Flush(t *testing.T) {
+	runScript(t, script(
+		// Flushing a tag that is not in flight still gets an Rflush.
+		step{2, &TflushMsg{Oldtag: 1}, Rflush, nil},
+		step{2, &TflushMsg{Oldtag: 2}, Rflush, nil},
+		step{2, &TflushMsg{Oldtag: NoTag}, Rflush, nil},
+	))
+}
+
+func TestConformance_Malformed(t *testing.T) {
+	runScript(t, script(
+		step{1, rawMsg{Tattach, []byte{0, 0}}, Rerror, nil},
+		step{1, rawMsg{Twalk, []byte{0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 5, 0, 'a'}}, Rerror, nil},
+		step{1, rawMsg{Twrite, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xf8, 0xff, 0xff, 0xff}}, Rerror, nil},
+		step{1, rawMsg{Twstat, []byte{0, 0, 0, 0, 2, 0, 0, 0}}, Rerror, nil},
+		step{1, rawMsg{99, nil}, Rerror, nil},    // no such message
+		step{1, rawMsg{Rread, nil}, Rerror, nil}, // a reply
+		step{1, &TstatMsg{Fid: 0}, Rstat, nil},   // the connection survives
+	))
+}
+
+// rawMsg sends an arbitrary payload
+type rawMsg struct {
+	typ     uint8
+	payload []byte
+}
+
+func (m rawMsg) Type() uint8           { return m.typ }
+func (m rawMsg) Encode(buf []byte) int { return copy(buf, m.payload) }
+
+func TestConformance_ShortMessage(t *testing.T) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		NewServer(NewStaticDir("root")).ServeConn(server)
+		close(done)
+	}()
+	defer client.Close()
+
+	// A message whose size is smaller than the header ends the
+	// connection rather than being misparsed.
+	client.Write([]byte{3, 0, 0, 0})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("connection still open after a short message")
+	}
+}
+
+func TestStaticDir_ReadLongName(t *testing.T) {
+	// Entries longer than 256 bytes used to overflow a fixed buffer.
+	name := strings.Repeat("n", 300)
+	d := NewStaticDir("root")
+	d.AddChild(NewStaticFile(name, nil))
+
+	p := make([]byte, 1024)
+	n, err := d.Read(p, 0)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	st, sn := DecodeStat(p[:n])
+	if sn != n || st.Name != name {
+		t.Errorf("entry = %d bytes named %.10q..., want %d bytes named %.10q...", sn, st.Name, n, name)
+	}
+}
@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Conn is a client connection as seen by the files it uses. Files reach
+// it through the context passed to their context-aware methods, so they
+// can tie state to the connection and clean it up when the client goes
+// away.
+type Conn struct {
+	remote net.Addr
+
+	mu      sync.Mutex
+	closed  bool
+	onClose []func()
+}
+
+type connKey struct{}
+
+// ConnFromContext returns the connection a request arrived on, or nil if
+// ctx does not come from the server.
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connKey{}).(*Conn)
+	return c
+}
+
+// RemoteAddr returns the client's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// OnClose arranges for f to be called once the connection has ended,
+// after its outstanding requests have finished and its fids have been
+// clunked. If the connection has already ended, f is called at once.
+func (c *Conn) OnClose(f func()) {
+	c.mu.Lock()
+	if !c.closed {
+		c.onClose = append(c.onClose, f)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	f()
+}
+
+// close runs the OnClose functions in the order they were registered.
+func (c *Conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	fns := c.onClose
+	c.onClose = nil
+	c.mu.Unlock()
+
+	for _, f := range fns {
+		f()
+	}
+}
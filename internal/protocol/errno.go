@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errno is a Linux errno value, as carried by 9P2000.u Rerror and
+// 9P2000.L Rlerror. An Errno is itself an error, so a file can return one
+// directly.
+type Errno uint32
+
+// Linux errno values
+const (
+	EPERM      Errno = 1
+	ENOENT     Errno = 2
+	EINTR      Errno = 4
+	EIO        Errno = 5
+	EBADF      Errno = 9
+	EACCES     Errno = 13
+	EEXIST     Errno = 17
+	ENOTDIR    Errno = 20
+	EISDIR     Errno = 21
+	EINVAL     Errno = 22
+	EMFILE     Errno = 24
+	ENOTEMPTY  Errno = 39
+	EOPNOTSUPP Errno = 95
+)
+
+var errnoText = map[Errno]string{
+	EPERM:      "operation not permitted",
+	ENOENT:     "no such file or directory",
+	EINTR:      "interrupted",
+	EIO:        "input/output error",
+	EBADF:      "bad file descriptor",
+	EACCES:     "permission denied",
+	EEXIST:     "file exists",
+	ENOTDIR:    "not a directory",
+	EISDIR:     "is a directory",
+	EINVAL:     "invalid argument",
+	EMFILE:     "too many open files",
+	ENOTEMPTY:  "directory not empty",
+	EOPNOTSUPP: "operation not supported",
+}
+
+func (e Errno) Error() string {
+	if s, ok := errnoText[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("errno %d", uint32(e))
+}
+
+// Errno returns e itself.
+func (e Errno) Errno() Errno { return e }
+
+// errnoer is implemented by errors that know their errno.
+type errnoer interface {
+	Errno() Errno
+}
+
+// errnos maps the protocol errors to their errno values.
+var errnos = map[Error]Errno{
+	ErrNotFound:     ENOENT,
+	ErrPermission:   EACCES,
+	ErrNotDir:       ENOTDIR,
+	ErrIsDir:        EISDIR,
+	ErrBadFid:       EBADF,
+	ErrFidInUse:     EBADF,
+	ErrBadOffset:    EINVAL,
+	ErrTagInUse:     EINVAL,
+	ErrNotOpen:      EBADF,
+	ErrAlreadyOpen:  EBADF,
+	ErrTooManyFids:  EMFILE,
+	ErrNotSupported: EOPNOTSUPP,
+	ErrExists:       EEXIST,
+	ErrBadName:      EINVAL,
+	ErrAuthRequired: EACCES,
+	ErrAuthFailed:   EACCES,
+	ErrNoAuth:       EOPNOTSUPP,
+}
+
+// Errno returns the errno for e. Errors without a specific mapping, such
+// as the free-form Error("...") messages, are reported as EIO.
+func (e Error) Errno() Errno {
+	if errno, ok := errnos[e]; ok {
+		return errno
+	}
+	return EIO
+}
+
+// errnoError is an error message with an explicit errno.
+type errnoError struct {
+	msg   string
+	errno Errno
+}
+
+func (e *errnoError) Error() string { return e.msg }
+func (e *errnoError) Errno() Errno  { return e.errno }
+
+// Errorf formats an error message that is reported to 9P2000.u and
+// 9P2000.L clients as errno.
+func Errorf(errno Errno, format string, args ...any) error {
+	return &errnoError{msg: fmt.Sprintf(format, args...), errno: errno}
+}
+
+// ErrnoOf returns the errno a client should see for err. Errors that carry
+// no errno are reported as EIO.
+func ErrnoOf(err error) Errno {
+	var e errnoer
+	if errors.As(err, &e) {
+		return e.Errno()
+	}
+	if errors.Is(err, context.Canceled) {
+		return EINTR
+	}
+	return EIO
+}
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"context"
 	"io"
 	"sync/atomic"
 	"time"
@@ -12,8 +13,11 @@ type File interface {
 	// Stat returns the file's metadata
 	Stat() Stat
 
-	// Open prepares the file for reading/writing
-	Open(mode uint8) error
+	// Open prepares the file for reading/writing and returns the state
+	// for this open fid. A nil Handle means the file keeps no per-open
+	// state; the server then uses the file's own Read, Write and Close
+	// (or, for a directory, a snapshot of its children).
+	Open(ctx context.Context, mode uint8) (Handle, error)
 
 	// Read reads up to len(p) bytes starting at offset
 	Read(p []byte, offset int64) (n int, err error)
@@ -25,6 +29,48 @@ type File interface {
 	Close() error
 }
 
+// ContextReader is implemented by files whose reads may block on slow work.
+// The server calls ReadContext instead of Read, passing a context that is
+// cancelled when the client flushes the request.
+type ContextReader interface {
+	ReadContext(ctx context.Context, p []byte, offset int64) (n int, err error)
+}
+
+// ContextWriter is implemented by files whose writes may block on slow work,
+// such as a prompt sent to an LLM. The server calls WriteContext instead of
+// Write, passing a context that is cancelled when the client flushes the
+// request.
+type ContextWriter interface {
+	WriteContext(ctx context.Context, p []byte, offset int64) (n int, err error)
+}
+
+// ContextCloser is implemented by files that do slow work when their fid is
+// clunked, such as committing a buffered prompt. The server calls
+// CloseContext instead of Close, passing a context that is cancelled when
+// the client flushes the Tclunk.
+type ContextCloser interface {
+	CloseContext(ctx context.Context) error
+}
+
+// Remover is implemented by files and directories that clients may
+// remove. Files without it cannot be removed.
+type Remover interface {
+	Remove() error
+}
+
+// Truncater is implemented by files whose length can be set, by Twstat,
+// Tsetattr or an open with OTRUNC. Files without it accept only their
+// current length.
+type Truncater interface {
+	Truncate(length uint64) error
+}
+
+// Renamer is implemented by files and directories that can be renamed
+// within their directory.
+type Renamer interface {
+	Rename(name string) error
+}
+
 // Dir is the interface that directories must implement
 type Dir interface {
 	File
@@ -34,6 +80,11 @@ type Dir interface {
 
 	// Lookup finds a child by name
 	Lookup(name string) (File, error)
+
+	// Create makes a new child called name, a directory if perm has
+	// DMDIR set. Directories whose contents are fixed return
+	// ErrPermission.
+	Create(name string, perm uint32) (File, error)
 }
 
 // pathCounter generates unique path IDs for qids
@@ -91,10 +142,10 @@ func (f *BaseFile) Stat() Stat {
 	}
 }
 
-func (f *BaseFile) Open(mode uint8) error                   { return nil }
-func (f *BaseFile) Close() error                            { return nil }
-func (f *BaseFile) Read(p []byte, offset int64) (int, error)  { return 0, io.EOF }
-func (f *BaseFile) Write(p []byte, offset int64) (int, error) { return 0, ErrPermission }
+func (f *BaseFile) Open(ctx context.Context, mode uint8) (Handle, error) { return nil, nil }
+func (f *BaseFile) Close() error                                         { return nil }
+func (f *BaseFile) Read(p []byte, offset int64) (int, error)             { return 0, io.EOF }
+func (f *BaseFile) Write(p []byte, offset int64) (int, error)            { return 0, ErrPermission }
 
 // SetLength updates the file length
 func (f *BaseFile) SetLength(n uint64) {
@@ -167,14 +218,19 @@ func (d *StaticDir) Lookup(name string) (File, error) {
 	return nil, ErrNotFound
 }
 
+// Create is not permitted; a static directory's children are fixed.
+func (d *StaticDir) Create(name string, perm uint32) (File, error) {
+	return nil, ErrPermission
+}
+
 func (d *StaticDir) Read(p []byte, offset int64) (int, error) {
 	// Directory read returns packed stat entries
 	var buf []byte
 	for _, f := range d.Children() {
 		stat := f.Stat()
-		entry := make([]byte, 256)
-		n := stat.Encode(entry)
-		buf = append(buf, entry[:n]...)
+		entry := make([]byte, stat.EncodedLen())
+		stat.Encode(entry)
+		buf = append(buf, entry...)
 	}
 
 	if offset >= int64(len(buf)) {
@@ -199,6 +255,12 @@ func NewDynamicFile(name string, generator func() []byte) *DynamicFile {
 	}
 }
 
+// Open captures the generated content, so that every read on this fid
+// sees the same version.
+func (f *DynamicFile) Open(ctx context.Context, mode uint8) (Handle, error) {
+	return NewSnapshotHandle(f, f.Generator()), nil
+}
+
 func (f *DynamicFile) Read(p []byte, offset int64) (int, error) {
 	content := f.Generator()
 	if offset >= int64(len(content)) {
@@ -220,11 +282,23 @@ type Error string
 func (e Error) Error() string { return string(e) }
 
 const (
-	ErrNotFound   Error = "file not found"
-	ErrPermission Error = "permission denied"
-	ErrNotDir     Error = "not a directory"
-	ErrIsDir      Error = "is a directory"
-	ErrBadFid     Error = "bad fid"
-	ErrFidInUse   Error = "fid already in use"
-	ErrBadOffset  Error = "bad offset"
+	ErrNotFound    Error = "file not found"
+	ErrPermission  Error = "permission denied"
+	ErrNotDir      Error = "not a directory"
+	ErrIsDir       Error = "is a directory"
+	ErrBadFid      Error = "bad fid"
+	ErrFidInUse    Error = "fid already in use"
+	ErrBadOffset   Error = "bad offset"
+	ErrTagInUse    Error = "tag in use"
+	ErrNotOpen     Error = "fid not open"
+	ErrAlreadyOpen Error = "fid already open"
+	ErrTooManyFids Error = "too many fids"
+
+	ErrNotSupported Error = "operation not supported"
+	ErrExists       Error = "file exists"
+	ErrBadName      Error = "bad file name"
+
+	ErrAuthRequired Error = "authentication required"
+	ErrAuthFailed   Error = "authentication failed"
+	ErrNoAuth       Error = "authentication not required"
 )
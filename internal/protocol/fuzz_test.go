@@ -0,0 +1,341 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// encode returns the payload of msg, for seeding a fuzz corpus
+func encode(msg Message) []byte {
+	buf := make([]byte, MaxMessageSize)
+	return buf[:msg.Encode(buf)]
+}
+
+// fuzzDecode checks that decode never panics, and that it does not depend
+// on anything but the bytes it is given.
+func fuzzDecode[T any](f *testing.F, decode func([]byte) (T, error), seeds ...Message) {
+	for _, seed := range seeds {
+		f.Add(encode(seed))
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := decode(data)
+		if err != nil {
+			return
+		}
+		if m2, err := decode(bytes.Clone(data)); err != nil || !reflect.DeepEqual(m, m2) {
+			t.Errorf("second decode = %+v, %v; want %+v", m2, err, m)
+		}
+	})
+}
+
+// fuzzDecodeN is fuzzDecode for the decoders that return a length.
+func fuzzDecodeN[T any](f *testing.F, decode func([]byte) (T, int), seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, n := decode(data); n < 0 || n > len(data) {
+			t.Errorf("decoded %d bytes from %d", n, len(data))
+		}
+	})
+}
+
+var (
+	fuzzQid  = Qid{Type: QTDIR, Version: 3, Path: 42}
+	fuzzStat = Stat{
+		Type: 1, Dev: 2, Qid: fuzzQid, Mode: DMDIR | 0755, Atime: 3, Mtime: 4, Length: 5,
+		Name: "name", Uid: "uid", Gid: "gid", Muid: "muid",
+		Extension: "ext", NUid: 1000, NGid: 1000, NMuid: NoUid,
+	}
+)
+
+func statBytes(s Stat, dotu bool) []byte {
+	buf := make([]byte, s.EncodedLenU())
+	if dotu {
+		return buf[:s.EncodeU(buf)]
+	}
+	return buf[:s.Encode(buf)]
+}
+
+func FuzzDecodeString(f *testing.F) {
+	fuzzDecodeN(f, DecodeString, []byte{3, 0, 'a', 'b', 'c'})
+}
+
+func FuzzDecodeQid(f *testing.F) {
+	buf := make([]byte, 13)
+	fuzzQid.Encode(buf)
+	fuzzDecodeN(f, DecodeQid, buf)
+}
+
+func FuzzDecodeStat(f *testing.F) {
+	fuzzDecodeN(f, DecodeStat, statBytes(fuzzStat, false), statBytes(fuzzStat, true))
+}
+
+func FuzzDecodeStatU(f *testing.F) {
+	fuzzDecodeN(f, DecodeStatU, statBytes(fuzzStat, true), statBytes(fuzzStat, false))
+}
+
+func FuzzDecodeDirent(f *testing.F) {
+	d := Dirent{Qid: fuzzQid, Offset: 1, Type: DTDIR, Name: "sub"}
+	buf := make([]byte, d.EncodedLen())
+	d.Encode(buf)
+	fuzzDecodeN(f, DecodeDirent, buf)
+}
+
+func FuzzDecodeTversion(f *testing.F) {
+	fuzzDecode(f, DecodeTversion, &TversionMsg{Msize: 8192, Version: Version})
+}
+
+func FuzzDecodeRversion(f *testing.F) {
+	fuzzDecode(f, DecodeRversion, &RversionMsg{Msize: 8192, Version: Version})
+}
+
+func FuzzDecodeTauth(f *testing.F) {
+	fuzzDecode(f, DecodeTauth, &TauthMsg{Afid: 1, Uname: "glenda", Aname: "llm"})
+}
+
+func FuzzDecodeRauth(f *testing.F) {
+	fuzzDecode(f, DecodeRauth, &RauthMsg{Aqid: fuzzQid})
+}
+
+func FuzzDecodeTattach(f *testing.F) {
+	fuzzDecode(f, DecodeTattach, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda", Aname: "llm"})
+}
+
+func FuzzDecodeRattach(f *testing.F) {
+	fuzzDecode(f, DecodeRattach, &RattachMsg{Qid: fuzzQid})
+}
+
+func FuzzDecodeTwalk(f *testing.F) {
+	fuzzDecode(f, DecodeTwalk, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"0", "ask"}})
+}
+
+func FuzzDecodeRwalk(f *testing.F) {
+	fuzzDecode(f, DecodeRwalk, &RwalkMsg{Qids: []Qid{fuzzQid, fuzzQid}})
+}
+
+func FuzzDecodeTopen(f *testing.F) {
+	fuzzDecode(f, DecodeTopen, &TopenMsg{Fid: 1, Mode: ORDWR})
+}
+
+func FuzzDecodeRopen(f *testing.F) {
+	fuzzDecode(f, DecodeRopen, &RopenMsg{Qid: fuzzQid, Iounit: 8000})
+}
+
+func FuzzDecodeTcreate(f *testing.F) {
+	fuzzDecode(f, DecodeTcreate, &TcreateMsg{Fid: 1, Name: "bot", Perm: DMDIR | 0755, Mode: OREAD})
+}
+
+func FuzzDecodeRcreate(f *testing.F) {
+	fuzzDecode(f, DecodeRcreate, &RcreateMsg{Qid: fuzzQid})
+}
+
+func FuzzDecodeTread(f *testing.F) {
+	fuzzDecode(f, DecodeTread, &TreadMsg{Fid: 1, Offset: 10, Count: 100})
+}
+
+func FuzzDecodeRread(f *testing.F) {
+	fuzzDecode(f, DecodeRread, &RreadMsg{Data: []byte("hello")})
+}
+
+func FuzzDecodeTwrite(f *testing.F) {
+	fuzzDecode(f, DecodeTwrite, &TwriteMsg{Fid: 1, Offset: 10, Data: []byte("hello")})
+}
+
+func FuzzDecodeRwrite(f *testing.F) {
+	fuzzDecode(f, DecodeRwrite, &RwriteMsg{Count: 5})
+}
+
+func FuzzDecodeTclunk(f *testing.F) {
+	fuzzDecode(f, DecodeTclunk, &TclunkMsg{Fid: 1})
+}
+
+func FuzzDecodeTremove(f *testing.F) {
+	fuzzDecode(f, DecodeTremove, &TremoveMsg{Fid: 1})
+}
+
+func FuzzDecodeTstat(f *testing.F) {
+	fuzzDecode(f, DecodeTstat, &TstatMsg{Fid: 1})
+}
+
+func FuzzDecodeRstat(f *testing.F) {
+	fuzzDecode(f, DecodeRstat, &RstatMsg{Stat: fuzzStat})
+}
+
+func FuzzDecodeRstatU(f *testing.F) {
+	f.Add(append(binary.LittleEndian.AppendUint16(nil, uint16(fuzzStat.EncodedLenU())), statBytes(fuzzStat, true)...))
+	fuzzDecode(f, DecodeRstatU, &RstatMsg{Stat: fuzzStat})
+}
+
+func FuzzDecodeTwstat(f *testing.F) {
+	fuzzDecode(f, DecodeTwstat, &TwstatMsg{Fid: 1, Stat: NullStat()}, &TwstatMsg{Fid: 1, Stat: fuzzStat})
+}
+
+func FuzzDecodeTwstatU(f *testing.F) {
+	f.Add(append([]byte{1, 0, 0, 0}, append(binary.LittleEndian.AppendUint16(nil, uint16(fuzzStat.EncodedLenU())), statBytes(fuzzStat, true)...)...))
+	fuzzDecode(f, DecodeTwstatU, &TwstatMsg{Fid: 1, Stat: fuzzStat})
+}
+
+func FuzzDecodeRerror(f *testing.F) {
+	fuzzDecode(f, DecodeRerror, &RerrorMsg{Ename: "file not found", Errno: ENOENT})
+}
+
+func FuzzDecodeTflush(f *testing.F) {
+	fuzzDecode(f, DecodeTflush, &TflushMsg{Oldtag: 1})
+}
+
+func FuzzDecodeTstatfs(f *testing.F) {
+	fuzzDecode(f, DecodeTstatfs, &TstatfsMsg{Fid: 1})
+}
+
+func FuzzDecodeTlopen(f *testing.F) {
+	fuzzDecode(f, DecodeTlopen, &TlopenMsg{Fid: 1, Flags: 2})
+}
+
+func FuzzDecodeTlcreate(f *testing.F) {
+	fuzzDecode(f, DecodeTlcreate, &TlcreateMsg{Fid: 1, Name: "notes", Flags: 2, Mode: 0644})
+}
+
+func FuzzDecodeTmkdir(f *testing.F) {
+	fuzzDecode(f, DecodeTmkdir, &TmkdirMsg{Dfid: 1, Name: "bot", Mode: 0755})
+}
+
+func FuzzDecodeTunlinkat(f *testing.F) {
+	fuzzDecode(f, DecodeTunlinkat, &TunlinkatMsg{Dfid: 1, Name: "bot", Flags: ATRemoveDir})
+}
+
+func FuzzDecodeTrenameat(f *testing.F) {
+	fuzzDecode(f, DecodeTrenameat, &TrenameatMsg{OldDirfid: 1, OldName: "a", NewDirfid: 1, NewName: "b"})
+}
+
+func FuzzDecodeTgetattr(f *testing.F) {
+	fuzzDecode(f, DecodeTgetattr, &TgetattrMsg{Fid: 1, RequestMask: ^uint64(0)})
+}
+
+func FuzzDecodeTsetattr(f *testing.F) {
+	fuzzDecode(f, DecodeTsetattr, &TsetattrMsg{Fid: 1, Valid: SetattrSize, Size: 10})
+}
+
+func FuzzDecodeTreaddir(f *testing.F) {
+	fuzzDecode(f, DecodeTreaddir, &TreaddirMsg{Fid: 1, Offset: 2, Count: 1000})
+}
+
+func FuzzDecodeTfsync(f *testing.F) {
+	fuzzDecode(f, DecodeTfsync, &TfsyncMsg{Fid: 1, Datasync: 1})
+}
+
+// FuzzDecodeMessage checks that DecodeMessage, which tracing and
+// llm9p-replay run on every payload, never panics for any message type in
+// any dialect.
+func FuzzDecodeMessage(f *testing.F) {
+	seeds := []struct {
+		version string
+		msg     Message
+	}{
+		{Version, &TversionMsg{Msize: 8192, Version: Version}},
+		{Version, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"0", "ask"}}},
+		{Version, &RreadMsg{Data: []byte("hello")}},
+		{Version, &TwstatMsg{Fid: 1, Stat: fuzzStat}},
+		{VersionU, &TwstatMsg{Fid: 1, Stat: fuzzStat}},
+		{VersionU, &RerrorMsg{Ename: "not found", Errno: ENOENT}},
+		{VersionL, &RlerrorMsg{Ecode: ENOENT}},
+		{VersionL, &TlopenMsg{Fid: 1, Flags: 2}},
+		{VersionL, &TreaddirMsg{Fid: 1, Offset: 0, Count: 100}},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.version, seed.msg.Type(), encode(seed.msg))
+	}
+	f.Add(VersionL, uint8(Rlerror), []byte{1, 2})
+	f.Add("unknown", uint8(0), []byte{})
+
+	f.Fuzz(func(t *testing.T, version string, msgType uint8, payload []byte) {
+		m, err := DecodeMessage(version, msgType, payload)
+		if err != nil {
+			return
+		}
+		if m2, err := DecodeMessage(version, msgType, bytes.Clone(payload)); err != nil || !reflect.DeepEqual(m, m2) {
+			t.Errorf("second decode = %+v, %v; want %+v", m2, err, m)
+		}
+	})
+}
+
+// FuzzReadMessage feeds arbitrary bytes to a Decoder.
+func FuzzReadMessage(f *testing.F) {
+	var wire bytes.Buffer
+	NewEncoder(&wire).WriteMessage(Tversion, NoTag, encode(&TversionMsg{Msize: 8192, Version: Version}))
+	f.Add(wire.Bytes())
+	f.Add([]byte{7, 0, 0, 0, Tclunk, 1, 0})
+	f.Add([]byte{3, 0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, Tread})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bytes.NewReader(data))
+		d.SetMsize(MinMessageSize)
+		defer d.Release()
+		for {
+			_, _, payload, err := d.ReadMessage()
+			if err != nil {
+				return
+			}
+			if len(payload) > MinMessageSize-7 {
+				t.Fatalf("payload of %d bytes exceeds msize", len(payload))
+			}
+		}
+	})
+}
+
+// FuzzServer sends one arbitrary message to a server after attaching, in
+// each dialect, and checks that it replies and stays up.
+func FuzzServer(f *testing.F) {
+	for _, msg := range []Message{
+		&TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"notes"}},
+		&TopenMsg{Fid: 0, Mode: OREAD},
+		&TreadMsg{Fid: 0, Count: 100},
+		&TwstatMsg{Fid: 0, Stat: NullStat()},
+		&TcreateMsg{Fid: 0, Name: "x", Perm: 0644},
+		&TgetattrMsg{Fid: 0, RequestMask: ^uint64(0)},
+		&TreaddirMsg{Fid: 0, Count: 1000},
+		&TrenameatMsg{OldDirfid: 0, OldName: "notes", NewDirfid: 0, NewName: "n"},
+	} {
+		for _, version := range []string{Version, VersionU, VersionL} {
+			f.Add(version, msg.Type(), encode(msg))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, version string, msgType uint8, payload []byte) {
+		if len(payload) > MaxMessageSize-7 || len(version) > 64 || msgType == Tversion {
+			return
+		}
+		root := NewStaticDir("root")
+		root.AddChild(newMemFile("notes", "some notes"))
+		root.AddChild(NewStaticDir("sub"))
+
+		client, server := net.Pipe()
+		go NewServer(root).ServeConn(server)
+		defer client.Close()
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+
+		c := &testConn{t: t, enc: NewEncoder(client), dec: NewDecoder(client)}
+		c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: version}, Rversion)
+		attach := &TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda", NUname: NoUid}
+		if version == Version {
+			c.rpc(1, attach, Rattach)
+		} else {
+			c.rpc(1, &dotuAttach{*attach}, Rattach)
+		}
+
+		c.send(2, rawMsg{msgType, payload})
+		if _, tag, _ := c.recv(); tag != 2 {
+			t.Fatalf("reply tag = %d, want 2", tag)
+		}
+		c.rpc(3, &TflushMsg{Oldtag: 2}, Rflush)
+	})
+}
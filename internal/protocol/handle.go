@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"context"
+	"io"
+)
+
+// Handle is the state of one open fid. Once a fid is opened, Tread, Twrite
+// and Tclunk on it go to the handle returned by File.Open rather than to the
+// file, so per-open state such as a read snapshot, a write buffer or a
+// stream cursor belongs to the fid that opened it.
+type Handle interface {
+	// Read reads up to len(p) bytes starting at offset
+	Read(ctx context.Context, p []byte, offset int64) (n int, err error)
+
+	// Write writes len(p) bytes starting at offset
+	Write(ctx context.Context, p []byte, offset int64) (n int, err error)
+
+	// Close is called when the fid is clunked
+	Close(ctx context.Context) error
+}
+
+// Syncer is implemented by handles that buffer writes until the fid is
+// clunked. Tfsync calls Sync to commit what has been written so far.
+type Syncer interface {
+	Sync(ctx context.Context) error
+}
+
+// fileHandle is the handle for files without per-open state.
+// It passes I/O straight through to the file.
+type fileHandle struct {
+	file File
+}
+
+// NewFileHandle returns a handle that reads and writes f directly, using
+// its ContextReader, ContextWriter and ContextCloser methods if present.
+func NewFileHandle(f File) Handle {
+	return fileHandle{file: f}
+}
+
+func (h fileHandle) Read(ctx context.Context, p []byte, offset int64) (int, error) {
+	if cr, ok := h.file.(ContextReader); ok {
+		return cr.ReadContext(ctx, p, offset)
+	}
+	return h.file.Read(p, offset)
+}
+
+func (h fileHandle) Write(ctx context.Context, p []byte, offset int64) (int, error) {
+	if cw, ok := h.file.(ContextWriter); ok {
+		return cw.WriteContext(ctx, p, offset)
+	}
+	return h.file.Write(p, offset)
+}
+
+func (h fileHandle) Close(ctx context.Context) error {
+	if cc, ok := h.file.(ContextCloser); ok {
+		return cc.CloseContext(ctx)
+	}
+	return h.file.Close()
+}
+
+// SnapshotHandle serves reads from content captured when the file was
+// opened, so a reader sees one consistent version of a generated file
+// however many Treads it takes. Writes and Close go to the file itself.
+type SnapshotHandle struct {
+	Handle
+	Content []byte
+}
+
+// NewSnapshotHandle returns a handle for f that reads from content.
+func NewSnapshotHandle(f File, content []byte) *SnapshotHandle {
+	return &SnapshotHandle{
+		Handle:  NewFileHandle(f),
+		Content: content,
+	}
+}
+
+func (h *SnapshotHandle) Read(ctx context.Context, p []byte, offset int64) (int, error) {
+	if offset >= int64(len(h.Content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.Content[offset:])
+	return n, nil
+}
+
+// dirHandle serves directory reads from the children's stats as they were
+// when the directory was opened. Each Rread carries whole stat entries.
+type dirHandle struct {
+	Handle
+	stats   []Stat
+	entries [][]byte
+	offsets []int64 // offsets[i] is the byte offset of entries[i]
+}
+
+// newDirHandle snapshots the children of d, using stat to fill in each
+// child's metadata. With dotu set the entries use 9P2000.u stat encoding.
+func newDirHandle(d Dir, stat func(File) Stat, dotu bool) *dirHandle {
+	h := &dirHandle{Handle: NewFileHandle(d)}
+	var off int64
+	for _, f := range d.Children() {
+		stat := stat(f)
+		var entry []byte
+		if dotu {
+			entry = make([]byte, stat.EncodedLenU())
+			stat.EncodeU(entry)
+		} else {
+			entry = make([]byte, stat.EncodedLen())
+			stat.Encode(entry)
+		}
+		h.stats = append(h.stats, stat)
+		h.entries = append(h.entries, entry)
+		h.offsets = append(h.offsets, off)
+		off += int64(len(entry))
+	}
+	return h
+}
+
+// readdir packs 9P2000.L directory entries into p, starting with the entry
+// at index offset. Each entry's offset cookie is the index of the next one.
+func (h *dirHandle) readdir(p []byte, offset uint64) (int, error) {
+	n := 0
+	for i := offset; i < uint64(len(h.stats)); i++ {
+		stat := &h.stats[i]
+		d := Dirent{
+			Qid:    stat.Qid,
+			Offset: i + 1,
+			Type:   DTREG,
+			Name:   stat.Name,
+		}
+		if stat.Mode&DMDIR != 0 {
+			d.Type = DTDIR
+		}
+		if n+d.EncodedLen() > len(p) {
+			if n == 0 {
+				return 0, Error("read count too small for directory entry")
+			}
+			break
+		}
+		n += d.Encode(p[n:])
+	}
+	return n, nil
+}
+
+func (h *dirHandle) Read(ctx context.Context, p []byte, offset int64) (int, error) {
+	// Find the entry starting at offset; directory reads must resume
+	// where the previous one ended.
+	i := 0
+	for i < len(h.offsets) && h.offsets[i] < offset {
+		i++
+	}
+	if i == len(h.entries) {
+		return 0, io.EOF
+	}
+	if h.offsets[i] != offset {
+		return 0, ErrBadOffset
+	}
+
+	n := 0
+	for ; i < len(h.entries); i++ {
+		if n+len(h.entries[i]) > len(p) {
+			break
+		}
+		n += copy(p[n:], h.entries[i])
+	}
+	if n == 0 {
+		return 0, Error("read count too small for directory entry")
+	}
+	return n, nil
+}
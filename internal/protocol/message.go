@@ -33,7 +33,11 @@ func DecodeTversion(buf []byte) (*TversionMsg, error) {
 	m := &TversionMsg{
 		Msize: binary.LittleEndian.Uint32(buf[0:4]),
 	}
-	m.Version, _ = DecodeString(buf[4:])
+	version, sn := DecodeString(buf[4:])
+	if sn == 0 {
+		return nil, fmt.Errorf("Tversion too short")
+	}
+	m.Version = version
 	return m, nil
 }
 
@@ -50,12 +54,31 @@ func (m *RversionMsg) Encode(buf []byte) int {
 	return 4 + EncodeString(buf[4:], m.Version)
 }
 
+func DecodeRversion(buf []byte) (*RversionMsg, error) {
+	if len(buf) < 6 {
+		return nil, fmt.Errorf("Rversion too short")
+	}
+	m := &RversionMsg{
+		Msize: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	version, sn := DecodeString(buf[4:])
+	if sn == 0 {
+		return nil, fmt.Errorf("Rversion too short")
+	}
+	m.Version = version
+	return m, nil
+}
+
 // TattachMsg attaches to a filesystem
 type TattachMsg struct {
 	Fid   uint32 // fid to use for this connection
 	Afid  uint32 // auth fid (NoFid if no auth)
 	Uname string // user name
 	Aname string // attach name (filesystem to attach)
+
+	// NUname is the numeric user id sent by 9P2000.u and 9P2000.L
+	// clients, or NoUid.
+	NUname uint32
 }
 
 func (m *TattachMsg) Type() uint8 { return Tattach }
@@ -69,22 +92,97 @@ func (m *TattachMsg) Encode(buf []byte) int {
 	return n
 }
 
+// EncodeU encodes the message with the 9P2000.u n_uname field.
+func (m *TattachMsg) EncodeU(buf []byte) int {
+	n := m.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.NUname)
+	return n + 4
+}
+
 func DecodeTattach(buf []byte) (*TattachMsg, error) {
 	if len(buf) < 12 {
 		return nil, fmt.Errorf("Tattach too short")
 	}
 	m := &TattachMsg{
-		Fid:  binary.LittleEndian.Uint32(buf[0:4]),
-		Afid: binary.LittleEndian.Uint32(buf[4:8]),
+		Fid:    binary.LittleEndian.Uint32(buf[0:4]),
+		Afid:   binary.LittleEndian.Uint32(buf[4:8]),
+		NUname: NoUid,
 	}
 	n := 8
-	var sn int
-	m.Uname, sn = DecodeString(buf[n:])
-	n += sn
-	m.Aname, _ = DecodeString(buf[n:])
+	for _, field := range []*string{&m.Uname, &m.Aname} {
+		var sn int
+		*field, sn = DecodeString(buf[n:])
+		if sn == 0 {
+			return nil, fmt.Errorf("Tattach too short")
+		}
+		n += sn
+	}
+	if len(buf) >= n+4 {
+		m.NUname = binary.LittleEndian.Uint32(buf[n : n+4])
+	}
+	return m, nil
+}
+
+// TauthMsg starts authentication for a later Tattach
+type TauthMsg struct {
+	Afid   uint32 // fid on which to carry out the authentication exchange
+	Uname  string // user name
+	Aname  string // attach name
+	NUname uint32 // numeric user id (9P2000.u and .L), or NoUid
+}
+
+func (m *TauthMsg) Type() uint8 { return Tauth }
+
+func (m *TauthMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Afid)
+	n := 4
+	n += EncodeString(buf[n:], m.Uname)
+	n += EncodeString(buf[n:], m.Aname)
+	return n
+}
+
+func DecodeTauth(buf []byte) (*TauthMsg, error) {
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("Tauth too short")
+	}
+	m := &TauthMsg{
+		Afid:   binary.LittleEndian.Uint32(buf[0:4]),
+		NUname: NoUid,
+	}
+	n := 4
+	for _, field := range []*string{&m.Uname, &m.Aname} {
+		var sn int
+		*field, sn = DecodeString(buf[n:])
+		if sn == 0 {
+			return nil, fmt.Errorf("Tauth too short")
+		}
+		n += sn
+	}
+	if len(buf) >= n+4 {
+		m.NUname = binary.LittleEndian.Uint32(buf[n : n+4])
+	}
 	return m, nil
 }
 
+// RauthMsg is the response to Tauth
+type RauthMsg struct {
+	Aqid Qid
+}
+
+func (m *RauthMsg) Type() uint8 { return Rauth }
+
+func (m *RauthMsg) Encode(buf []byte) int {
+	return m.Aqid.Encode(buf)
+}
+
+func DecodeRauth(buf []byte) (*RauthMsg, error) {
+	qid, n := DecodeQid(buf)
+	if n == 0 {
+		return nil, fmt.Errorf("Rauth too short")
+	}
+	return &RauthMsg{Aqid: qid}, nil
+}
+
 // RattachMsg is the response to Tattach
 type RattachMsg struct {
 	Qid Qid
@@ -96,6 +194,14 @@ func (m *RattachMsg) Encode(buf []byte) int {
 	return m.Qid.Encode(buf)
 }
 
+func DecodeRattach(buf []byte) (*RattachMsg, error) {
+	qid, n := DecodeQid(buf)
+	if n == 0 {
+		return nil, fmt.Errorf("Rattach too short")
+	}
+	return &RattachMsg{Qid: qid}, nil
+}
+
 // TwalkMsg walks a path
 type TwalkMsg struct {
 	Fid    uint32   // starting fid
@@ -125,11 +231,17 @@ func DecodeTwalk(buf []byte) (*TwalkMsg, error) {
 		Newfid: binary.LittleEndian.Uint32(buf[4:8]),
 	}
 	nwname := binary.LittleEndian.Uint16(buf[8:10])
+	if nwname > MaxWalkElem {
+		return nil, fmt.Errorf("Twalk of %d elements exceeds %d", nwname, MaxWalkElem)
+	}
 	m.Names = make([]string, nwname)
 	n := 10
 	for i := range m.Names {
 		var sn int
 		m.Names[i], sn = DecodeString(buf[n:])
+		if sn == 0 {
+			return nil, fmt.Errorf("Twalk too short")
+		}
 		n += sn
 	}
 	return m, nil
@@ -151,6 +263,21 @@ func (m *RwalkMsg) Encode(buf []byte) int {
 	return n
 }
 
+func DecodeRwalk(buf []byte) (*RwalkMsg, error) {
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("Rwalk too short")
+	}
+	nwqid := int(binary.LittleEndian.Uint16(buf[0:2]))
+	if len(buf) < 2+nwqid*13 {
+		return nil, fmt.Errorf("Rwalk too short")
+	}
+	m := &RwalkMsg{Qids: make([]Qid, nwqid)}
+	for i := range m.Qids {
+		m.Qids[i], _ = DecodeQid(buf[2+i*13:])
+	}
+	return m, nil
+}
+
 // TopenMsg opens a file
 type TopenMsg struct {
 	Fid  uint32
@@ -189,6 +316,92 @@ func (m *RopenMsg) Encode(buf []byte) int {
 	return n + 4
 }
 
+func DecodeRopen(buf []byte) (*RopenMsg, error) {
+	if len(buf) < 17 {
+		return nil, fmt.Errorf("Ropen too short")
+	}
+	qid, _ := DecodeQid(buf)
+	return &RopenMsg{
+		Qid:    qid,
+		Iounit: binary.LittleEndian.Uint32(buf[13:17]),
+	}, nil
+}
+
+// TcreateMsg creates a file in the directory fid and opens it. On success
+// fid refers to the new file.
+type TcreateMsg struct {
+	Fid  uint32
+	Name string
+	Perm uint32
+	Mode uint8
+
+	// Extension is the 9P2000.u special file description, such as a
+	// symlink target.
+	Extension string
+}
+
+func (m *TcreateMsg) Type() uint8 { return Tcreate }
+
+func (m *TcreateMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	n := 4 + EncodeString(buf[4:], m.Name)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Perm)
+	buf[n+4] = m.Mode
+	return n + 5
+}
+
+// EncodeU encodes the message with the 9P2000.u extension field.
+func (m *TcreateMsg) EncodeU(buf []byte) int {
+	n := m.Encode(buf)
+	return n + EncodeString(buf[n:], m.Extension)
+}
+
+func DecodeTcreate(buf []byte) (*TcreateMsg, error) {
+	if len(buf) < 11 {
+		return nil, fmt.Errorf("Tcreate too short")
+	}
+	m := &TcreateMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	name, sn := DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+5 {
+		return nil, fmt.Errorf("Tcreate too short")
+	}
+	m.Name = name
+	m.Perm = binary.LittleEndian.Uint32(buf[n : n+4])
+	m.Mode = buf[n+4]
+	if len(buf) > n+5 {
+		m.Extension, _ = DecodeString(buf[n+5:])
+	}
+	return m, nil
+}
+
+// RcreateMsg is the response to Tcreate
+type RcreateMsg struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (m *RcreateMsg) Type() uint8 { return Rcreate }
+
+func (m *RcreateMsg) Encode(buf []byte) int {
+	n := m.Qid.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Iounit)
+	return n + 4
+}
+
+func DecodeRcreate(buf []byte) (*RcreateMsg, error) {
+	if len(buf) < 17 {
+		return nil, fmt.Errorf("Rcreate too short")
+	}
+	qid, _ := DecodeQid(buf)
+	return &RcreateMsg{
+		Qid:    qid,
+		Iounit: binary.LittleEndian.Uint32(buf[13:17]),
+	}, nil
+}
+
 // TreadMsg reads from a file
 type TreadMsg struct {
 	Fid    uint32
@@ -229,6 +442,18 @@ func (m *RreadMsg) Encode(buf []byte) int {
 	return 4 + len(m.Data)
 }
 
+// DecodeRread decodes an Rread. Data aliases buf.
+func DecodeRread(buf []byte) (*RreadMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Rread too short")
+	}
+	count := binary.LittleEndian.Uint32(buf[0:4])
+	if uint64(len(buf)-4) < uint64(count) {
+		return nil, fmt.Errorf("Rread data truncated")
+	}
+	return &RreadMsg{Data: buf[4 : 4+count]}, nil
+}
+
 // TwriteMsg writes to a file
 type TwriteMsg struct {
 	Fid    uint32
@@ -251,7 +476,7 @@ func DecodeTwrite(buf []byte) (*TwriteMsg, error) {
 		return nil, fmt.Errorf("Twrite too short")
 	}
 	count := binary.LittleEndian.Uint32(buf[12:16])
-	if len(buf) < int(16+count) {
+	if uint64(len(buf)-16) < uint64(count) {
 		return nil, fmt.Errorf("Twrite data truncated")
 	}
 	return &TwriteMsg{
@@ -273,6 +498,15 @@ func (m *RwriteMsg) Encode(buf []byte) int {
 	return 4
 }
 
+func DecodeRwrite(buf []byte) (*RwriteMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Rwrite too short")
+	}
+	return &RwriteMsg{
+		Count: binary.LittleEndian.Uint32(buf[0:4]),
+	}, nil
+}
+
 // TclunkMsg closes a fid
 type TclunkMsg struct {
 	Fid uint32
@@ -303,6 +537,36 @@ func (m *RclunkMsg) Encode(buf []byte) int {
 	return 0
 }
 
+// TremoveMsg removes the file behind fid and clunks the fid
+type TremoveMsg struct {
+	Fid uint32
+}
+
+func (m *TremoveMsg) Type() uint8 { return Tremove }
+
+func (m *TremoveMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	return 4
+}
+
+func DecodeTremove(buf []byte) (*TremoveMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Tremove too short")
+	}
+	return &TremoveMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}, nil
+}
+
+// RremoveMsg is the response to Tremove
+type RremoveMsg struct{}
+
+func (m *RremoveMsg) Type() uint8 { return Rremove }
+
+func (m *RremoveMsg) Encode(buf []byte) int {
+	return 0
+}
+
 // TstatMsg requests file stats
 type TstatMsg struct {
 	Fid uint32
@@ -339,9 +603,108 @@ func (m *RstatMsg) Encode(buf []byte) int {
 	return 2 + n
 }
 
+// EncodeU encodes the message with a 9P2000.u stat.
+func (m *RstatMsg) EncodeU(buf []byte) int {
+	n := m.Stat.EncodeU(buf[2:])
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(n))
+	return 2 + n
+}
+
+func DecodeRstat(buf []byte) (*RstatMsg, error) {
+	return decodeRstat(buf, DecodeStat)
+}
+
+// DecodeRstatU decodes an Rstat carrying a 9P2000.u stat.
+func DecodeRstatU(buf []byte) (*RstatMsg, error) {
+	return decodeRstat(buf, DecodeStatU)
+}
+
+func decodeRstat(buf []byte, decodeStat func([]byte) (Stat, int)) (*RstatMsg, error) {
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("Rstat too short")
+	}
+	size := int(binary.LittleEndian.Uint16(buf[0:2]))
+	if len(buf) < 2+size {
+		return nil, fmt.Errorf("Rstat too short")
+	}
+	stat, n := decodeStat(buf[2 : 2+size])
+	if n == 0 {
+		return nil, fmt.Errorf("Rstat: bad stat")
+	}
+	return &RstatMsg{Stat: stat}, nil
+}
+
+// TwstatMsg changes the metadata of the file behind fid. Fields holding
+// their "don't touch" values (see NullStat) are left unchanged.
+type TwstatMsg struct {
+	Fid  uint32
+	Stat Stat
+}
+
+func (m *TwstatMsg) Type() uint8 { return Twstat }
+
+func (m *TwstatMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	n := m.Stat.Encode(buf[6:])
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(n))
+	return 6 + n
+}
+
+// EncodeU encodes the message with a 9P2000.u stat.
+func (m *TwstatMsg) EncodeU(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	n := m.Stat.EncodeU(buf[6:])
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(n))
+	return 6 + n
+}
+
+func DecodeTwstat(buf []byte) (*TwstatMsg, error) {
+	m, err := decodeTwstat(buf, DecodeStat)
+	if err != nil {
+		return nil, err
+	}
+	// The base protocol has no numeric ids to change.
+	m.Stat.NUid, m.Stat.NGid, m.Stat.NMuid = NoUid, NoUid, NoUid
+	return m, nil
+}
+
+// DecodeTwstatU decodes a Twstat carrying a 9P2000.u stat.
+func DecodeTwstatU(buf []byte) (*TwstatMsg, error) {
+	return decodeTwstat(buf, DecodeStatU)
+}
+
+func decodeTwstat(buf []byte, decodeStat func([]byte) (Stat, int)) (*TwstatMsg, error) {
+	if len(buf) < 6 {
+		return nil, fmt.Errorf("Twstat too short")
+	}
+	m := &TwstatMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	size := int(binary.LittleEndian.Uint16(buf[4:6]))
+	if len(buf) < 6+size {
+		return nil, fmt.Errorf("Twstat too short")
+	}
+	stat, n := decodeStat(buf[6 : 6+size])
+	if n == 0 {
+		return nil, fmt.Errorf("Twstat: bad stat")
+	}
+	m.Stat = stat
+	return m, nil
+}
+
+// RwstatMsg is the response to Twstat
+type RwstatMsg struct{}
+
+func (m *RwstatMsg) Type() uint8 { return Rwstat }
+
+func (m *RwstatMsg) Encode(buf []byte) int {
+	return 0
+}
+
 // RerrorMsg indicates an error
 type RerrorMsg struct {
 	Ename string
+	Errno Errno // 9P2000.u only
 }
 
 func (m *RerrorMsg) Type() uint8 { return Rerror }
@@ -350,6 +713,25 @@ func (m *RerrorMsg) Encode(buf []byte) int {
 	return EncodeString(buf, m.Ename)
 }
 
+// EncodeU encodes the message with the 9P2000.u errno field.
+func (m *RerrorMsg) EncodeU(buf []byte) int {
+	n := EncodeString(buf, m.Ename)
+	binary.LittleEndian.PutUint32(buf[n:n+4], uint32(m.Errno))
+	return n + 4
+}
+
+func DecodeRerror(buf []byte) (*RerrorMsg, error) {
+	ename, sn := DecodeString(buf)
+	if sn == 0 {
+		return nil, fmt.Errorf("Rerror too short")
+	}
+	m := &RerrorMsg{Ename: ename}
+	if len(buf) >= sn+4 {
+		m.Errno = Errno(binary.LittleEndian.Uint32(buf[sn : sn+4]))
+	}
+	return m, nil
+}
+
 // TflushMsg cancels a pending request
 type TflushMsg struct {
 	Oldtag uint16
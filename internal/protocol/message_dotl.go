@@ -0,0 +1,633 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// 9P2000.L messages
+//
+// The Linux dialect replaces Topen, Tcreate, Tstat and directory Treads
+// with messages modelled on the Linux VFS, and reports errors as errno
+// values rather than strings.
+
+// Linux open flags carried by Tlopen and Tlcreate
+const (
+	LOWRONLY uint32 = 0x0001
+	LORDWR   uint32 = 0x0002
+	LOTRUNC  uint32 = 0x0200
+)
+
+// Tgetattr request mask and Rgetattr valid bits
+const (
+	GetattrMode   uint64 = 0x00000001
+	GetattrNlink  uint64 = 0x00000002
+	GetattrUID    uint64 = 0x00000004
+	GetattrGID    uint64 = 0x00000008
+	GetattrRdev   uint64 = 0x00000010
+	GetattrAtime  uint64 = 0x00000020
+	GetattrMtime  uint64 = 0x00000040
+	GetattrCtime  uint64 = 0x00000080
+	GetattrIno    uint64 = 0x00000100
+	GetattrSize   uint64 = 0x00000200
+	GetattrBlocks uint64 = 0x00000400
+	GetattrBasic  uint64 = 0x000007ff
+)
+
+// Tsetattr valid bits
+const (
+	SetattrMode     uint32 = 0x00000001
+	SetattrUID      uint32 = 0x00000002
+	SetattrGID      uint32 = 0x00000004
+	SetattrSize     uint32 = 0x00000008
+	SetattrAtime    uint32 = 0x00000010
+	SetattrMtime    uint32 = 0x00000020
+	SetattrCtime    uint32 = 0x00000040
+	SetattrAtimeSet uint32 = 0x00000080
+	SetattrMtimeSet uint32 = 0x00000100
+)
+
+// Linux file type bits for Rgetattr mode and Rreaddir entries
+const (
+	SIFDIR uint32 = 0040000
+	SIFREG uint32 = 0100000
+
+	DTDIR uint8 = 4
+	DTREG uint8 = 8
+)
+
+// ATRemoveDir is the Tunlinkat flag asking to remove a directory, as
+// rmdir(2) does
+const ATRemoveDir uint32 = 0x200
+
+// V9FSMagic is the filesystem type reported by Rstatfs
+const V9FSMagic uint32 = 0x01021997
+
+// RlerrorMsg indicates an error in the 9P2000.L dialect
+type RlerrorMsg struct {
+	Ecode Errno
+}
+
+func (m *RlerrorMsg) Type() uint8 { return Rlerror }
+
+func (m *RlerrorMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(m.Ecode))
+	return 4
+}
+
+// TstatfsMsg requests filesystem information
+type TstatfsMsg struct {
+	Fid uint32
+}
+
+func (m *TstatfsMsg) Type() uint8 { return Tstatfs }
+
+func (m *TstatfsMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	return 4
+}
+
+func DecodeTstatfs(buf []byte) (*TstatfsMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Tstatfs too short")
+	}
+	return &TstatfsMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}, nil
+}
+
+// RstatfsMsg is the response to Tstatfs
+type RstatfsMsg struct {
+	FsType  uint32
+	Bsize   uint32
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Fsid    uint64
+	Namelen uint32
+}
+
+func (m *RstatfsMsg) Type() uint8 { return Rstatfs }
+
+func (m *RstatfsMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.FsType)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Bsize)
+	binary.LittleEndian.PutUint64(buf[8:16], m.Blocks)
+	binary.LittleEndian.PutUint64(buf[16:24], m.Bfree)
+	binary.LittleEndian.PutUint64(buf[24:32], m.Bavail)
+	binary.LittleEndian.PutUint64(buf[32:40], m.Files)
+	binary.LittleEndian.PutUint64(buf[40:48], m.Ffree)
+	binary.LittleEndian.PutUint64(buf[48:56], m.Fsid)
+	binary.LittleEndian.PutUint32(buf[56:60], m.Namelen)
+	return 60
+}
+
+// TlopenMsg opens a file with Linux open flags
+type TlopenMsg struct {
+	Fid   uint32
+	Flags uint32
+}
+
+func (m *TlopenMsg) Type() uint8 { return Tlopen }
+
+func (m *TlopenMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Flags)
+	return 8
+}
+
+func DecodeTlopen(buf []byte) (*TlopenMsg, error) {
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("Tlopen too short")
+	}
+	return &TlopenMsg{
+		Fid:   binary.LittleEndian.Uint32(buf[0:4]),
+		Flags: binary.LittleEndian.Uint32(buf[4:8]),
+	}, nil
+}
+
+// Mode converts Linux open flags to a 9P open mode.
+func (m *TlopenMsg) Mode() uint8 {
+	return lflagsToMode(m.Flags)
+}
+
+func lflagsToMode(flags uint32) uint8 {
+	var mode uint8
+	switch {
+	case flags&LORDWR != 0:
+		mode = ORDWR
+	case flags&LOWRONLY != 0:
+		mode = OWRITE
+	default:
+		mode = OREAD
+	}
+	if flags&LOTRUNC != 0 {
+		mode |= OTRUNC
+	}
+	return mode
+}
+
+// RlopenMsg is the response to Tlopen
+type RlopenMsg struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (m *RlopenMsg) Type() uint8 { return Rlopen }
+
+func (m *RlopenMsg) Encode(buf []byte) int {
+	n := m.Qid.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Iounit)
+	return n + 4
+}
+
+// TlcreateMsg creates and opens a file in the directory fid
+type TlcreateMsg struct {
+	Fid   uint32
+	Name  string
+	Flags uint32
+	Mode  uint32
+	Gid   uint32
+}
+
+func (m *TlcreateMsg) Type() uint8 { return Tlcreate }
+
+func (m *TlcreateMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	n := 4 + EncodeString(buf[4:], m.Name)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Flags)
+	binary.LittleEndian.PutUint32(buf[n+4:n+8], m.Mode)
+	binary.LittleEndian.PutUint32(buf[n+8:n+12], m.Gid)
+	return n + 12
+}
+
+func DecodeTlcreate(buf []byte) (*TlcreateMsg, error) {
+	if len(buf) < 18 {
+		return nil, fmt.Errorf("Tlcreate too short")
+	}
+	m := &TlcreateMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	name, sn := DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+12 {
+		return nil, fmt.Errorf("Tlcreate too short")
+	}
+	m.Name = name
+	m.Flags = binary.LittleEndian.Uint32(buf[n : n+4])
+	m.Mode = binary.LittleEndian.Uint32(buf[n+4 : n+8])
+	m.Gid = binary.LittleEndian.Uint32(buf[n+8 : n+12])
+	return m, nil
+}
+
+// RlcreateMsg is the response to Tlcreate
+type RlcreateMsg struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (m *RlcreateMsg) Type() uint8 { return Rlcreate }
+
+func (m *RlcreateMsg) Encode(buf []byte) int {
+	n := m.Qid.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Iounit)
+	return n + 4
+}
+
+// TmkdirMsg creates a directory in the directory dfid
+type TmkdirMsg struct {
+	Dfid uint32
+	Name string
+	Mode uint32
+	Gid  uint32
+}
+
+func (m *TmkdirMsg) Type() uint8 { return Tmkdir }
+
+func (m *TmkdirMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Dfid)
+	n := 4 + EncodeString(buf[4:], m.Name)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Mode)
+	binary.LittleEndian.PutUint32(buf[n+4:n+8], m.Gid)
+	return n + 8
+}
+
+func DecodeTmkdir(buf []byte) (*TmkdirMsg, error) {
+	if len(buf) < 14 {
+		return nil, fmt.Errorf("Tmkdir too short")
+	}
+	m := &TmkdirMsg{
+		Dfid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	name, sn := DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+8 {
+		return nil, fmt.Errorf("Tmkdir too short")
+	}
+	m.Name = name
+	m.Mode = binary.LittleEndian.Uint32(buf[n : n+4])
+	m.Gid = binary.LittleEndian.Uint32(buf[n+4 : n+8])
+	return m, nil
+}
+
+// RmkdirMsg is the response to Tmkdir
+type RmkdirMsg struct {
+	Qid Qid
+}
+
+func (m *RmkdirMsg) Type() uint8 { return Rmkdir }
+
+func (m *RmkdirMsg) Encode(buf []byte) int {
+	return m.Qid.Encode(buf)
+}
+
+// TunlinkatMsg removes name from the directory dfid
+type TunlinkatMsg struct {
+	Dfid  uint32
+	Name  string
+	Flags uint32 // AT_REMOVEDIR for rmdir
+}
+
+func (m *TunlinkatMsg) Type() uint8 { return Tunlinkat }
+
+func (m *TunlinkatMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Dfid)
+	n := 4 + EncodeString(buf[4:], m.Name)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Flags)
+	return n + 4
+}
+
+func DecodeTunlinkat(buf []byte) (*TunlinkatMsg, error) {
+	if len(buf) < 10 {
+		return nil, fmt.Errorf("Tunlinkat too short")
+	}
+	m := &TunlinkatMsg{
+		Dfid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	name, sn := DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+4 {
+		return nil, fmt.Errorf("Tunlinkat too short")
+	}
+	m.Name = name
+	m.Flags = binary.LittleEndian.Uint32(buf[n : n+4])
+	return m, nil
+}
+
+// RunlinkatMsg is the response to Tunlinkat
+type RunlinkatMsg struct{}
+
+func (m *RunlinkatMsg) Type() uint8 { return Runlinkat }
+
+func (m *RunlinkatMsg) Encode(buf []byte) int {
+	return 0
+}
+
+// TrenameatMsg renames oldname in olddirfid to newname in newdirfid
+type TrenameatMsg struct {
+	OldDirfid uint32
+	OldName   string
+	NewDirfid uint32
+	NewName   string
+}
+
+func (m *TrenameatMsg) Type() uint8 { return Trenameat }
+
+func (m *TrenameatMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.OldDirfid)
+	n := 4 + EncodeString(buf[4:], m.OldName)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.NewDirfid)
+	n += 4
+	n += EncodeString(buf[n:], m.NewName)
+	return n
+}
+
+func DecodeTrenameat(buf []byte) (*TrenameatMsg, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("Trenameat too short")
+	}
+	m := &TrenameatMsg{
+		OldDirfid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	name, sn := DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+6 {
+		return nil, fmt.Errorf("Trenameat too short")
+	}
+	m.OldName = name
+	m.NewDirfid = binary.LittleEndian.Uint32(buf[n : n+4])
+	n += 4
+	name, sn = DecodeString(buf[n:])
+	if sn == 0 {
+		return nil, fmt.Errorf("Trenameat too short")
+	}
+	m.NewName = name
+	return m, nil
+}
+
+// RrenameatMsg is the response to Trenameat
+type RrenameatMsg struct{}
+
+func (m *RrenameatMsg) Type() uint8 { return Rrenameat }
+
+func (m *RrenameatMsg) Encode(buf []byte) int {
+	return 0
+}
+
+// TgetattrMsg requests file attributes
+type TgetattrMsg struct {
+	Fid         uint32
+	RequestMask uint64
+}
+
+func (m *TgetattrMsg) Type() uint8 { return Tgetattr }
+
+func (m *TgetattrMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint64(buf[4:12], m.RequestMask)
+	return 12
+}
+
+func DecodeTgetattr(buf []byte) (*TgetattrMsg, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("Tgetattr too short")
+	}
+	return &TgetattrMsg{
+		Fid:         binary.LittleEndian.Uint32(buf[0:4]),
+		RequestMask: binary.LittleEndian.Uint64(buf[4:12]),
+	}, nil
+}
+
+// RgetattrMsg is the response to Tgetattr
+type RgetattrMsg struct {
+	Valid       uint64
+	Qid         Qid
+	Mode        uint32
+	UID         uint32
+	GID         uint32
+	Nlink       uint64
+	Rdev        uint64
+	Size        uint64
+	Blksize     uint64
+	Blocks      uint64
+	AtimeSec    uint64
+	AtimeNsec   uint64
+	MtimeSec    uint64
+	MtimeNsec   uint64
+	CtimeSec    uint64
+	CtimeNsec   uint64
+	BtimeSec    uint64
+	BtimeNsec   uint64
+	Gen         uint64
+	DataVersion uint64
+}
+
+func (m *RgetattrMsg) Type() uint8 { return Rgetattr }
+
+func (m *RgetattrMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint64(buf[0:8], m.Valid)
+	n := 8 + m.Qid.Encode(buf[8:])
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Mode)
+	binary.LittleEndian.PutUint32(buf[n+4:n+8], m.UID)
+	binary.LittleEndian.PutUint32(buf[n+8:n+12], m.GID)
+	n += 12
+	for _, v := range []uint64{
+		m.Nlink, m.Rdev, m.Size, m.Blksize, m.Blocks,
+		m.AtimeSec, m.AtimeNsec, m.MtimeSec, m.MtimeNsec,
+		m.CtimeSec, m.CtimeNsec, m.BtimeSec, m.BtimeNsec,
+		m.Gen, m.DataVersion,
+	} {
+		binary.LittleEndian.PutUint64(buf[n:n+8], v)
+		n += 8
+	}
+	return n
+}
+
+// TsetattrMsg changes file attributes
+type TsetattrMsg struct {
+	Fid       uint32
+	Valid     uint32
+	Mode      uint32
+	UID       uint32
+	GID       uint32
+	Size      uint64
+	AtimeSec  uint64
+	AtimeNsec uint64
+	MtimeSec  uint64
+	MtimeNsec uint64
+}
+
+func (m *TsetattrMsg) Type() uint8 { return Tsetattr }
+
+func (m *TsetattrMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Valid)
+	binary.LittleEndian.PutUint32(buf[8:12], m.Mode)
+	binary.LittleEndian.PutUint32(buf[12:16], m.UID)
+	binary.LittleEndian.PutUint32(buf[16:20], m.GID)
+	binary.LittleEndian.PutUint64(buf[20:28], m.Size)
+	binary.LittleEndian.PutUint64(buf[28:36], m.AtimeSec)
+	binary.LittleEndian.PutUint64(buf[36:44], m.AtimeNsec)
+	binary.LittleEndian.PutUint64(buf[44:52], m.MtimeSec)
+	binary.LittleEndian.PutUint64(buf[52:60], m.MtimeNsec)
+	return 60
+}
+
+func DecodeTsetattr(buf []byte) (*TsetattrMsg, error) {
+	if len(buf) < 60 {
+		return nil, fmt.Errorf("Tsetattr too short")
+	}
+	return &TsetattrMsg{
+		Fid:       binary.LittleEndian.Uint32(buf[0:4]),
+		Valid:     binary.LittleEndian.Uint32(buf[4:8]),
+		Mode:      binary.LittleEndian.Uint32(buf[8:12]),
+		UID:       binary.LittleEndian.Uint32(buf[12:16]),
+		GID:       binary.LittleEndian.Uint32(buf[16:20]),
+		Size:      binary.LittleEndian.Uint64(buf[20:28]),
+		AtimeSec:  binary.LittleEndian.Uint64(buf[28:36]),
+		AtimeNsec: binary.LittleEndian.Uint64(buf[36:44]),
+		MtimeSec:  binary.LittleEndian.Uint64(buf[44:52]),
+		MtimeNsec: binary.LittleEndian.Uint64(buf[52:60]),
+	}, nil
+}
+
+// RsetattrMsg is the response to Tsetattr
+type RsetattrMsg struct{}
+
+func (m *RsetattrMsg) Type() uint8 { return Rsetattr }
+
+func (m *RsetattrMsg) Encode(buf []byte) int {
+	return 0
+}
+
+// TxattrwalkMsg looks up an extended attribute
+type TxattrwalkMsg struct {
+	Fid    uint32
+	Newfid uint32
+	Name   string
+}
+
+func (m *TxattrwalkMsg) Type() uint8 { return Txattrwalk }
+
+func (m *TxattrwalkMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Newfid)
+	return 8 + EncodeString(buf[8:], m.Name)
+}
+
+// TreaddirMsg reads directory entries
+type TreaddirMsg struct {
+	Fid    uint32
+	Offset uint64 // offset cookie from the last entry previously returned
+	Count  uint32
+}
+
+func (m *TreaddirMsg) Type() uint8 { return Treaddir }
+
+func (m *TreaddirMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint64(buf[4:12], m.Offset)
+	binary.LittleEndian.PutUint32(buf[12:16], m.Count)
+	return 16
+}
+
+func DecodeTreaddir(buf []byte) (*TreaddirMsg, error) {
+	if len(buf) < 16 {
+		return nil, fmt.Errorf("Treaddir too short")
+	}
+	return &TreaddirMsg{
+		Fid:    binary.LittleEndian.Uint32(buf[0:4]),
+		Offset: binary.LittleEndian.Uint64(buf[4:12]),
+		Count:  binary.LittleEndian.Uint32(buf[12:16]),
+	}, nil
+}
+
+// Dirent is one entry in an Rreaddir
+type Dirent struct {
+	Qid    Qid
+	Offset uint64 // cookie to pass in Treaddir to continue after this entry
+	Type   uint8  // DTDIR or DTREG
+	Name   string
+}
+
+// EncodedLen returns the number of bytes Encode writes.
+func (d *Dirent) EncodedLen() int {
+	return 13 + 8 + 1 + 2 + len(d.Name)
+}
+
+func (d *Dirent) Encode(buf []byte) int {
+	n := d.Qid.Encode(buf)
+	binary.LittleEndian.PutUint64(buf[n:n+8], d.Offset)
+	buf[n+8] = d.Type
+	n += 9
+	return n + EncodeString(buf[n:], d.Name)
+}
+
+// DecodeDirent decodes one directory entry, returning the bytes consumed
+// (0 if buf is too short).
+func DecodeDirent(buf []byte) (Dirent, int) {
+	if len(buf) < 24 {
+		return Dirent{}, 0
+	}
+	var d Dirent
+	d.Qid, _ = DecodeQid(buf)
+	d.Offset = binary.LittleEndian.Uint64(buf[13:21])
+	d.Type = buf[21]
+	name, sn := DecodeString(buf[22:])
+	if sn == 0 {
+		return Dirent{}, 0
+	}
+	d.Name = name
+	return d, 22 + sn
+}
+
+// RreaddirMsg is the response to Treaddir
+type RreaddirMsg struct {
+	Data []byte // packed Dirents
+}
+
+func (m *RreaddirMsg) Type() uint8 { return Rreaddir }
+
+func (m *RreaddirMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(m.Data)))
+	copy(buf[4:], m.Data)
+	return 4 + len(m.Data)
+}
+
+// TfsyncMsg flushes a file to stable storage
+type TfsyncMsg struct {
+	Fid      uint32
+	Datasync uint32
+}
+
+func (m *TfsyncMsg) Type() uint8 { return Tfsync }
+
+func (m *TfsyncMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Datasync)
+	return 8
+}
+
+func DecodeTfsync(buf []byte) (*TfsyncMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Tfsync too short")
+	}
+	m := &TfsyncMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	if len(buf) >= 8 {
+		m.Datasync = binary.LittleEndian.Uint32(buf[4:8])
+	}
+	return m, nil
+}
+
+// RfsyncMsg is the response to Tfsync
+type RfsyncMsg struct{}
+
+func (m *RfsyncMsg) Type() uint8 { return Rfsync }
+
+func (m *RfsyncMsg) Encode(buf []byte) int {
+	return 0
+}
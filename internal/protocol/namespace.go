@@ -0,0 +1,34 @@
+package protocol
+
+// Namespace chooses the tree a client sees when it attaches, so that one
+// server can offer different roots to different users, or for different
+// attach names (the aname in "mount -A ... /n/llm admin").
+type Namespace interface {
+	// Root returns the root for uname attaching to aname. uname has
+	// already been authenticated if the server requires it. An error
+	// fails the attach.
+	Root(uname, aname string) (Dir, error)
+}
+
+// NamespaceFunc adapts a function to the Namespace interface.
+type NamespaceFunc func(uname, aname string) (Dir, error)
+
+// Root calls f(uname, aname).
+func (f NamespaceFunc) Root(uname, aname string) (Dir, error) {
+	return f(uname, aname)
+}
+
+// SetNamespace makes attaches get their root from ns. Without one (the
+// default) every attach gets the root passed to NewServer, whatever its
+// aname.
+func (s *Server) SetNamespace(ns Namespace) {
+	s.ns = ns
+}
+
+// attachRoot returns the root for an attach.
+func (s *Server) attachRoot(uname, aname string) (Dir, error) {
+	if s.ns == nil {
+		return s.root, nil
+	}
+	return s.ns.Root(uname, aname)
+}
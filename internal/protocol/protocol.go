@@ -22,14 +22,35 @@ const (
 	// Version is the protocol version we implement
 	Version = "9P2000"
 
-	// MaxMessageSize is the maximum size of a 9P message
+	// VersionL is the Linux dialect, as negotiated by the kernel's v9fs
+	VersionL = "9P2000.L"
+
+	// VersionU is the Unix extension: numeric ids, stat extensions and
+	// errno in Rerror
+	VersionU = "9P2000.u"
+
+	// MaxMessageSize is the default maximum size of a 9P message, and
+	// the size assumed until Tversion negotiates another
 	MaxMessageSize = 8192
 
+	// MinMessageSize is the smallest msize the server will negotiate
+	MinMessageSize = 256
+
+	// MaxMsizeLimit is the largest maximum message size a server can be
+	// configured with
+	MaxMsizeLimit = 16 << 20
+
+	// MaxWalkElem is the most path elements one Twalk may carry
+	MaxWalkElem = 16
+
 	// NoTag is used for Tversion/Rversion which don't use tags
 	NoTag uint16 = 0xFFFF
 
 	// NoFid represents an invalid fid
 	NoFid uint32 = 0xFFFFFFFF
+
+	// NoUid represents an unknown numeric user or group (9P2000.u)
+	NoUid uint32 = 0xFFFFFFFF
 )
 
 // Message types (T = request from client, R = response from server)
@@ -64,6 +85,34 @@ const (
 	Rwstat   uint8 = 127
 )
 
+// 9P2000.L message types
+const (
+	Tlerror    uint8 = 6 // never sent
+	Rlerror    uint8 = 7
+	Tstatfs    uint8 = 8
+	Rstatfs    uint8 = 9
+	Tlopen     uint8 = 12
+	Rlopen     uint8 = 13
+	Tlcreate   uint8 = 14
+	Rlcreate   uint8 = 15
+	Tgetattr   uint8 = 24
+	Rgetattr   uint8 = 25
+	Tsetattr   uint8 = 26
+	Rsetattr   uint8 = 27
+	Txattrwalk uint8 = 30
+	Rxattrwalk uint8 = 31
+	Treaddir   uint8 = 40
+	Rreaddir   uint8 = 41
+	Tfsync     uint8 = 50
+	Rfsync     uint8 = 51
+	Tmkdir     uint8 = 72
+	Rmkdir     uint8 = 73
+	Trenameat  uint8 = 74
+	Rrenameat  uint8 = 75
+	Tunlinkat  uint8 = 76
+	Runlinkat  uint8 = 77
+)
+
 // Open modes
 const (
 	OREAD  uint8 = 0  // open for read
@@ -78,6 +127,7 @@ const (
 	DMDIR    uint32 = 0x80000000 // directory
 	DMAPPEND uint32 = 0x40000000 // append only
 	DMEXCL   uint32 = 0x20000000 // exclusive use
+	DMAUTH   uint32 = 0x08000000 // authentication file
 	DMTMP    uint32 = 0x04000000 // temporary file
 )
 
@@ -93,6 +143,7 @@ const (
 	QTDIR    uint8 = 0x80 // directory
 	QTAPPEND uint8 = 0x40 // append-only
 	QTEXCL   uint8 = 0x20 // exclusive use
+	QTAUTH   uint8 = 0x08 // authentication file
 	QTTMP    uint8 = 0x04 // temporary
 	QTFILE   uint8 = 0x00 // regular file
 )
@@ -111,60 +162,96 @@ type Stat struct {
 	Uid    string // owner
 	Gid    string // group
 	Muid   string // last modifier
+
+	// 9P2000.u extensions
+	Extension string // special file description (symlink target etc.)
+	NUid      uint32 // numeric owner
+	NGid      uint32 // numeric group
+	NMuid     uint32 // numeric last modifier
 }
 
 // Encoder handles encoding messages to the wire format
 type Encoder struct {
-	w   io.Writer
-	buf []byte
+	w     io.Writer
+	msize uint32
 }
 
-// NewEncoder creates a new encoder
+// NewEncoder creates a new encoder for messages of up to MaxMessageSize
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
-		w:   w,
-		buf: make([]byte, MaxMessageSize),
+		w:     w,
+		msize: MaxMessageSize,
 	}
 }
 
+// SetMsize sets the largest message the encoder will write, normally the
+// msize negotiated by Tversion.
+func (e *Encoder) SetMsize(msize uint32) {
+	e.msize = msize
+}
+
 // Decoder handles decoding messages from the wire format
 type Decoder struct {
-	r   io.Reader
-	buf []byte
+	r     io.Reader
+	msize uint32
+	buf   *[]byte // pooled, msize bytes
 }
 
-// NewDecoder creates a new decoder
+// NewDecoder creates a new decoder for messages of up to MaxMessageSize
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{
-		r:   r,
-		buf: make([]byte, MaxMessageSize),
+		r:     r,
+		msize: MaxMessageSize,
 	}
 }
 
-// ReadMessage reads a complete 9P message from the stream
+// SetMsize sets the largest message the decoder will accept, normally the
+// msize negotiated by Tversion. The payload returned by the previous
+// ReadMessage is no longer valid afterwards.
+func (d *Decoder) SetMsize(msize uint32) {
+	d.Release()
+	d.msize = msize
+}
+
+// Release returns the decoder's buffer to the pool. The payload returned by
+// the previous ReadMessage is no longer valid afterwards.
+func (d *Decoder) Release() {
+	if d.buf != nil {
+		putBuffer(d.buf)
+		d.buf = nil
+	}
+}
+
+// ReadMessage reads a complete 9P message from the stream. The payload
+// refers to the decoder's buffer and is only valid until the next call.
 func (d *Decoder) ReadMessage() (msgType uint8, tag uint16, payload []byte, err error) {
+	if d.buf == nil {
+		d.buf = getBuffer(d.msize)
+	}
+	buf := *d.buf
+
 	// Read 4-byte size
-	if _, err := io.ReadFull(d.r, d.buf[:4]); err != nil {
+	if _, err := io.ReadFull(d.r, buf[:4]); err != nil {
 		return 0, 0, nil, fmt.Errorf("reading size: %w", err)
 	}
-	size := binary.LittleEndian.Uint32(d.buf[:4])
+	size := binary.LittleEndian.Uint32(buf[:4])
 
 	if size < 7 {
 		return 0, 0, nil, fmt.Errorf("message too small: %d", size)
 	}
-	if size > MaxMessageSize {
+	if size > d.msize {
 		return 0, 0, nil, fmt.Errorf("message too large: %d", size)
 	}
 
 	// Read rest of message
 	remaining := size - 4
-	if _, err := io.ReadFull(d.r, d.buf[:remaining]); err != nil {
+	if _, err := io.ReadFull(d.r, buf[:remaining]); err != nil {
 		return 0, 0, nil, fmt.Errorf("reading message: %w", err)
 	}
 
-	msgType = d.buf[0]
-	tag = binary.LittleEndian.Uint16(d.buf[1:3])
-	payload = d.buf[3:remaining]
+	msgType = buf[0]
+	tag = binary.LittleEndian.Uint16(buf[1:3])
+	payload = buf[3:remaining]
 
 	return msgType, tag, payload, nil
 }
@@ -172,16 +259,20 @@ func (d *Decoder) ReadMessage() (msgType uint8, tag uint16, payload []byte, err
 // WriteMessage writes a complete 9P message to the stream
 func (e *Encoder) WriteMessage(msgType uint8, tag uint16, payload []byte) error {
 	size := uint32(4 + 1 + 2 + len(payload))
-	if size > MaxMessageSize {
+	if size > e.msize {
 		return fmt.Errorf("message too large: %d", size)
 	}
 
-	binary.LittleEndian.PutUint32(e.buf[0:4], size)
-	e.buf[4] = msgType
-	binary.LittleEndian.PutUint16(e.buf[5:7], tag)
-	copy(e.buf[7:], payload)
+	b := getBuffer(size)
+	defer putBuffer(b)
+	buf := *b
 
-	_, err := e.w.Write(e.buf[:size])
+	binary.LittleEndian.PutUint32(buf[0:4], size)
+	buf[4] = msgType
+	binary.LittleEndian.PutUint16(buf[5:7], tag)
+	copy(buf[7:], payload)
+
+	_, err := e.w.Write(buf)
 	return err
 }
 
@@ -193,15 +284,17 @@ func EncodeString(buf []byte, s string) int {
 	return 2 + len(s)
 }
 
+// DecodeString decodes a string and returns the number of bytes consumed,
+// or 0 if buf is too short to hold it.
 func DecodeString(buf []byte) (string, int) {
 	if len(buf) < 2 {
 		return "", 0
 	}
-	size := binary.LittleEndian.Uint16(buf[0:2])
-	if len(buf) < int(2+size) {
+	n := 2 + int(binary.LittleEndian.Uint16(buf[0:2]))
+	if len(buf) < n {
 		return "", 0
 	}
-	return string(buf[2 : 2+size]), int(2 + size)
+	return string(buf[2:n]), n
 }
 
 // Qid encoding
@@ -227,6 +320,15 @@ func DecodeQid(buf []byte) (Qid, int) {
 // Stat encoding
 
 func (s *Stat) Encode(buf []byte) int {
+	return s.encode(buf, false)
+}
+
+// EncodeU encodes the stat with the 9P2000.u extension fields.
+func (s *Stat) EncodeU(buf []byte) int {
+	return s.encode(buf, true)
+}
+
+func (s *Stat) encode(buf []byte, dotu bool) int {
 	// Skip size field, we'll fill it at the end
 	n := 2
 
@@ -251,6 +353,14 @@ func (s *Stat) Encode(buf []byte) int {
 	n += EncodeString(buf[n:], s.Gid)
 	n += EncodeString(buf[n:], s.Muid)
 
+	if dotu {
+		n += EncodeString(buf[n:], s.Extension)
+		binary.LittleEndian.PutUint32(buf[n:n+4], s.NUid)
+		binary.LittleEndian.PutUint32(buf[n+4:n+8], s.NGid)
+		binary.LittleEndian.PutUint32(buf[n+8:n+12], s.NMuid)
+		n += 12
+	}
+
 	// Fill in size (total - 2 for size field itself)
 	s.Size = uint16(n - 2)
 	binary.LittleEndian.PutUint16(buf[0:2], s.Size)
@@ -258,6 +368,26 @@ func (s *Stat) Encode(buf []byte) int {
 	return n
 }
 
+// EncodedLen returns the number of bytes Encode writes, including the
+// leading size field.
+func (s *Stat) EncodedLen() int {
+	return 2 + 2 + 4 + 13 + 4 + 4 + 4 + 8 +
+		2 + len(s.Name) + 2 + len(s.Uid) + 2 + len(s.Gid) + 2 + len(s.Muid)
+}
+
+// EncodedLenU returns the number of bytes EncodeU writes.
+func (s *Stat) EncodedLenU() int {
+	return s.EncodedLen() + 2 + len(s.Extension) + 12
+}
+
+// statFixedLen is the length of a stat's fixed fields, including the
+// leading size and the length prefixes of its four strings.
+const statFixedLen = 2 + 2 + 4 + 13 + 4 + 4 + 4 + 8 + 4*2
+
+// DecodeStat decodes a stat and returns the number of bytes consumed,
+// which is its size field plus 2, or 0 if the stat is malformed. Fields
+// are only read from within the size the stat claims, and any bytes after
+// the last string (such as 9P2000.u fields) are skipped.
 func DecodeStat(buf []byte) (Stat, int) {
 	if len(buf) < 2 {
 		return Stat{}, 0
@@ -265,10 +395,11 @@ func DecodeStat(buf []byte) (Stat, int) {
 
 	s := Stat{}
 	s.Size = binary.LittleEndian.Uint16(buf[0:2])
-
-	if len(buf) < int(s.Size)+2 {
+	size := int(s.Size) + 2
+	if size < statFixedLen || len(buf) < size {
 		return Stat{}, 0
 	}
+	buf = buf[:size]
 
 	n := 2
 	s.Type = binary.LittleEndian.Uint16(buf[n : n+2])
@@ -289,17 +420,58 @@ func DecodeStat(buf []byte) (Stat, int) {
 	s.Length = binary.LittleEndian.Uint64(buf[n : n+8])
 	n += 8
 
+	for _, field := range []*string{&s.Name, &s.Uid, &s.Gid, &s.Muid} {
+		var sn int
+		*field, sn = DecodeString(buf[n:])
+		if sn == 0 {
+			return Stat{}, 0
+		}
+		n += sn
+	}
+
+	return s, size
+}
+
+// DecodeStatU decodes a stat with the 9P2000.u extension fields.
+func DecodeStatU(buf []byte) (Stat, int) {
+	s, size := DecodeStat(buf)
+	if size == 0 {
+		return Stat{}, 0
+	}
+
+	n := s.EncodedLen()
+	if n > size {
+		return Stat{}, 0
+	}
 	var sn int
-	s.Name, sn = DecodeString(buf[n:])
-	n += sn
-	s.Uid, sn = DecodeString(buf[n:])
-	n += sn
-	s.Gid, sn = DecodeString(buf[n:])
-	n += sn
-	s.Muid, sn = DecodeString(buf[n:])
+	s.Extension, sn = DecodeString(buf[n:size])
 	n += sn
+	if sn == 0 || n+12 > size {
+		return Stat{}, 0
+	}
+	s.NUid = binary.LittleEndian.Uint32(buf[n : n+4])
+	s.NGid = binary.LittleEndian.Uint32(buf[n+4 : n+8])
+	s.NMuid = binary.LittleEndian.Uint32(buf[n+8 : n+12])
 
-	return s, int(s.Size) + 2
+	return s, size
+}
+
+// NullStat returns a stat whose every field means "don't touch". A Twstat
+// built from it changes only the fields that are then set; one sent
+// unchanged asks the server to commit the file to stable storage.
+func NullStat() Stat {
+	return Stat{
+		Type:   ^uint16(0),
+		Dev:    ^uint32(0),
+		Qid:    Qid{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)},
+		Mode:   ^uint32(0),
+		Atime:  ^uint32(0),
+		Mtime:  ^uint32(0),
+		Length: ^uint64(0),
+		NUid:   NoUid,
+		NGid:   NoUid,
+		NMuid:  NoUid,
+	}
 }
 
 // MessageName returns the human-readable name of a message type
@@ -319,6 +491,18 @@ func MessageName(t uint8) string {
 		Tremove: "Tremove", Rremove: "Rremove",
 		Tstat: "Tstat", Rstat: "Rstat",
 		Twstat: "Twstat", Rwstat: "Rwstat",
+		Rlerror: "Rlerror",
+		Tstatfs: "Tstatfs", Rstatfs: "Rstatfs",
+		Tlopen: "Tlopen", Rlopen: "Rlopen",
+		Tlcreate: "Tlcreate", Rlcreate: "Rlcreate",
+		Tgetattr: "Tgetattr", Rgetattr: "Rgetattr",
+		Tsetattr: "Tsetattr", Rsetattr: "Rsetattr",
+		Txattrwalk: "Txattrwalk", Rxattrwalk: "Rxattrwalk",
+		Treaddir: "Treaddir", Rreaddir: "Rreaddir",
+		Tfsync: "Tfsync", Rfsync: "Rfsync",
+		Tmkdir: "Tmkdir", Rmkdir: "Rmkdir",
+		Trenameat: "Trenameat", Rrenameat: "Rrenameat",
+		Tunlinkat: "Tunlinkat", Runlinkat: "Runlinkat",
 	}
 	if name, ok := names[t]; ok {
 		return name
@@ -0,0 +1,97 @@
+package protocol
+
+import "context"
+
+// ReadOnly returns a view of the tree rooted at d in which nothing can be
+// written, created, removed or renamed. Reads go through unchanged. Write
+// permission bits are cleared from every stat so that clients can tell.
+func ReadOnly(d Dir) Dir {
+	return readOnlyDir{readOnlyFile{d}, d}
+}
+
+// readOnly wraps f, and f's children if it is a directory
+func readOnly(f File) File {
+	if d, ok := f.(Dir); ok {
+		return ReadOnly(d)
+	}
+	return readOnlyFile{f}
+}
+
+// writes reports whether an open with mode could change the file
+func writes(mode uint8) bool {
+	return mode&3 == OWRITE || mode&3 == ORDWR || mode&OTRUNC != 0
+}
+
+// readOnlyFile embeds only File, so a wrapped file's Remover, Renamer and
+// Truncater methods are hidden from the server.
+type readOnlyFile struct {
+	File
+}
+
+func (f readOnlyFile) Stat() Stat {
+	s := f.File.Stat()
+	s.Mode &^= 0222
+	return s
+}
+
+func (f readOnlyFile) Open(ctx context.Context, mode uint8) (Handle, error) {
+	if writes(mode) {
+		return nil, ErrPermission
+	}
+	h, err := f.File.Open(ctx, mode)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	return readOnlyHandle{h}, nil
+}
+
+func (f readOnlyFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	if cr, ok := f.File.(ContextReader); ok {
+		return cr.ReadContext(ctx, p, offset)
+	}
+	return f.File.Read(p, offset)
+}
+
+func (f readOnlyFile) Write(p []byte, offset int64) (int, error) {
+	return 0, ErrPermission
+}
+
+func (f readOnlyFile) CloseContext(ctx context.Context) error {
+	if cc, ok := f.File.(ContextCloser); ok {
+		return cc.CloseContext(ctx)
+	}
+	return f.File.Close()
+}
+
+type readOnlyDir struct {
+	readOnlyFile
+	dir Dir
+}
+
+func (d readOnlyDir) Children() []File {
+	var children []File
+	for _, f := range d.dir.Children() {
+		children = append(children, readOnly(f))
+	}
+	return children
+}
+
+func (d readOnlyDir) Lookup(name string) (File, error) {
+	f, err := d.dir.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return readOnly(f), nil
+}
+
+func (d readOnlyDir) Create(name string, perm uint32) (File, error) {
+	return nil, ErrPermission
+}
+
+type readOnlyHandle struct {
+	Handle
+}
+
+func (h readOnlyHandle) Write(ctx context.Context, p []byte, offset int64) (int, error) {
+	return 0, ErrPermission
+}
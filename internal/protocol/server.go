@@ -2,32 +2,102 @@ package protocol
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultMaxOutstanding is the default limit on requests (tags) a single
+// connection may have in flight at once.
+const DefaultMaxOutstanding = 64
+
+// ErrServerClosed is returned by Serve once Shutdown has been called.
+var ErrServerClosed = errors.New("server closed")
+
 // Server is a 9P file server
 type Server struct {
-	root    Dir
-	debug   bool
-	mu      sync.Mutex
-	clients map[net.Conn]*clientState
+	root           Dir
+	debug          bool
+	maxOutstanding int
+	maxMsize       uint32
+	maxConns       int
+	maxFids        int
+	idleTimeout    time.Duration
+	uid, gid       uint32 // numeric owner of every file
+	auth           Authenticator
+	ns             Namespace
+	trace          *tracer
+
+	mu        sync.Mutex
+	clients   map[net.Conn]*clientState
+	listeners map[net.Listener]struct{}
+	conns     sync.WaitGroup // running connections
+	nextConn  atomic.Uint64
+	closing   atomic.Bool // set by Shutdown
 }
 
-// clientState tracks state for a single client connection
+// clientState tracks state for a single client connection.
+// Requests on a connection are dispatched concurrently, so the fid table
+// and in-flight tags are guarded by mu and replies are serialized by wmu.
 type clientState struct {
-	fids  map[uint32]File
-	msize uint32
+	id      uint64 // for traces
+	conn    net.Conn
+	info    *Conn
+	ctx     context.Context // cancelled when the connection ends
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	fids    map[uint32]*fidState
+	maxFids int // 0 for no limit
+	tags    map[uint16]*request
+	msize   uint32
+	dialect dialect
+	tlsUser string // common name of the verified TLS client certificate
+	tlsCert bool   // whether a TLS client certificate was verified
+
+	wmu sync.Mutex
+	enc *Encoder
+}
+
+// dialect is the protocol variant negotiated by Tversion
+type dialect int
+
+const (
+	dialect9P2000 dialect = iota
+	dialect9P2000U
+	dialect9P2000L
+)
+
+// fidState is the server's state for one client fid
+type fidState struct {
+	file   File
+	handle Handle // set once the fid has been opened
+}
+
+// request tracks a single in-flight T-message
+type request struct {
+	tag    uint16
+	ctx    context.Context // cancelled by Tflush or once the reply is sent
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the reply has been written
 }
 
 // NewServer creates a new 9P server with the given root directory
 func NewServer(root Dir) *Server {
 	return &Server{
-		root:    root,
-		clients: make(map[net.Conn]*clientState),
+		root:           root,
+		maxOutstanding: DefaultMaxOutstanding,
+		maxMsize:       MaxMessageSize,
+		uid:            uint32(os.Getuid()),
+		gid:            uint32(os.Getgid()),
+		clients:        make(map[net.Conn]*clientState),
+		listeners:      make(map[net.Listener]struct{}),
 	}
 }
 
@@ -36,55 +106,247 @@ func (s *Server) SetDebug(debug bool) {
 	s.debug = debug
 }
 
-// Serve handles incoming connections on the listener
+// SetMaxOutstanding sets how many requests a connection may have in flight
+// at once. Further requests are not read from the connection until an
+// earlier one completes. Values below 1 restore the default.
+func (s *Server) SetMaxOutstanding(n int) {
+	if n < 1 {
+		n = DefaultMaxOutstanding
+	}
+	s.maxOutstanding = n
+}
+
+// SetMaxMsize sets the largest msize the server will agree to in Tversion.
+// Larger messages let clients such as Linux v9fs read a long response in
+// fewer round trips. The value is clamped to [MinMessageSize, MaxMsizeLimit].
+func (s *Server) SetMaxMsize(n uint32) {
+	if n < MinMessageSize {
+		n = MinMessageSize
+	}
+	if n > MaxMsizeLimit {
+		n = MaxMsizeLimit
+	}
+	s.maxMsize = n
+}
+
+// SetOwner sets the numeric user and group that own every file, as
+// reported to 9P2000.u and 9P2000.L clients. The default is the user
+// running the server.
+func (s *Server) SetOwner(uid, gid uint32) {
+	s.uid = uid
+	s.gid = gid
+}
+
+// SetMaxConns limits how many connections the server handles at once.
+// Connections accepted beyond the limit are closed straight away. Zero,
+// the default, means no limit.
+func (s *Server) SetMaxConns(n int) {
+	s.maxConns = max(n, 0)
+}
+
+// SetMaxFids limits how many fids each connection may hold. Attaches and
+// walks that would exceed it fail. Zero, the default, means no limit.
+func (s *Server) SetMaxFids(n int) {
+	s.maxFids = max(n, 0)
+}
+
+// SetIdleTimeout closes connections that have no request in flight and
+// send nothing for d. A request that takes longer than d, such as a slow
+// LLM call, does not count as idle. Zero, the default, means no timeout.
+func (s *Server) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout = max(d, 0)
+}
+
+// Serve handles incoming connections on the listener until ctx is
+// cancelled, the listener fails or Shutdown is called, in which case it
+// returns ErrServerClosed.
 func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing.Load() {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+	}()
+
+	var backoff time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			select {
-			case <-ctx.Done():
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
 				return ctx.Err()
-			default:
-				log.Printf("accept error: %v", err)
-				continue
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Probably out of file descriptors; wait for some to free up.
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			log.Printf("accept error: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
 		}
+		backoff = 0
 
-		go s.handleConn(conn)
+		state, err := s.newClient(conn)
+		if err != nil {
+			log.Printf("refusing connection from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		go s.handleConn(conn, state)
 	}
 }
 
 // ServeConn handles a single connection (useful for testing)
 func (s *Server) ServeConn(conn net.Conn) {
-	s.handleConn(conn)
+	state, err := s.newClient(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.handleConn(conn, state)
 }
 
-func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
+// Shutdown stops the server gracefully. It closes the listeners given to
+// Serve, stops reading requests from every connection and waits for those
+// already in flight to finish. If ctx ends first, the remaining requests
+// are cancelled and Shutdown returns ctx's error once they have unwound.
+// Either way every fid is then clunked and every connection closed, as
+// when a client disconnects.
+//
+// Buffered writes that were never committed by a clunk, such as a prompt
+// still being written to ask, are discarded.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing.Store(true)
+	for l := range s.listeners {
+		l.Close()
+	}
+	// Wake the readers; handleConn sees closing and stops reading.
+	for conn := range s.clients {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
 
-	state := &clientState{
-		fids:  make(map[uint32]File),
-		msize: MaxMessageSize,
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
 	}
 
 	s.mu.Lock()
-	s.clients[conn] = state
+	for _, state := range s.clients {
+		state.cancel()
+	}
 	s.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// newClient registers a connection, unless the server is shutting down or
+// already has as many as it may.
+func (s *Server) newClient(conn net.Conn) (*clientState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing.Load() {
+		return nil, ErrServerClosed
+	}
+	if s.maxConns > 0 && len(s.clients) >= s.maxConns {
+		return nil, fmt.Errorf("limit of %d connections reached", s.maxConns)
+	}
+
+	info := &Conn{remote: conn.RemoteAddr()}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), connKey{}, info))
+	state := &clientState{
+		id:      s.nextConn.Add(1),
+		conn:    conn,
+		info:    info,
+		ctx:     ctx,
+		cancel:  cancel,
+		fids:    make(map[uint32]*fidState),
+		maxFids: s.maxFids,
+		tags:    make(map[uint16]*request),
+		msize:   MaxMessageSize,
+		enc:     NewEncoder(conn),
+	}
+	s.clients[conn] = state
+	s.conns.Add(1)
+	return state, nil
+}
 
+func (s *Server) handleConn(conn net.Conn, state *clientState) {
+	defer s.conns.Done()
+	defer conn.Close()
 	defer func() {
 		s.mu.Lock()
 		delete(s.clients, conn)
 		s.mu.Unlock()
 	}()
 
+	tlsUser, tlsCert, err := verifiedTLSUser(conn)
+	if err != nil {
+		log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	state.tlsUser, state.tlsCert = tlsUser, tlsCert
+
+	s.traceConn(state, "connect")
+	defer s.traceConn(state, "disconnect")
+
 	dec := NewDecoder(conn)
-	enc := NewEncoder(conn)
-	buf := make([]byte, MaxMessageSize)
+	defer dec.Release()
+
+	// Each T-message is handled on its own goroutine so that a slow
+	// request (an LLM call behind a Twrite) does not hold up other fids.
+	// sem bounds the number of requests in flight on this connection.
+	sem := make(chan struct{}, s.maxOutstanding)
+	var wg sync.WaitGroup
+
+	// When the connection ends, clean up as if the client had flushed
+	// every request and clunked every fid. A client that hangs up has no
+	// use for the replies, so its requests are cancelled; on shutdown they
+	// are first given the chance to finish. Handles are closed with the
+	// cancelled context, so nothing they buffered is committed.
+	defer func() {
+		if !s.closing.Load() {
+			state.cancel()
+		}
+		wg.Wait()
+		state.cancel()
+		state.clunkAll(state.ctx)
+		state.info.close()
+	}()
 
 	for {
+		if s.closing.Load() {
+			return
+		}
+		s.armIdle(state)
 		msgType, tag, payload, err := dec.ReadMessage()
 		if err != nil {
-			if err != io.EOF {
+			var ne net.Error
+			switch {
+			case s.closing.Load(), errors.Is(err, io.EOF):
+			case errors.As(err, &ne) && ne.Timeout():
+				if s.debug {
+					log.Printf("closing idle connection from %s", conn.RemoteAddr())
+				}
+			default:
 				log.Printf("read error: %v", err)
 			}
 			return
@@ -93,70 +355,403 @@ func (s *Server) handleConn(conn net.Conn) {
 		if s.debug {
 			log.Printf("< %s tag=%d len=%d", MessageName(msgType), tag, len(payload))
 		}
+		s.traceMessage(state, "in", msgType, tag, payload)
 
-		resp, respType := s.handleMessage(state, msgType, payload, buf)
+		// Tversion aborts all outstanding I/O, so handle it once
+		// everything already dispatched has completed.
+		if msgType == Tversion {
+			wg.Wait()
+			buf := getBuffer(state.getMsize())
+			resp, respType := s.handleMessage(state, nil, msgType, payload, *buf)
+			err := s.reply(state, tag, respType, resp, nil)
+			putBuffer(buf)
+			if err != nil {
+				log.Printf("write error: %v", err)
+				return
+			}
 
-		if s.debug {
-			log.Printf("> %s tag=%d len=%d", MessageName(respType), tag, len(resp))
+			// Messages in both directions may now use the new msize.
+			msize := state.getMsize()
+			dec.SetMsize(msize)
+			state.wmu.Lock()
+			state.enc.SetMsize(msize)
+			state.wmu.Unlock()
+			continue
 		}
 
-		if err := enc.WriteMessage(respType, tag, resp); err != nil {
-			log.Printf("write error: %v", err)
-			return
+		req, ok := state.begin(tag)
+		if !ok {
+			buf := getBuffer(state.getMsize())
+			resp, respType := s.errorResponse(state, *buf, ErrTagInUse)
+			err := s.reply(state, tag, respType, resp, nil)
+			putBuffer(buf)
+			if err != nil {
+				log.Printf("write error: %v", err)
+				return
+			}
+			continue
+		}
+
+		// The decoder reuses its buffer for the next message.
+		in := getBuffer(uint32(len(payload)))
+		copy(*in, payload)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer putBuffer(in)
+
+			buf := getBuffer(state.getMsize())
+			defer putBuffer(buf)
+
+			// Requests wait for a slot here rather than in the read loop,
+			// which must keep reading: Tflush must always be accepted,
+			// otherwise a connection whose slots are all taken by slow
+			// requests could never cancel them. A request flushed while
+			// waiting is answered without being handled.
+			var resp []byte
+			var respType uint8
+			if msgType != Tflush {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-req.ctx.Done():
+					resp, respType = s.errorResponse(state, *buf, req.ctx.Err())
+				}
+			}
+			if resp == nil {
+				resp, respType = s.handleMessage(state, req, msgType, *in, *buf)
+			}
+			if err := s.reply(state, tag, respType, resp, req); err != nil {
+				log.Printf("write error: %v", err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// reply writes a single R-message. Replies for concurrent requests may
+// complete in any order, so writes are serialized on the connection.
+//
+// If req is non-nil its tag is released before the reply is written, since
+// the client may reuse the tag as soon as it sees the reply. This happens
+// under wmu so that an Rflush waiting on req cannot overtake it.
+func (s *Server) reply(state *clientState, tag uint16, respType uint8, resp []byte, req *request) error {
+	if s.debug {
+		log.Printf("> %s tag=%d len=%d", MessageName(respType), tag, len(resp))
+	}
+
+	state.wmu.Lock()
+	if req != nil {
+		state.end(req)
+	}
+	s.traceMessage(state, "out", respType, tag, resp)
+	err := state.enc.WriteMessage(respType, tag, resp)
+	state.wmu.Unlock()
+
+	if req != nil {
+		close(req.done)
+		s.armIdle(state)
+	}
+	return err
+}
+
+// armIdle starts the idle timeout on the connection if no request is in
+// flight, and stops it otherwise. While a request is in flight the read
+// loop may be blocked waiting for the next message, so the last request
+// to finish rearms the timeout.
+func (s *Server) armIdle(state *clientState) {
+	if s.idleTimeout == 0 {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.tags) > 0 {
+		state.conn.SetReadDeadline(time.Time{})
+	} else {
+		state.conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+	}
+	// Shutdown may have woken the reader in the meantime.
+	if s.closing.Load() {
+		state.conn.SetReadDeadline(time.Now())
+	}
+}
+
+// begin registers tag as in flight. It reports false if the tag is
+// already in use by an outstanding request.
+func (c *clientState) begin(tag uint16) (*request, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, busy := c.tags[tag]; busy {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	req := &request{tag: tag, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	c.tags[tag] = req
+	return req, true
+}
+
+// end releases the tag held by req.
+func (c *clientState) end(req *request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags[req.tag] == req {
+		delete(c.tags, req.tag)
+	}
+	req.cancel()
+}
+
+// clunkAll releases every fid, closing open handles with ctx.
+func (c *clientState) clunkAll(ctx context.Context) {
+	c.mu.Lock()
+	fids := c.fids
+	c.fids = make(map[uint32]*fidState)
+	c.mu.Unlock()
+
+	for _, f := range fids {
+		if f.handle != nil {
+			f.handle.Close(ctx)
 		}
 	}
 }
 
-func (s *Server) handleMessage(state *clientState, msgType uint8, payload []byte, buf []byte) ([]byte, uint8) {
+// pending returns the in-flight request for tag, or nil.
+func (c *clientState) pending(tag uint16) *request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tags[tag]
+}
+
+func (c *clientState) getDialect() dialect {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dialect
+}
+
+func (c *clientState) setDialect(d dialect) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialect = d
+}
+
+func (c *clientState) getMsize() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.msize
+}
+
+func (c *clientState) setMsize(msize uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msize = msize
+}
+
+// fid returns the file bound to fid.
+func (c *clientState) fid(fid uint32) (File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	if !ok {
+		return nil, false
+	}
+	return f.file, true
+}
+
+// handle returns the open handle for fid.
+func (c *clientState) handle(fid uint32) (Handle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	if !ok {
+		return nil, ErrBadFid
+	}
+	if f.handle == nil {
+		return nil, ErrNotOpen
+	}
+	return f.handle, nil
+}
+
+// addFid binds a new fid. It fails if the fid is already in use.
+func (c *clientState) addFid(fid uint32, f File) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.fids[fid]; exists {
+		return ErrFidInUse
+	}
+	if c.maxFids > 0 && len(c.fids) >= c.maxFids {
+		return ErrTooManyFids
+	}
+	c.fids[fid] = &fidState{file: f}
+	return nil
+}
+
+// setFid binds fid, replacing any existing binding.
+func (c *clientState) setFid(fid uint32, f File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fids[fid] = &fidState{file: f}
+}
+
+// setOpen records h as the open handle for fid, which must still be bound
+// to file and not yet open.
+func (c *clientState) setOpen(fid uint32, file File, h Handle) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	if !ok || f.file != file {
+		return ErrBadFid
+	}
+	if f.handle != nil {
+		return ErrAlreadyOpen
+	}
+	f.handle = h
+	return nil
+}
+
+// setCreated rebinds fid from dir to the newly created file, opened as h.
+func (c *clientState) setCreated(fid uint32, dir, file File, h Handle) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	if !ok || f.file != dir {
+		return ErrBadFid
+	}
+	if f.handle != nil {
+		return ErrAlreadyOpen
+	}
+	f.file = file
+	f.handle = h
+	return nil
+}
+
+// isOpen reports whether fid has been opened.
+func (c *clientState) isOpen(fid uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	return ok && f.handle != nil
+}
+
+// removeFid unbinds fid and returns its state.
+func (c *clientState) removeFid(fid uint32) (*fidState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	if ok {
+		delete(c.fids, fid)
+	}
+	return f, ok
+}
+
+func (s *Server) handleMessage(state *clientState, req *request, msgType uint8, payload []byte, buf []byte) ([]byte, uint8) {
+	if state.getDialect() == dialect9P2000L {
+		if resp, respType, ok := s.handleMessageL(state, req, msgType, payload, buf); ok {
+			return resp, respType
+		}
+	}
+
 	switch msgType {
 	case Tversion:
 		return s.handleVersion(state, payload, buf)
+	case Tauth:
+		return s.handleAuth(state, payload, buf)
 	case Tattach:
 		return s.handleAttach(state, payload, buf)
 	case Twalk:
 		return s.handleWalk(state, payload, buf)
 	case Topen:
-		return s.handleOpen(state, payload, buf)
+		return s.handleOpen(state, req, payload, buf)
+	case Tcreate:
+		return s.handleCreate(state, req, payload, buf)
 	case Tread:
-		return s.handleRead(state, payload, buf)
+		return s.handleRead(state, req, payload, buf)
 	case Twrite:
-		return s.handleWrite(state, payload, buf)
+		return s.handleWrite(state, req, payload, buf)
 	case Tclunk:
-		return s.handleClunk(state, payload, buf)
+		return s.handleClunk(state, req, payload, buf)
+	case Tremove:
+		return s.handleRemove(state, req, payload, buf)
 	case Tstat:
 		return s.handleStat(state, payload, buf)
+	case Twstat:
+		return s.handleWstat(state, payload, buf)
 	case Tflush:
-		return s.handleFlush(state, payload, buf)
+		return s.handleFlush(state, req, payload, buf)
 	default:
-		return s.errorResponse(buf, fmt.Sprintf("unknown message type: %d", msgType))
+		return s.errorResponse(state, buf, fmt.Errorf("unknown message type: %d: %w", msgType, ErrNotSupported))
 	}
 }
 
-func (s *Server) errorResponse(buf []byte, msg string) ([]byte, uint8) {
-	resp := &RerrorMsg{Ename: msg}
+// errorResponse encodes err in the negotiated dialect: an error string in
+// Rerror (with an errno for 9P2000.u), or an errno in Rlerror for 9P2000.L.
+func (s *Server) errorResponse(state *clientState, buf []byte, err error) ([]byte, uint8) {
+	if state.getDialect() == dialect9P2000L {
+		resp := &RlerrorMsg{Ecode: ErrnoOf(err)}
+		n := resp.Encode(buf)
+		return buf[:n], Rlerror
+	}
+	resp := &RerrorMsg{Ename: err.Error()}
+	if state.getDialect() == dialect9P2000U {
+		resp.Errno = ErrnoOf(err)
+		n := resp.EncodeU(buf)
+		return buf[:n], Rerror
+	}
 	n := resp.Encode(buf)
 	return buf[:n], Rerror
 }
 
+// stat returns the metadata of f with the server's numeric owner filled in.
+func (s *Server) stat(f File) Stat {
+	st := f.Stat()
+	st.NUid = s.uid
+	st.NGid = s.gid
+	st.NMuid = s.uid
+	return st
+}
+
 func (s *Server) handleVersion(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTversion(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
 	// Negotiate message size
 	msize := msg.Msize
-	if msize > MaxMessageSize {
-		msize = MaxMessageSize
+	if msize < MinMessageSize {
+		return s.errorResponse(state, buf, fmt.Errorf("msize %d too small, need at least %d", msize, MinMessageSize))
+	}
+	if msize > s.maxMsize {
+		msize = s.maxMsize
 	}
-	state.msize = msize
+	state.setMsize(msize)
 
-	// Check version - accept both 9P2000 and Styx (Inferno's name)
+	// Check version - accept both 9P2000 and Styx (Inferno's name), and
+	// the Linux dialect
 	version := msg.Version
-	if msg.Version != Version && msg.Version != "Styx" {
+	switch msg.Version {
+	case Version, "Styx":
+		state.setDialect(dialect9P2000)
+	case VersionU:
+		state.setDialect(dialect9P2000U)
+	case VersionL:
+		state.setDialect(dialect9P2000L)
+	default:
+		// A version such as 9P2000.x is a variant of 9P2000 that the
+		// client can fall back from.
 		version = "unknown"
+		if base, _, _ := strings.Cut(msg.Version, "."); base == Version {
+			version = Version
+		}
+		state.setDialect(dialect9P2000)
 	}
 
+	// Tversion starts a new session, so every fid is clunked. The requests
+	// that used them have finished; as when the client hangs up, their
+	// handles are closed with a cancelled context and commit nothing.
+	ctx, cancel := context.WithCancel(state.ctx)
+	cancel()
+	state.clunkAll(ctx)
+
 	if s.debug {
 		log.Printf("Version negotiation: client=%q responding=%q msize=%d", msg.Version, version, msize)
 	}
@@ -169,16 +764,27 @@ func (s *Server) handleVersion(state *clientState, payload []byte, buf []byte) (
 func (s *Server) handleAttach(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTattach(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
-	if _, exists := state.fids[msg.Fid]; exists {
-		return s.errorResponse(buf, ErrFidInUse.Error())
+	if s.debug {
+		log.Printf("Attach: uname=%q aname=%q n_uname=%d", msg.Uname, msg.Aname, msg.NUname)
 	}
 
-	state.fids[msg.Fid] = s.root
+	if err := s.checkAuth(state, msg.Afid, msg.Uname); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
 
-	resp := &RattachMsg{Qid: s.root.Stat().Qid}
+	root, err := s.attachRoot(msg.Uname, msg.Aname)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	if err := state.addFid(msg.Fid, root); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RattachMsg{Qid: root.Stat().Qid}
 	n := resp.Encode(buf)
 	return buf[:n], Rattach
 }
@@ -186,17 +792,17 @@ func (s *Server) handleAttach(state *clientState, payload []byte, buf []byte) ([
 func (s *Server) handleWalk(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTwalk(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.fid(msg.Fid)
 	if !exists {
-		return s.errorResponse(buf, ErrBadFid.Error())
+		return s.errorResponse(state, buf, ErrBadFid)
 	}
 
 	if msg.Fid != msg.Newfid {
-		if _, exists := state.fids[msg.Newfid]; exists {
-			return s.errorResponse(buf, ErrFidInUse.Error())
+		if _, exists := state.fid(msg.Newfid); exists {
+			return s.errorResponse(state, buf, ErrFidInUse)
 		}
 	}
 
@@ -205,14 +811,18 @@ func (s *Server) handleWalk(state *clientState, payload []byte, buf []byte) ([]b
 	current := file
 
 	for _, name := range msg.Names {
+		var next File
 		dir, ok := current.(Dir)
 		if !ok {
-			return s.errorResponse(buf, ErrNotDir.Error())
+			err = ErrNotDir
+		} else {
+			next, err = dir.Lookup(name)
 		}
-
-		next, err := dir.Lookup(name)
 		if err != nil {
-			// Return partial walk
+			// An error for the first element, a partial walk otherwise
+			if len(qids) == 0 {
+				return s.errorResponse(state, buf, err)
+			}
 			break
 		}
 
@@ -222,7 +832,11 @@ func (s *Server) handleWalk(state *clientState, payload []byte, buf []byte) ([]b
 
 	// Only update fid if we walked at least one element (or no elements requested)
 	if len(qids) == len(msg.Names) {
-		state.fids[msg.Newfid] = current
+		if msg.Fid == msg.Newfid {
+			state.setFid(msg.Newfid, current)
+		} else if err := state.addFid(msg.Newfid, current); err != nil {
+			return s.errorResponse(state, buf, err)
+		}
 	}
 
 	resp := &RwalkMsg{Qids: qids}
@@ -230,19 +844,15 @@ func (s *Server) handleWalk(state *clientState, payload []byte, buf []byte) ([]b
 	return buf[:n], Rwalk
 }
 
-func (s *Server) handleOpen(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+func (s *Server) handleOpen(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTopen(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
-	}
-
-	file, exists := state.fids[msg.Fid]
-	if !exists {
-		return s.errorResponse(buf, ErrBadFid.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
-	if err := file.Open(msg.Mode); err != nil {
-		return s.errorResponse(buf, err.Error())
+	file, err := s.open(state, req, msg.Fid, msg.Mode)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
 	}
 
 	resp := &RopenMsg{
@@ -253,28 +863,146 @@ func (s *Server) handleOpen(state *clientState, payload []byte, buf []byte) ([]b
 	return buf[:n], Ropen
 }
 
-func (s *Server) handleRead(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
-	msg, err := DecodeTread(payload)
+// open opens fid with the given 9P mode and records its handle.
+func (s *Server) open(state *clientState, req *request, fid uint32, mode uint8) (File, error) {
+	file, exists := state.fid(fid)
+	if !exists {
+		return nil, ErrBadFid
+	}
+	if state.isOpen(fid) {
+		return nil, ErrAlreadyOpen
+	}
+
+	if mode&OTRUNC != 0 {
+		if err := s.truncate(file, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	h, err := s.openHandle(state, req, file, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fid may have been clunked or opened while Open ran.
+	if err := state.setOpen(fid, file, h); err != nil {
+		h.Close(req.ctx)
+		return nil, err
+	}
+	return file, nil
+}
+
+// openHandle opens file with the given 9P mode and returns the handle for
+// its fid.
+func (s *Server) openHandle(state *clientState, req *request, file File, mode uint8) (Handle, error) {
+	h, err := file.Open(req.ctx, mode)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		if dir, ok := file.(Dir); ok {
+			h = newDirHandle(dir, s.stat, state.getDialect() == dialect9P2000U)
+		} else {
+			h = NewFileHandle(file)
+		}
+	}
+	return h, nil
+}
+
+func (s *Server) handleCreate(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTcreate(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, err := s.create(state, req, msg.Fid, msg.Name, msg.Perm, msg.Mode)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RcreateMsg{
+		Qid:    file.Stat().Qid,
+		Iounit: 0,
+	}
+	n := resp.Encode(buf)
+	return buf[:n], Rcreate
+}
+
+// create makes name in the directory fid and opens it with mode. On
+// success fid refers to the new file.
+func (s *Server) create(state *clientState, req *request, fid uint32, name string, perm uint32, mode uint8) (File, error) {
+	if state.isOpen(fid) {
+		return nil, ErrAlreadyOpen
+	}
+	dir, file, err := s.createChild(state, fid, name, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := s.openHandle(state, req, file, mode)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return nil, err
+	}
+	if err := state.setCreated(fid, dir, file, h); err != nil {
+		h.Close(req.ctx)
+		return nil, err
 	}
+	return file, nil
+}
 
-	file, exists := state.fids[msg.Fid]
+// createChild asks the directory bound to fid to create name, returning
+// the directory and the new file.
+func (s *Server) createChild(state *clientState, fid uint32, name string, perm uint32) (File, File, error) {
+	file, exists := state.fid(fid)
 	if !exists {
-		return s.errorResponse(buf, ErrBadFid.Error())
+		return nil, nil, ErrBadFid
+	}
+	dir, ok := file.(Dir)
+	if !ok {
+		return nil, nil, ErrNotDir
+	}
+	if !validName(name) {
+		return nil, nil, ErrBadName
+	}
+
+	child, err := dir.Create(name, perm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.debug {
+		log.Printf("Create: %q perm=%#o", name, perm)
+	}
+	return file, child, nil
+}
+
+// validName reports whether name may be used for a new file.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+func (s *Server) handleRead(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTread(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	h, err := state.handle(msg.Fid)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
 	}
 
 	// Limit read size to available buffer
 	count := msg.Count
-	maxData := state.msize - 4 - 1 - 2 - 4 // size, type, tag, count
+	maxData := state.getMsize() - 4 - 1 - 2 - 4 // size, type, tag, count
 	if count > maxData {
 		count = maxData
 	}
 
-	data := make([]byte, count)
-	n, err := file.Read(data, int64(msg.Offset))
+	// Read straight into the reply buffer, which is msize bytes.
+	data := buf[4 : 4+count]
+	n, err := h.Read(req.ctx, data, int64(msg.Offset))
 	if err != nil && err != io.EOF {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
 	resp := &RreadMsg{Data: data[:n]}
@@ -282,20 +1010,20 @@ func (s *Server) handleRead(state *clientState, payload []byte, buf []byte) ([]b
 	return buf[:rn], Rread
 }
 
-func (s *Server) handleWrite(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+func (s *Server) handleWrite(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTwrite(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
-	file, exists := state.fids[msg.Fid]
-	if !exists {
-		return s.errorResponse(buf, ErrBadFid.Error())
+	h, err := state.handle(msg.Fid)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
 	}
 
-	n, err := file.Write(msg.Data, int64(msg.Offset))
+	n, err := h.Write(req.ctx, msg.Data, int64(msg.Offset))
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
 	resp := &RwriteMsg{Count: uint32(n)}
@@ -303,43 +1031,197 @@ func (s *Server) handleWrite(state *clientState, payload []byte, buf []byte) ([]
 	return buf[:rn], Rwrite
 }
 
-func (s *Server) handleClunk(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+func (s *Server) handleClunk(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTclunk(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
-	file, exists := state.fids[msg.Fid]
+	f, exists := state.removeFid(msg.Fid)
 	if !exists {
-		return s.errorResponse(buf, ErrBadFid.Error())
+		return s.errorResponse(state, buf, ErrBadFid)
 	}
 
-	file.Close()
-	delete(state.fids, msg.Fid)
+	// The fid is gone whether or not closing succeeds, but an error
+	// (such as a failed commit) is still reported to the client.
+	if f.handle != nil {
+		if err := f.handle.Close(req.ctx); err != nil {
+			return s.errorResponse(state, buf, err)
+		}
+	}
 
 	resp := &RclunkMsg{}
 	n := resp.Encode(buf)
 	return buf[:n], Rclunk
 }
 
+func (s *Server) handleRemove(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTremove(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	// Like Tclunk, Tremove releases the fid even if the remove fails.
+	f, exists := state.removeFid(msg.Fid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+	if f.handle != nil {
+		if err := f.handle.Close(req.ctx); err != nil {
+			return s.errorResponse(state, buf, err)
+		}
+	}
+
+	if err := s.remove(f.file); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RremoveMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rremove
+}
+
+// remove removes file, if it can be removed.
+func (s *Server) remove(file File) error {
+	r, ok := file.(Remover)
+	if !ok {
+		return ErrPermission
+	}
+	if s.debug {
+		log.Printf("Remove: %q", file.Stat().Name)
+	}
+	return r.Remove()
+}
+
 func (s *Server) handleStat(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTstat(payload)
 	if err != nil {
-		return s.errorResponse(buf, err.Error())
+		return s.errorResponse(state, buf, err)
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.fid(msg.Fid)
 	if !exists {
-		return s.errorResponse(buf, ErrBadFid.Error())
+		return s.errorResponse(state, buf, ErrBadFid)
 	}
 
-	resp := &RstatMsg{Stat: file.Stat()}
+	resp := &RstatMsg{Stat: s.stat(file)}
+	if state.getDialect() == dialect9P2000U {
+		n := resp.EncodeU(buf)
+		return buf[:n], Rstat
+	}
 	n := resp.Encode(buf)
 	return buf[:n], Rstat
 }
 
-func (s *Server) handleFlush(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
-	// We don't have async operations to cancel, so just respond OK
+func (s *Server) handleWstat(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	var msg *TwstatMsg
+	var err error
+	if state.getDialect() == dialect9P2000U {
+		msg, err = DecodeTwstatU(payload)
+	} else {
+		msg, err = DecodeTwstat(payload)
+	}
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, exists := state.fid(msg.Fid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+
+	if err := s.wstat(file, msg.Stat); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RwstatMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rwstat
+}
+
+// wstat applies the changes requested by st to file, following the 9P
+// rules: fields holding their "don't touch" values are ignored, the
+// length and name may change if the file supports it, times are accepted
+// but not recorded, and anything else is refused. Every change is checked
+// before any is made.
+func (s *Server) wstat(file File, st Stat) error {
+	cur := s.stat(file)
+	null := NullStat()
+
+	if changes(st.Type, null.Type, cur.Type) ||
+		changes(st.Dev, null.Dev, cur.Dev) ||
+		changes(st.Qid.Type, null.Qid.Type, cur.Qid.Type) ||
+		changes(st.Qid.Version, null.Qid.Version, cur.Qid.Version) ||
+		changes(st.Qid.Path, null.Qid.Path, cur.Qid.Path) ||
+		changes(st.Mode, null.Mode, cur.Mode) ||
+		changes(st.Uid, "", cur.Uid) ||
+		changes(st.Gid, "", cur.Gid) ||
+		changes(st.Muid, "", cur.Muid) ||
+		changes(st.NUid, null.NUid, cur.NUid) ||
+		changes(st.NGid, null.NGid, cur.NGid) {
+		return ErrPermission
+	}
+
+	rename := changes(st.Name, "", cur.Name)
+	if rename {
+		if !validName(st.Name) {
+			return ErrBadName
+		}
+		if _, ok := file.(Renamer); !ok {
+			return ErrPermission
+		}
+	}
+	truncate := changes(st.Length, null.Length, cur.Length)
+	if truncate {
+		if _, ok := file.(Truncater); !ok {
+			return ErrPermission
+		}
+	}
+
+	if truncate {
+		if err := s.truncate(file, st.Length); err != nil {
+			return err
+		}
+	}
+	if rename {
+		return file.(Renamer).Rename(st.Name)
+	}
+	return nil
+}
+
+// changes reports whether the wstat field v asks to change the current
+// value cur, rather than holding the "don't touch" value null.
+func changes[T comparable](v, null, cur T) bool {
+	return v != null && v != cur
+}
+
+// truncate sets the length of file. Files that are not Truncaters accept
+// only their current length.
+func (s *Server) truncate(file File, length uint64) error {
+	if t, ok := file.(Truncater); ok {
+		return t.Truncate(length)
+	}
+	if length == file.Stat().Length {
+		return nil
+	}
+	return ErrPermission
+}
+
+func (s *Server) handleFlush(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTflush(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	// Cancel the flushed request if it is still in flight. Rflush must not
+	// be sent before its reply, so wait for it to finish unwinding.
+	if msg.Oldtag != req.tag {
+		if old := state.pending(msg.Oldtag); old != nil {
+			old.cancel()
+			<-old.done
+		}
+	}
+
 	resp := &RflushMsg{}
 	n := resp.Encode(buf)
 	return buf[:n], Rflush
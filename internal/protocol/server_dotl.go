@@ -0,0 +1,322 @@
+package protocol
+
+// 9P2000.L request handlers. These map the Linux-flavoured messages onto
+// the same File and Dir interfaces as the base protocol.
+
+import "errors"
+
+// handleMessageL handles the messages specific to 9P2000.L. It reports
+// false for messages shared with the base protocol.
+func (s *Server) handleMessageL(state *clientState, req *request, msgType uint8, payload []byte, buf []byte) ([]byte, uint8, bool) {
+	var resp []byte
+	var respType uint8
+	switch msgType {
+	case Tlopen:
+		resp, respType = s.handleLopen(state, req, payload, buf)
+	case Tlcreate:
+		resp, respType = s.handleLcreate(state, req, payload, buf)
+	case Tmkdir:
+		resp, respType = s.handleMkdir(state, payload, buf)
+	case Tunlinkat:
+		resp, respType = s.handleUnlinkat(state, payload, buf)
+	case Trenameat:
+		resp, respType = s.handleRenameat(state, payload, buf)
+	case Tgetattr:
+		resp, respType = s.handleGetattr(state, payload, buf)
+	case Tsetattr:
+		resp, respType = s.handleSetattr(state, payload, buf)
+	case Treaddir:
+		resp, respType = s.handleReaddir(state, payload, buf)
+	case Tstatfs:
+		resp, respType = s.handleStatfs(state, payload, buf)
+	case Tfsync:
+		resp, respType = s.handleFsync(state, req, payload, buf)
+	case Txattrwalk:
+		// Extended attributes are not supported; the kernel treats
+		// this as "no xattrs" rather than a failure.
+		resp, respType = s.errorResponse(state, buf, ErrNotSupported)
+	default:
+		return nil, 0, false
+	}
+	return resp, respType, true
+}
+
+func (s *Server) handleLopen(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTlopen(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, err := s.open(state, req, msg.Fid, msg.Mode())
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RlopenMsg{
+		Qid:    file.Stat().Qid,
+		Iounit: 0, // 0 means use msize - overhead
+	}
+	n := resp.Encode(buf)
+	return buf[:n], Rlopen
+}
+
+func (s *Server) handleLcreate(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTlcreate(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, err := s.create(state, req, msg.Fid, msg.Name, msg.Mode&0777, lflagsToMode(msg.Flags))
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RlcreateMsg{
+		Qid:    file.Stat().Qid,
+		Iounit: 0,
+	}
+	n := resp.Encode(buf)
+	return buf[:n], Rlcreate
+}
+
+func (s *Server) handleMkdir(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTmkdir(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	// Unlike Tcreate, the directory fid is left as it was.
+	_, file, err := s.createChild(state, msg.Dfid, msg.Name, DMDIR|msg.Mode&0777)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RmkdirMsg{Qid: file.Stat().Qid}
+	n := resp.Encode(buf)
+	return buf[:n], Rmkdir
+}
+
+func (s *Server) handleUnlinkat(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTunlinkat(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, exists := state.fid(msg.Dfid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+	dir, ok := file.(Dir)
+	if !ok {
+		return s.errorResponse(state, buf, ErrNotDir)
+	}
+	child, err := dir.Lookup(msg.Name)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	// unlink(2) and rmdir(2) each refuse the other kind of file.
+	isDir := child.Stat().Mode&DMDIR != 0
+	if removeDir := msg.Flags&ATRemoveDir != 0; isDir != removeDir {
+		if isDir {
+			return s.errorResponse(state, buf, ErrIsDir)
+		}
+		return s.errorResponse(state, buf, ErrNotDir)
+	}
+
+	if err := s.remove(child); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RunlinkatMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Runlinkat
+}
+
+func (s *Server) handleRenameat(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTrenameat(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	olddir, exists := state.fid(msg.OldDirfid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+	newdir, exists := state.fid(msg.NewDirfid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+	dir, ok := olddir.(Dir)
+	if !ok {
+		return s.errorResponse(state, buf, ErrNotDir)
+	}
+
+	// As with Twstat, files can only be renamed within their directory.
+	if newdir.Stat().Qid.Path != olddir.Stat().Qid.Path {
+		return s.errorResponse(state, buf, ErrPermission)
+	}
+	child, err := dir.Lookup(msg.OldName)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+	st := NullStat()
+	st.Name = msg.NewName
+	if err := s.wstat(child, st); err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RrenameatMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rrenameat
+}
+
+func (s *Server) handleGetattr(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTgetattr(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, exists := state.fid(msg.Fid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+
+	resp := statToAttr(s.stat(file))
+	n := resp.Encode(buf)
+	return buf[:n], Rgetattr
+}
+
+// statToAttr converts a 9P stat to 9P2000.L attributes.
+func statToAttr(stat Stat) *RgetattrMsg {
+	mode := stat.Mode & 0777
+	nlink := uint64(1)
+	if stat.Mode&DMDIR != 0 {
+		mode |= SIFDIR
+		nlink = 2
+	} else {
+		mode |= SIFREG
+	}
+
+	return &RgetattrMsg{
+		Valid:    GetattrBasic,
+		Qid:      stat.Qid,
+		Mode:     mode,
+		UID:      stat.NUid,
+		GID:      stat.NGid,
+		Nlink:    nlink,
+		Size:     stat.Length,
+		Blksize:  4096,
+		Blocks:   (stat.Length + 511) / 512,
+		AtimeSec: uint64(stat.Atime),
+		MtimeSec: uint64(stat.Mtime),
+		CtimeSec: uint64(stat.Mtime),
+	}
+}
+
+func (s *Server) handleSetattr(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTsetattr(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	file, exists := state.fid(msg.Fid)
+	if !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+
+	// Ownership and permissions are fixed. Times are accepted but not
+	// recorded, so that touch succeeds; the size follows the same rules
+	// as a Twstat of the length.
+	if msg.Valid&(SetattrMode|SetattrUID|SetattrGID) != 0 {
+		return s.errorResponse(state, buf, ErrPermission)
+	}
+	if msg.Valid&SetattrSize != 0 {
+		if err := s.truncate(file, msg.Size); err != nil {
+			return s.errorResponse(state, buf, err)
+		}
+	}
+
+	resp := &RsetattrMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rsetattr
+}
+
+func (s *Server) handleReaddir(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTreaddir(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	h, err := state.handle(msg.Fid)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+	dh, ok := h.(*dirHandle)
+	if !ok {
+		return s.errorResponse(state, buf, ErrNotDir)
+	}
+
+	count := msg.Count
+	maxData := state.getMsize() - 4 - 1 - 2 - 4 // size, type, tag, count
+	if count > maxData {
+		count = maxData
+	}
+
+	// Entries come from the snapshot taken when the directory was opened.
+	data := buf[4 : 4+count]
+	n, err := dh.readdir(data, msg.Offset)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	resp := &RreaddirMsg{Data: data[:n]}
+	rn := resp.Encode(buf)
+	return buf[:rn], Rreaddir
+}
+
+func (s *Server) handleStatfs(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTstatfs(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	if _, exists := state.fid(msg.Fid); !exists {
+		return s.errorResponse(state, buf, ErrBadFid)
+	}
+
+	resp := &RstatfsMsg{
+		FsType:  V9FSMagic,
+		Bsize:   4096,
+		Namelen: 255,
+	}
+	n := resp.Encode(buf)
+	return buf[:n], Rstatfs
+}
+
+func (s *Server) handleFsync(state *clientState, req *request, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTfsync(payload)
+	if err != nil {
+		return s.errorResponse(state, buf, err)
+	}
+
+	// Most writes take effect when they are made. Handles that buffer
+	// them until the clunk, such as ask's, commit them now; a fid that
+	// is not open has nothing to commit.
+	h, err := state.handle(msg.Fid)
+	switch {
+	case errors.Is(err, ErrNotOpen):
+	case err != nil:
+		return s.errorResponse(state, buf, err)
+	default:
+		if sy, ok := h.(Syncer); ok {
+			if err := sy.Sync(req.ctx); err != nil {
+				return s.errorResponse(state, buf, err)
+			}
+		}
+	}
+
+	resp := &RfsyncMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rfsync
+}
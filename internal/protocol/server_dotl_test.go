@@ -0,0 +1,248 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func setupDotLTest(t *testing.T) *testConn {
+	root := NewStaticDir("root")
+	root.AddChild(NewStaticFile("file", []byte("hello")))
+	sub := NewStaticDir("sub")
+	root.AddChild(sub)
+
+	c := newTestConn(t, NewServer(root))
+	payload := c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	if version, _ := DecodeString(payload[4:]); version != VersionL {
+		t.Fatalf("Rversion = %q, want %q", version, VersionL)
+	}
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	return c
+}
+
+func TestDotL_LopenRead(t *testing.T) {
+	c := setupDotLTest(t)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"file"}}, Rwalk)
+	c.rpc(1, &TlopenMsg{Fid: 1, Flags: 0}, Rlopen)
+
+	payload := c.rpc(1, &TreadMsg{Fid: 1, Count: 100}, Rread)
+	if got := string(payload[4:]); got != "hello" {
+		t.Errorf("Rread = %q, want %q", got, "hello")
+	}
+}
+
+func TestDotL_Getattr(t *testing.T) {
+	c := setupDotLTest(t)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"file"}}, Rwalk)
+
+	payload := c.rpc(1, &TgetattrMsg{Fid: 1, RequestMask: GetattrBasic}, Rgetattr)
+	mode := binary.LittleEndian.Uint32(payload[21:25])
+	size := binary.LittleEndian.Uint64(payload[49:57])
+	if mode != SIFREG|0444 {
+		t.Errorf("mode = %o, want %o", mode, SIFREG|0444)
+	}
+	if size != 5 {
+		t.Errorf("size = %d, want 5", size)
+	}
+
+	payload = c.rpc(1, &TgetattrMsg{Fid: 0, RequestMask: GetattrBasic}, Rgetattr)
+	if mode := binary.LittleEndian.Uint32(payload[21:25]); mode&SIFDIR == 0 {
+		t.Errorf("root mode = %o, want a directory", mode)
+	}
+}
+
+func TestDotL_Readdir(t *testing.T) {
+	c := setupDotLTest(t)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1}, Rwalk)
+	c.rpc(1, &TlopenMsg{Fid: 1, Flags: 0}, Rlopen)
+
+	var ents []Dirent
+	var offset uint64
+	for {
+		payload := c.rpc(1, &TreaddirMsg{Fid: 1, Offset: offset, Count: 30}, Rreaddir)
+		data := payload[4:]
+		if len(data) == 0 {
+			break
+		}
+		for len(data) > 0 {
+			d, n := DecodeDirent(data)
+			if n == 0 {
+				t.Fatalf("partial dirent in Rreaddir: %x", data)
+			}
+			ents = append(ents, d)
+			offset = d.Offset
+			data = data[n:]
+		}
+	}
+
+	if len(ents) != 2 {
+		t.Fatalf("got %d entries, want 2", len(ents))
+	}
+	if ents[0].Name != "file" || ents[0].Type != DTREG {
+		t.Errorf("entry 0 = %q type %d, want file DTREG", ents[0].Name, ents[0].Type)
+	}
+	if ents[1].Name != "sub" || ents[1].Type != DTDIR {
+		t.Errorf("entry 1 = %q type %d, want sub DTDIR", ents[1].Name, ents[1].Type)
+	}
+}
+
+func TestDotL_Errors(t *testing.T) {
+	c := setupDotLTest(t)
+
+	tests := []struct {
+		name  string
+		msg   Message
+		errno Errno
+	}{
+		{"bad fid", &TlopenMsg{Fid: 99}, EBADF},
+		{"xattrwalk", &TxattrwalkMsg{Fid: 0, Newfid: 2, Name: "user.foo"}, EOPNOTSUPP},
+		{"readdir unopened", &TreaddirMsg{Fid: 0, Count: 100}, EBADF},
+		{"chmod", &TsetattrMsg{Fid: 0, Valid: SetattrMode, Mode: 0777}, EACCES},
+	}
+	for _, tt := range tests {
+		payload := c.rpc(1, tt.msg, Rlerror)
+		if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != tt.errno {
+			t.Errorf("%s: errno = %d, want %d", tt.name, errno, tt.errno)
+		}
+	}
+}
+
+func TestDotL_SetattrTruncate(t *testing.T) {
+	c := setupDotLTest(t)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"file"}}, Rwalk)
+	c.rpc(1, &TsetattrMsg{Fid: 1, Valid: SetattrSize, Size: 5}, Rsetattr)
+	c.rpc(1, &TsetattrMsg{Fid: 1, Valid: SetattrMtime | SetattrAtime}, Rsetattr)
+	c.rpc(1, &TstatfsMsg{Fid: 1}, Rstatfs)
+	c.rpc(1, &TfsyncMsg{Fid: 1}, Rfsync)
+
+	// A static file cannot be truncated.
+	payload := c.rpc(1, &TsetattrMsg{Fid: 1, Valid: SetattrSize}, Rlerror)
+	if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != EACCES {
+		t.Errorf("truncate static file: errno = %d, want EACCES", errno)
+	}
+}
+
+// bufferedFile buffers each fid's writes until Sync or Close
+type bufferedFile struct {
+	*memFile
+}
+
+func (f *bufferedFile) Open(ctx context.Context, mode uint8) (Handle, error) {
+	return &bufferedHandle{Handle: NewFileHandle(f.memFile), file: f.memFile}, nil
+}
+
+type bufferedHandle struct {
+	Handle
+	file *memFile
+	buf  []byte
+}
+
+func (h *bufferedHandle) Write(ctx context.Context, p []byte, offset int64) (int, error) {
+	h.buf = append(h.buf, p...)
+	return len(p), nil
+}
+
+func (h *bufferedHandle) Sync(ctx context.Context) error {
+	h.file.content = string(h.buf)
+	return nil
+}
+
+func TestDotL_FsyncCommitsBufferedWrites(t *testing.T) {
+	f := &bufferedFile{newMemFile("notes", "")}
+	root := NewStaticDir("root")
+	root.AddChild(f)
+	c := newTestConn(t, NewServer(root))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"notes"}}, Rwalk)
+
+	// Before the fid is opened there is nothing to commit.
+	c.rpc(1, &TfsyncMsg{Fid: 1}, Rfsync)
+
+	c.rpc(1, &TlopenMsg{Fid: 1, Flags: 1}, Rlopen)
+	c.rpc(1, &TwriteMsg{Fid: 1, Data: []byte("buffered")}, Rwrite)
+	if f.content != "" {
+		t.Fatalf("content before fsync = %q, want empty", f.content)
+	}
+	c.rpc(1, &TfsyncMsg{Fid: 1}, Rfsync)
+	if f.content != "buffered" {
+		t.Errorf("content after fsync = %q, want %q", f.content, "buffered")
+	}
+
+	payload := c.rpc(1, &TfsyncMsg{Fid: 99}, Rlerror)
+	if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != EBADF {
+		t.Errorf("fsync unknown fid: errno = %d, want EBADF", errno)
+	}
+}
+
+func TestDotL_SetattrTruncateWritable(t *testing.T) {
+	f := newMemFile("notes", "some notes")
+	root := NewStaticDir("root")
+	root.AddChild(f)
+	c := newTestConn(t, NewServer(root))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"notes"}}, Rwalk)
+
+	c.rpc(1, &TsetattrMsg{Fid: 1, Valid: SetattrSize}, Rsetattr)
+	if f.content != "" {
+		t.Errorf("content after truncate = %q, want empty", f.content)
+	}
+}
+
+func TestDotL_MkdirAndLcreate(t *testing.T) {
+	c := newTestConn(t, NewServer(&mkdirDir{NewStaticDir("root")}))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+
+	payload := c.rpc(1, &TmkdirMsg{Dfid: 0, Name: "sub", Mode: 0755}, Rmkdir)
+	if qid, _ := DecodeQid(payload); qid.Type != QTDIR {
+		t.Errorf("Rmkdir qid type = %#x, want QTDIR", qid.Type)
+	}
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"sub"}}, Rwalk)
+
+	payload = c.rpc(1, &TmkdirMsg{Dfid: 0, Name: "sub", Mode: 0755}, Rlerror)
+	if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != EEXIST {
+		t.Errorf("mkdir existing: errno = %d, want EEXIST", errno)
+	}
+
+	// Only directories can be created here.
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2}, Rwalk)
+	payload = c.rpc(1, &TlcreateMsg{Fid: 2, Name: "file", Flags: LOWRONLY, Mode: 0644}, Rlerror)
+	if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != EACCES {
+		t.Errorf("lcreate: errno = %d, want EACCES", errno)
+	}
+}
+
+func TestDotL_Unlinkat(t *testing.T) {
+	f := &removableFile{StaticFile: NewStaticFile("scratch", nil)}
+	root := NewStaticDir("root")
+	root.AddChild(f)
+	root.AddChild(NewStaticDir("sub"))
+	c := newTestConn(t, NewServer(root))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+
+	tests := []struct {
+		name  string
+		msg   *TunlinkatMsg
+		errno Errno
+	}{
+		{"rmdir of a file", &TunlinkatMsg{Dfid: 0, Name: "scratch", Flags: ATRemoveDir}, ENOTDIR},
+		{"unlink of a dir", &TunlinkatMsg{Dfid: 0, Name: "sub"}, EISDIR},
+		{"not removable", &TunlinkatMsg{Dfid: 0, Name: "sub", Flags: ATRemoveDir}, EACCES},
+		{"missing", &TunlinkatMsg{Dfid: 0, Name: "nope"}, ENOENT},
+	}
+	for _, tt := range tests {
+		payload := c.rpc(1, tt.msg, Rlerror)
+		if errno := Errno(binary.LittleEndian.Uint32(payload)); errno != tt.errno {
+			t.Errorf("%s: errno = %d, want %d", tt.name, errno, tt.errno)
+		}
+	}
+
+	c.rpc(1, &TunlinkatMsg{Dfid: 0, Name: "scratch"}, Runlinkat)
+	if !f.removed {
+		t.Error("Remove was not called")
+	}
+}
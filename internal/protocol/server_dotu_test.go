@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// dotuAttach encodes a Tattach with the 9P2000.u n_uname field
+type dotuAttach struct{ TattachMsg }
+
+func (m *dotuAttach) Encode(buf []byte) int { return m.EncodeU(buf) }
+
+func setupDotUTest(t *testing.T) *testConn {
+	root := NewStaticDir("root")
+	root.AddChild(NewStaticFile("file", []byte("hello")))
+
+	srv := NewServer(root)
+	srv.SetOwner(1000, 100)
+	c := newTestConn(t, srv)
+	payload := c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionU}, Rversion)
+	if version, _ := DecodeString(payload[4:]); version != VersionU {
+		t.Fatalf("Rversion = %q, want %q", version, VersionU)
+	}
+	c.rpc(1, &dotuAttach{TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda", NUname: 1000}}, Rattach)
+	return c
+}
+
+func TestDotU_Stat(t *testing.T) {
+	c := setupDotUTest(t)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"file"}}, Rwalk)
+
+	payload := c.rpc(1, &TstatMsg{Fid: 1}, Rstat)
+	stat, n := DecodeStatU(payload[2:])
+	if n == 0 {
+		t.Fatalf("Rstat does not hold a 9P2000.u stat: %x", payload)
+	}
+	if stat.Name != "file" || stat.NUid != 1000 || stat.NGid != 100 {
+		t.Errorf("stat = %q uid %d gid %d, want file uid 1000 gid 100", stat.Name, stat.NUid, stat.NGid)
+	}
+}
+
+func TestDotU_DirRead(t *testing.T) {
+	c := setupDotUTest(t)
+	c.rpc(1, &TopenMsg{Fid: 0, Mode: OREAD}, Ropen)
+
+	payload := c.rpc(1, &TreadMsg{Fid: 0, Count: 1000}, Rread)
+	stat, n := DecodeStatU(payload[4:])
+	if n == 0 || n != len(payload)-4 {
+		t.Fatalf("Rread does not hold one 9P2000.u stat: %x", payload)
+	}
+	if stat.Name != "file" || stat.NUid != 1000 {
+		t.Errorf("entry = %q uid %d, want file uid 1000", stat.Name, stat.NUid)
+	}
+}
+
+func TestDotU_RerrorErrno(t *testing.T) {
+	c := setupDotUTest(t)
+
+	payload := c.rpc(1, &TreadMsg{Fid: 42, Count: 100}, Rerror)
+	ename, n := DecodeString(payload)
+	if ename != ErrBadFid.Error() {
+		t.Errorf("ename = %q, want %q", ename, ErrBadFid.Error())
+	}
+	if errno := Errno(binary.LittleEndian.Uint32(payload[n:])); errno != EBADF {
+		t.Errorf("errno = %d, want EBADF", errno)
+	}
+}
+
+func TestErrnoOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Errno
+	}{
+		{ErrNotFound, ENOENT},
+		{ErrPermission, EACCES},
+		{Error("something odd"), EIO},
+		{Errorf(EINVAL, "bad value %d", 7), EINVAL},
+		{fmt.Errorf("wrapped: %w", ErrNotDir), ENOTDIR},
+		{EPERM, EPERM},
+		{fmt.Errorf("plain"), EIO},
+	}
+	for _, tt := range tests {
+		if got := ErrnoOf(tt.err); got != tt.want {
+			t.Errorf("ErrnoOf(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
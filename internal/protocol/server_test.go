@@ -0,0 +1,958 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingFile blocks every Write until release is closed
+type blockingFile struct {
+	*BaseFile
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingFile(name string) *blockingFile {
+	return &blockingFile{
+		BaseFile: NewBaseFile(name, 0666),
+		started:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+}
+
+func (f *blockingFile) Write(p []byte, offset int64) (int, error) {
+	f.started <- struct{}{}
+	<-f.release
+	return len(p), nil
+}
+
+// cancellableFile blocks every write until its context is cancelled
+type cancellableFile struct {
+	*BaseFile
+	started chan struct{}
+}
+
+func (f *cancellableFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	f.started <- struct{}{}
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+// testConn is the client side of a net.Pipe served by a Server
+type testConn struct {
+	t   *testing.T
+	enc *Encoder
+	dec *Decoder
+}
+
+func newTestConn(t *testing.T, srv *Server) *testConn {
+	t.Helper()
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+	t.Cleanup(func() { client.Close() })
+	return &testConn{t: t, enc: NewEncoder(client), dec: NewDecoder(client)}
+}
+
+func (c *testConn) send(tag uint16, msg Message) {
+	c.t.Helper()
+	buf := make([]byte, MaxMessageSize)
+	n := msg.Encode(buf)
+	if err := c.enc.WriteMessage(msg.Type(), tag, buf[:n]); err != nil {
+		c.t.Fatalf("send %s: %v", MessageName(msg.Type()), err)
+	}
+}
+
+func (c *testConn) recv() (uint8, uint16, []byte) {
+	c.t.Helper()
+	msgType, tag, payload, err := c.dec.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("recv: %v", err)
+	}
+	return msgType, tag, append([]byte(nil), payload...)
+}
+
+// rpc sends msg and expects a reply of type want on the same tag
+func (c *testConn) rpc(tag uint16, msg Message, want uint8) []byte {
+	c.t.Helper()
+	c.send(tag, msg)
+	msgType, rtag, payload := c.recv()
+	if rtag != tag {
+		c.t.Fatalf("%s: reply tag = %d, want %d", MessageName(msg.Type()), rtag, tag)
+	}
+	if msgType != want {
+		ename, _ := DecodeString(payload)
+		c.t.Fatalf("%s: reply = %s (%q), want %s", MessageName(msg.Type()), MessageName(msgType), ename, MessageName(want))
+	}
+	return payload
+}
+
+func setupConcurrencyTest(t *testing.T) (*testConn, *blockingFile) {
+	slow := newBlockingFile("slow")
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+	root.AddChild(NewStaticFile("fast", []byte("hello")))
+
+	c := newTestConn(t, NewServer(root))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"fast"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+	c.rpc(1, &TopenMsg{Fid: 2, Mode: OREAD}, Ropen)
+	return c, slow
+}
+
+func waitStarted(t *testing.T, f *blockingFile) {
+	t.Helper()
+	select {
+	case <-f.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocking write never started")
+	}
+}
+
+func TestServer_SlowWriteDoesNotBlockOtherFids(t *testing.T) {
+	c, slow := setupConcurrencyTest(t)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	waitStarted(t, slow)
+
+	payload := c.rpc(11, &TreadMsg{Fid: 2, Count: 100}, Rread)
+	if got := string(payload[4:]); got != "hello" {
+		t.Errorf("Rread data = %q, want %q", got, "hello")
+	}
+
+	close(slow.release)
+	msgType, tag, _ := c.recv()
+	if msgType != Rwrite || tag != 10 {
+		t.Errorf("got %s tag=%d, want Rwrite tag=10", MessageName(msgType), tag)
+	}
+}
+
+func TestServer_FlushRepliesAfterOldTag(t *testing.T) {
+	c, slow := setupConcurrencyTest(t)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	waitStarted(t, slow)
+	c.send(12, &TflushMsg{Oldtag: 10})
+
+	close(slow.release)
+
+	msgType, tag, _ := c.recv()
+	if msgType != Rwrite || tag != 10 {
+		t.Fatalf("first reply = %s tag=%d, want Rwrite tag=10", MessageName(msgType), tag)
+	}
+	msgType, tag, _ = c.recv()
+	if msgType != Rflush || tag != 12 {
+		t.Fatalf("second reply = %s tag=%d, want Rflush tag=12", MessageName(msgType), tag)
+	}
+}
+
+func TestServer_DuplicateTag(t *testing.T) {
+	c, slow := setupConcurrencyTest(t)
+	defer close(slow.release)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	waitStarted(t, slow)
+
+	payload := c.rpc(10, &TstatMsg{Fid: 2}, Rerror)
+	if ename, _ := DecodeString(payload); ename != ErrTagInUse.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, ErrTagInUse.Error())
+	}
+}
+
+func TestServer_MaxOutstanding(t *testing.T) {
+	slow := newBlockingFile("slow")
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+	root.AddChild(NewStaticFile("fast", []byte("hello")))
+
+	srv := NewServer(root)
+	srv.SetMaxOutstanding(1)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"fast"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	waitStarted(t, slow)
+	c.send(11, &TstatMsg{Fid: 2})
+
+	// With one slot taken, the Tstat is held back until the write finishes.
+	close(slow.release)
+	msgType, tag, _ := c.recv()
+	if msgType != Rwrite || tag != 10 {
+		t.Fatalf("first reply = %s tag=%d, want Rwrite tag=10", MessageName(msgType), tag)
+	}
+	msgType, tag, _ = c.recv()
+	if msgType != Rstat || tag != 11 {
+		t.Fatalf("second reply = %s tag=%d, want Rstat tag=11", MessageName(msgType), tag)
+	}
+}
+
+func TestServer_FlushWithSlotsFull(t *testing.T) {
+	slow := &cancellableFile{BaseFile: NewBaseFile("slow", 0666), started: make(chan struct{}, 1)}
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+
+	srv := NewServer(root)
+	srv.SetMaxOutstanding(1)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+
+	// The only slot is taken by a write that blocks until flushed, and a
+	// Tstat waits behind it.
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write never started")
+	}
+	c.send(11, &TstatMsg{Fid: 0})
+
+	// Flushes are still read, both of the waiting request and of the
+	// running one.
+	for _, tc := range []struct{ old, flush uint16 }{{11, 12}, {10, 13}} {
+		c.send(tc.flush, &TflushMsg{Oldtag: tc.old})
+		msgType, tag, _ := c.recv()
+		if msgType != Rerror || tag != tc.old {
+			t.Fatalf("first reply = %s tag=%d, want Rerror tag=%d", MessageName(msgType), tag, tc.old)
+		}
+		msgType, tag, _ = c.recv()
+		if msgType != Rflush || tag != tc.flush {
+			t.Fatalf("second reply = %s tag=%d, want Rflush tag=%d", MessageName(msgType), tag, tc.flush)
+		}
+	}
+
+	// The freed slot serves the next request.
+	c.rpc(14, &TstatMsg{Fid: 0}, Rstat)
+}
+
+func TestServer_FlushCancelsContextWriter(t *testing.T) {
+	slow := &cancellableFile{BaseFile: NewBaseFile("slow", 0666), started: make(chan struct{}, 1)}
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+
+	c := newTestConn(t, NewServer(root))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write never started")
+	}
+	c.send(11, &TflushMsg{Oldtag: 10})
+
+	msgType, tag, payload := c.recv()
+	if msgType != Rerror || tag != 10 {
+		t.Fatalf("first reply = %s tag=%d, want Rerror tag=10", MessageName(msgType), tag)
+	}
+	if ename, _ := DecodeString(payload); ename != context.Canceled.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, context.Canceled.Error())
+	}
+	msgType, tag, _ = c.recv()
+	if msgType != Rflush || tag != 11 {
+		t.Fatalf("second reply = %s tag=%d, want Rflush tag=11", MessageName(msgType), tag)
+	}
+}
+
+func setupOpenTest(t *testing.T, files ...File) *testConn {
+	root := NewStaticDir("root")
+	for _, f := range files {
+		root.AddChild(f)
+	}
+	c := newTestConn(t, NewServer(root))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	return c
+}
+
+func TestServer_ReadSnapshot(t *testing.T) {
+	version := "first version"
+	c := setupOpenTest(t, NewDynamicFile("gen", func() []byte { return []byte(version) }))
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"gen"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OREAD}, Ropen)
+
+	payload := c.rpc(1, &TreadMsg{Fid: 1, Count: 6}, Rread)
+	version = "second version, which is longer"
+	payload2 := c.rpc(1, &TreadMsg{Fid: 1, Offset: 6, Count: 100}, Rread)
+	if got := string(payload[4:]) + string(payload2[4:]); got != "first version" {
+		t.Errorf("reads across a change = %q, want %q", got, "first version")
+	}
+
+	// A new open sees the new content.
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"gen"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 2, Mode: OREAD}, Ropen)
+	payload = c.rpc(1, &TreadMsg{Fid: 2, Count: 100}, Rread)
+	if got := string(payload[4:]); got != version {
+		t.Errorf("read after reopen = %q, want %q", got, version)
+	}
+}
+
+func TestServer_ReadUnopenedFid(t *testing.T) {
+	c := setupOpenTest(t, NewStaticFile("file", []byte("hello")))
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"file"}}, Rwalk)
+
+	payload := c.rpc(1, &TreadMsg{Fid: 1, Count: 100}, Rerror)
+	if ename, _ := DecodeString(payload); ename != ErrNotOpen.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, ErrNotOpen.Error())
+	}
+
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OREAD}, Ropen)
+	payload = c.rpc(1, &TopenMsg{Fid: 1, Mode: OREAD}, Rerror)
+	if ename, _ := DecodeString(payload); ename != ErrAlreadyOpen.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, ErrAlreadyOpen.Error())
+	}
+}
+
+func TestServer_DirReadWholeEntries(t *testing.T) {
+	c := setupOpenTest(t,
+		NewStaticFile("a", nil),
+		NewStaticFile("bb", nil),
+		NewStaticFile("ccc", nil),
+	)
+	c.rpc(1, &TopenMsg{Fid: 0, Mode: OREAD}, Ropen)
+
+	// Room for one entry and a bit: each Rread must hold whole entries.
+	var names []string
+	var offset uint64
+	for {
+		payload := c.rpc(1, &TreadMsg{Fid: 0, Offset: offset, Count: 80}, Rread)
+		data := payload[4:]
+		if len(data) == 0 {
+			break
+		}
+		for len(data) > 0 {
+			stat, n := DecodeStat(data)
+			if n == 0 {
+				t.Fatalf("partial stat entry in Rread: %x", data)
+			}
+			names = append(names, stat.Name)
+			data = data[n:]
+		}
+		offset += uint64(len(payload) - 4)
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "bb" || names[2] != "ccc" {
+		t.Errorf("entries = %q, want [a bb ccc]", names)
+	}
+
+	payload := c.rpc(1, &TreadMsg{Fid: 0, Offset: 1, Count: 100}, Rerror)
+	if ename, _ := DecodeString(payload); ename != ErrBadOffset.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, ErrBadOffset.Error())
+	}
+}
+
+func TestServer_NegotiateLargeMsize(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 40*1024)
+	root := NewStaticDir("root")
+	root.AddChild(NewStaticFile("answer", big))
+
+	srv := NewServer(root)
+	srv.SetMaxMsize(1 << 20)
+	c := newTestConn(t, srv)
+
+	payload := c.rpc(NoTag, &TversionMsg{Msize: 512 * 1024, Version: Version}, Rversion)
+	if msize := binary.LittleEndian.Uint32(payload[0:4]); msize != 512*1024 {
+		t.Fatalf("negotiated msize = %d, want %d", msize, 512*1024)
+	}
+	c.enc.SetMsize(512 * 1024)
+	c.dec.SetMsize(512 * 1024)
+
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"answer"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OREAD}, Ropen)
+	payload = c.rpc(1, &TreadMsg{Fid: 1, Count: 64 * 1024}, Rread)
+	if !bytes.Equal(payload[4:], big) {
+		t.Errorf("Rread returned %d bytes, want all %d in one message", len(payload)-4, len(big))
+	}
+}
+
+func TestServer_MsizeClamped(t *testing.T) {
+	c := newTestConn(t, NewServer(NewStaticDir("root")))
+
+	payload := c.rpc(NoTag, &TversionMsg{Msize: 512 * 1024, Version: Version}, Rversion)
+	if msize := binary.LittleEndian.Uint32(payload[0:4]); msize != MaxMessageSize {
+		t.Errorf("negotiated msize = %d, want default maximum %d", msize, MaxMessageSize)
+	}
+
+	c.rpc(NoTag, &TversionMsg{Msize: 16, Version: Version}, Rerror)
+}
+
+// mkdirDir is a directory in which clients may create subdirectories
+type mkdirDir struct {
+	*StaticDir
+}
+
+func (d *mkdirDir) Create(name string, perm uint32) (File, error) {
+	if perm&DMDIR == 0 {
+		return nil, ErrPermission
+	}
+	if _, err := d.Lookup(name); err == nil {
+		return nil, ErrExists
+	}
+	sub := NewStaticDir(name)
+	d.AddChild(sub)
+	return sub, nil
+}
+
+func TestServer_Create(t *testing.T) {
+	c := newTestConn(t, NewServer(&mkdirDir{NewStaticDir("root")}))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1}, Rwalk)
+
+	payload := c.rpc(1, &TcreateMsg{Fid: 1, Name: "sub", Perm: DMDIR | 0755, Mode: OREAD}, Rcreate)
+	qid, _ := DecodeQid(payload)
+	if qid.Type != QTDIR {
+		t.Errorf("Rcreate qid type = %#x, want QTDIR", qid.Type)
+	}
+
+	// The fid now refers to the new directory, already open.
+	payload = c.rpc(1, &TstatMsg{Fid: 1}, Rstat)
+	if stat, _ := DecodeStat(payload[2:]); stat.Name != "sub" {
+		t.Errorf("stat of created fid = %q, want sub", stat.Name)
+	}
+	c.rpc(1, &TreadMsg{Fid: 1, Count: 100}, Rread)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"sub"}}, Rwalk)
+
+	tests := []struct {
+		name string
+		msg  *TcreateMsg
+		want string
+	}{
+		{"exists", &TcreateMsg{Fid: 0, Name: "sub", Perm: DMDIR | 0755}, ErrExists.Error()},
+		{"plain file", &TcreateMsg{Fid: 0, Name: "file", Perm: 0644}, ErrPermission.Error()},
+		{"dot dot", &TcreateMsg{Fid: 0, Name: "..", Perm: DMDIR | 0755}, ErrBadName.Error()},
+		{"slash", &TcreateMsg{Fid: 0, Name: "a/b", Perm: DMDIR | 0755}, ErrBadName.Error()},
+		{"static dir", &TcreateMsg{Fid: 2, Name: "deeper", Perm: DMDIR | 0755}, ErrPermission.Error()},
+		{"open fid", &TcreateMsg{Fid: 1, Name: "other", Perm: DMDIR | 0755}, ErrAlreadyOpen.Error()},
+	}
+	for _, tt := range tests {
+		payload := c.rpc(1, tt.msg, Rerror)
+		if ename, _ := DecodeString(payload); ename != tt.want {
+			t.Errorf("%s: Rerror = %q, want %q", tt.name, ename, tt.want)
+		}
+	}
+}
+
+// removableFile records whether it has been removed
+type removableFile struct {
+	*StaticFile
+	removed bool
+}
+
+func (f *removableFile) Remove() error {
+	f.removed = true
+	return nil
+}
+
+func TestServer_Remove(t *testing.T) {
+	f := &removableFile{StaticFile: NewStaticFile("scratch", nil)}
+	c := setupOpenTest(t, f, NewStaticFile("fixed", nil))
+
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"scratch"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OREAD}, Ropen)
+	c.rpc(1, &TremoveMsg{Fid: 1}, Rremove)
+	if !f.removed {
+		t.Error("Remove was not called")
+	}
+
+	// Files without a Remove method cannot be removed, but the fid is
+	// clunked all the same.
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"fixed"}}, Rwalk)
+	payload := c.rpc(1, &TremoveMsg{Fid: 2}, Rerror)
+	if ename, _ := DecodeString(payload); ename != ErrPermission.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, ErrPermission.Error())
+	}
+	for _, fid := range []uint32{1, 2} {
+		payload = c.rpc(1, &TclunkMsg{Fid: fid}, Rerror)
+		if ename, _ := DecodeString(payload); ename != ErrBadFid.Error() {
+			t.Errorf("clunk of removed fid %d: Rerror = %q, want %q", fid, ename, ErrBadFid.Error())
+		}
+	}
+}
+
+// memFile is a writable file that can be truncated and renamed
+type memFile struct {
+	*BaseFile
+	content string
+}
+
+func newMemFile(name, content string) *memFile {
+	return &memFile{BaseFile: NewBaseFile(name, 0666), content: content}
+}
+
+func (f *memFile) Stat() Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content))
+	return s
+}
+
+func (f *memFile) Read(p []byte, offset int64) (int, error) {
+	if offset >= int64(len(f.content)) {
+		return 0, io.EOF
+	}
+	return copy(p, f.content[offset:]), nil
+}
+
+func (f *memFile) Write(p []byte, offset int64) (int, error) {
+	f.content = f.content[:min(int(offset), len(f.content))] + string(p)
+	return len(p), nil
+}
+
+func (f *memFile) Truncate(length uint64) error {
+	if length > uint64(len(f.content)) {
+		return ErrPermission
+	}
+	f.content = f.content[:length]
+	return nil
+}
+
+func (f *memFile) Rename(name string) error {
+	f.Name_ = name
+	return nil
+}
+
+func TestServer_Wstat(t *testing.T) {
+	f := newMemFile("notes", "some notes")
+	c := setupOpenTest(t, f, NewStaticFile("fixed", []byte("hello")))
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"notes"}}, Rwalk)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"fixed"}}, Rwalk)
+
+	// A stat with nothing to change is a sync and always succeeds.
+	c.rpc(1, &TwstatMsg{Fid: 2, Stat: NullStat()}, Rwstat)
+
+	st := NullStat()
+	st.Length = 0
+	c.rpc(1, &TwstatMsg{Fid: 1, Stat: st}, Rwstat)
+	if f.content != "" {
+		t.Errorf("content after truncate = %q, want empty", f.content)
+	}
+
+	// Times are accepted, and the current values are not changes.
+	st = NullStat()
+	st.Mtime = 12345
+	st.Atime = 12345
+	st.Mode = 0666
+	st.Name = "notes"
+	c.rpc(1, &TwstatMsg{Fid: 1, Stat: st}, Rwstat)
+
+	st = NullStat()
+	st.Name = "renamed"
+	c.rpc(1, &TwstatMsg{Fid: 1, Stat: st}, Rwstat)
+	if f.Name_ != "renamed" {
+		t.Errorf("name after rename = %q, want renamed", f.Name_)
+	}
+
+	tests := []struct {
+		name   string
+		fid    uint32
+		modify func(*Stat)
+		want   string
+	}{
+		{"chmod", 1, func(s *Stat) { s.Mode = 0600 }, ErrPermission.Error()},
+		{"chown", 1, func(s *Stat) { s.Uid = "glenda" }, ErrPermission.Error()},
+		{"qid", 1, func(s *Stat) { s.Qid.Path = 99 }, ErrPermission.Error()},
+		{"bad name", 1, func(s *Stat) { s.Name = "a/b" }, ErrBadName.Error()},
+		{"truncate static", 2, func(s *Stat) { s.Length = 0 }, ErrPermission.Error()},
+		{"rename static", 2, func(s *Stat) { s.Name = "other" }, ErrPermission.Error()},
+		// Nothing changes unless everything can.
+		{"truncate and chmod", 1, func(s *Stat) { s.Length = 0; s.Mode = 0 }, ErrPermission.Error()},
+	}
+	f.content = "kept"
+	for _, tt := range tests {
+		st := NullStat()
+		tt.modify(&st)
+		payload := c.rpc(1, &TwstatMsg{Fid: tt.fid, Stat: st}, Rerror)
+		if ename, _ := DecodeString(payload); ename != tt.want {
+			t.Errorf("%s: Rerror = %q, want %q", tt.name, ename, tt.want)
+		}
+	}
+	if f.content != "kept" {
+		t.Errorf("content after failed wstat = %q, want kept", f.content)
+	}
+}
+
+func TestServer_OpenTruncate(t *testing.T) {
+	f := newMemFile("notes", "some notes")
+	c := setupOpenTest(t, f, NewStaticFile("fixed", []byte("hello")))
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"notes"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE | OTRUNC}, Ropen)
+	if f.content != "" {
+		t.Errorf("content after OTRUNC open = %q, want empty", f.content)
+	}
+
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"fixed"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 2, Mode: OWRITE | OTRUNC}, Rerror)
+}
+
+// closeFile reports the context of each close
+type closeFile struct {
+	*BaseFile
+	closed chan error
+}
+
+func (f *closeFile) CloseContext(ctx context.Context) error {
+	f.closed <- ctx.Err()
+	return nil
+}
+
+func TestServer_MaxFids(t *testing.T) {
+	srv := NewServer(NewStaticDir("root"))
+	srv.SetMaxFids(2)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1}, Rwalk)
+
+	payload := c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2}, Rerror)
+	if ename, _ := DecodeString(payload); ename != ErrTooManyFids.Error() {
+		t.Errorf("Rerror = %q, want %q", ename, ErrTooManyFids.Error())
+	}
+
+	// Clunking frees a slot.
+	c.rpc(1, &TclunkMsg{Fid: 1}, Rclunk)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2}, Rwalk)
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(NewStaticDir("root"))
+	srv.SetMaxConns(1)
+	go srv.Serve(context.Background(), l)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	first, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	c := &testConn{t: t, enc: NewEncoder(first), dec: NewDecoder(first)}
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+
+	second, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read on connection over the limit = %v, want EOF", err)
+	}
+
+	// The first connection is unaffected.
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	slow := newBlockingFile("slow")
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+
+	srv := NewServer(root)
+	srv.SetIdleTimeout(50 * time.Millisecond)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+
+	// A request in flight keeps the connection open past the timeout.
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	waitStarted(t, slow)
+	time.Sleep(200 * time.Millisecond)
+	close(slow.release)
+	if msgType, tag, _ := c.recv(); msgType != Rwrite || tag != 10 {
+		t.Fatalf("reply = %s tag=%d, want Rwrite tag=10", MessageName(msgType), tag)
+	}
+
+	// Once idle, it is closed.
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := c.dec.ReadMessage()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("got a message on an idle connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection still open")
+	}
+}
+
+func TestServer_ShutdownDrains(t *testing.T) {
+	slow := newBlockingFile("slow")
+	closer := &closeFile{BaseFile: NewBaseFile("closer", 0666), closed: make(chan error, 1)}
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+	root.AddChild(closer)
+	srv := NewServer(root)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"closer"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 2, Mode: OWRITE}, Ropen)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	waitStarted(t, slow)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(slow.release)
+	if msgType, tag, _ := c.recv(); msgType != Rwrite || tag != 10 {
+		t.Fatalf("reply = %s tag=%d, want Rwrite tag=10", MessageName(msgType), tag)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+
+	// Open fids were clunked without committing anything.
+	select {
+	case err := <-closer.closed:
+		if err == nil {
+			t.Error("handle closed with a live context")
+		}
+	default:
+		t.Error("open fid not clunked")
+	}
+}
+
+func TestServer_ShutdownCancels(t *testing.T) {
+	slow := &cancellableFile{BaseFile: NewBaseFile("slow", 0666), started: make(chan struct{}, 1)}
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+
+	srv := NewServer(root)
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write never started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- srv.Shutdown(ctx) }()
+
+	if msgType, tag, _ := c.recv(); msgType != Rerror || tag != 10 {
+		t.Fatalf("reply = %s tag=%d, want Rerror tag=10", MessageName(msgType), tag)
+	}
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Shutdown = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	if err := srv.Serve(context.Background(), nil); err != ErrServerClosed {
+		t.Errorf("Serve after Shutdown = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+// connFile calls OnClose on the connection of each write
+type connFile struct {
+	*BaseFile
+	closed chan struct{}
+}
+
+func (f *connFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	ConnFromContext(ctx).OnClose(func() { close(f.closed) })
+	return len(p), nil
+}
+
+func TestServer_DisconnectCleansUp(t *testing.T) {
+	slow := &cancellableFile{BaseFile: NewBaseFile("slow", 0666), started: make(chan struct{}, 1)}
+	closer := &closeFile{BaseFile: NewBaseFile("closer", 0666), closed: make(chan error, 1)}
+	hook := &connFile{BaseFile: NewBaseFile("hook", 0666), closed: make(chan struct{})}
+	root := NewStaticDir("root")
+	root.AddChild(slow)
+	root.AddChild(closer)
+	root.AddChild(hook)
+
+	client, server := net.Pipe()
+	go NewServer(root).ServeConn(server)
+	c := &testConn{t: t, enc: NewEncoder(client), dec: NewDecoder(client)}
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	for i, name := range []string{"slow", "closer", "hook"} {
+		fid := uint32(i + 1)
+		c.rpc(1, &TwalkMsg{Fid: 0, Newfid: fid, Names: []string{name}}, Rwalk)
+		c.rpc(1, &TopenMsg{Fid: fid, Mode: OWRITE}, Ropen)
+	}
+	c.rpc(1, &TwriteMsg{Fid: 3, Data: []byte("x")}, Rwrite)
+
+	c.send(10, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	select {
+	case <-slow.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write never started")
+	}
+	client.Close()
+
+	// The write in flight is cancelled, so the fids can be clunked.
+	select {
+	case err := <-closer.closed:
+		if err == nil {
+			t.Error("handle closed with a live context")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("open fid not clunked")
+	}
+	select {
+	case <-hook.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnClose function not called")
+	}
+}
+
+func TestServer_Namespace(t *testing.T) {
+	a := NewStaticDir("a")
+	b := NewStaticDir("b")
+	srv := NewServer(NewStaticDir("default"))
+	srv.SetNamespace(NamespaceFunc(func(uname, aname string) (Dir, error) {
+		switch {
+		case aname == "a":
+			return a, nil
+		case aname == "b" && uname == "glenda":
+			return b, nil
+		}
+		return nil, ErrPermission
+	}))
+
+	c := newTestConn(t, srv)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	for fid, want := range map[uint32]Dir{0: a, 1: b} {
+		aname := want.Stat().Name
+		payload := c.rpc(1, &TattachMsg{Fid: fid, Afid: NoFid, Uname: "glenda", Aname: aname}, Rattach)
+		if r, _ := DecodeRattach(payload); r.Qid != want.Stat().Qid {
+			t.Errorf("attach %q qid = %+v, want %+v", aname, r.Qid, want.Stat().Qid)
+		}
+	}
+	c.rpc(1, &TattachMsg{Fid: 2, Afid: NoFid, Uname: "bob", Aname: "b"}, Rerror)
+	c.rpc(1, &TattachMsg{Fid: 2, Afid: NoFid, Uname: "glenda", Aname: "c"}, Rerror)
+}
+
+func TestReadOnly(t *testing.T) {
+	sub := NewStaticDir("sub")
+	sub.AddChild(newMemFile("notes", "hello"))
+	root := NewStaticDir("root")
+	root.AddChild(sub)
+
+	c := newTestConn(t, NewServer(ReadOnly(root)))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid}, Rattach)
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"sub", "notes"}}, Rwalk)
+
+	payload := c.rpc(1, &TstatMsg{Fid: 1}, Rstat)
+	if r, _ := DecodeRstat(payload); r.Stat.Mode&0222 != 0 {
+		t.Errorf("mode = %o, want no write bits", r.Stat.Mode)
+	}
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OWRITE}, Rerror)
+	c.rpc(1, &TopenMsg{Fid: 1, Mode: OREAD}, Ropen)
+	c.rpc(1, &TreadMsg{Fid: 1, Count: 100}, Rread)
+	c.rpc(1, &TwriteMsg{Fid: 1, Data: []byte("x")}, Rerror)
+	c.rpc(1, &TremoveMsg{Fid: 1}, Rerror)
+
+	c.rpc(1, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"sub"}}, Rwalk)
+	c.rpc(1, &TcreateMsg{Fid: 2, Name: "new", Perm: 0644, Mode: OWRITE}, Rerror)
+	if _, err := sub.Lookup("new"); err == nil {
+		t.Error("file created in a read-only tree")
+	}
+	if f := sub.Children()[0].(*memFile); f.content != "hello" {
+		t.Errorf("notes = %q, want it unchanged", f.content)
+	}
+}
+
+func TestServer_Trace(t *testing.T) {
+	var buf bytes.Buffer
+	root := NewStaticDir("root")
+	root.AddChild(NewStaticFile("hello", []byte("hello")))
+	srv := NewServer(root)
+	srv.SetTrace(&buf)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		srv.ServeConn(server)
+		close(done)
+	}()
+	c := &testConn{t: t, enc: NewEncoder(client), dec: NewDecoder(client)}
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "glenda"}, Rattach)
+	c.rpc(2, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"missing"}}, Rerror)
+	client.Close()
+	<-done
+
+	var events []TraceEvent
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var ev TraceEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatalf("bad trace line %q: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Event+ev.Dir+" "+ev.Type)
+	}
+	want := []string{"connect ", "in Tversion", "out Rversion", "in Tattach", "out Rattach",
+		"in Twalk", "out Rerror", "disconnect "}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+
+	walk := events[5]
+	var msg TwalkMsg
+	if err := json.Unmarshal(walk.Msg, &msg); err != nil || msg.Newfid != 1 || len(msg.Names) != 1 || msg.Names[0] != "missing" {
+		t.Errorf("Twalk msg = %s, %v", walk.Msg, err)
+	}
+	if walk.Tag != 2 || walk.Conn != events[0].Conn {
+		t.Errorf("Twalk tag %d conn %d, want tag 2 conn %d", walk.Tag, walk.Conn, events[0].Conn)
+	}
+	if m, err := DecodeTwalk(walk.Raw); err != nil || m.Names[0] != "missing" {
+		t.Errorf("raw Twalk decodes to %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,205 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TraceEvent is one line of a protocol trace: a message the server read
+// or sent, or a client connecting or disconnecting.
+type TraceEvent struct {
+	Time   time.Time `json:"time"`
+	Conn   uint64    `json:"conn"`             // numbers connections in the order they were accepted
+	Event  string    `json:"event,omitempty"`  // "connect" or "disconnect"; empty for messages
+	Remote string    `json:"remote,omitempty"` // client address, on connect
+
+	// The message, for message events. Msg is the decoded message for
+	// people to read, if the server can decode it; Raw is the payload
+	// exactly as it crossed the wire, for replay.
+	Dir  string          `json:"dir,omitempty"` // "in" from the client, "out" to it
+	Type string          `json:"type,omitempty"`
+	Tag  uint16          `json:"tag"`
+	Msg  json.RawMessage `json:"msg,omitempty"`
+	Raw  []byte          `json:"raw,omitempty"`
+}
+
+// tracer writes trace events as JSON lines
+type tracer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// SetTrace writes every message the server reads and sends, decoded, to w
+// as JSON lines, one TraceEvent per line. Traces contain everything the
+// clients send, prompts and credentials included. A nil w, the default,
+// turns tracing off.
+func (s *Server) SetTrace(w io.Writer) {
+	if w == nil {
+		s.trace = nil
+		return
+	}
+	s.trace = &tracer{enc: json.NewEncoder(w)}
+}
+
+func (t *tracer) write(ev *TraceEvent) {
+	ev.Time = time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enc.Encode(ev)
+}
+
+// traceConn records a client connecting or disconnecting.
+func (s *Server) traceConn(state *clientState, event string) {
+	if s.trace == nil {
+		return
+	}
+	ev := &TraceEvent{Conn: state.id, Event: event}
+	if event == "connect" && state.conn.RemoteAddr() != nil {
+		ev.Remote = state.conn.RemoteAddr().String()
+	}
+	s.trace.write(ev)
+}
+
+// traceMessage records a message read from or sent to a client.
+func (s *Server) traceMessage(state *clientState, dir string, msgType uint8, tag uint16, payload []byte) {
+	if s.trace == nil {
+		return
+	}
+	ev := &TraceEvent{
+		Conn: state.id,
+		Dir:  dir,
+		Type: MessageName(msgType),
+		Tag:  tag,
+		Raw:  payload,
+	}
+	if msg, err := DecodeMessage(state.getDialect().version(), msgType, payload); err == nil {
+		ev.Msg, _ = json.Marshal(readable(msg))
+	}
+	s.trace.write(ev)
+}
+
+// readable shows the data of reads and writes as text rather than base64,
+// which is what it nearly always is here. Raw keeps the exact bytes.
+func readable(msg Message) any {
+	switch m := msg.(type) {
+	case *TwriteMsg:
+		return struct {
+			Fid    uint32
+			Offset uint64
+			Data   string
+		}{m.Fid, m.Offset, string(m.Data)}
+	case *RreadMsg:
+		return struct{ Data string }{string(m.Data)}
+	}
+	return msg
+}
+
+// version returns the version string that selects the dialect.
+func (d dialect) version() string {
+	switch d {
+	case dialect9P2000U:
+		return VersionU
+	case dialect9P2000L:
+		return VersionL
+	}
+	return Version
+}
+
+// DecodeMessage decodes a message payload of the given type, as sent on a
+// connection that negotiated version. It covers every T-message the server
+// handles and the R-messages of 9P2000 and 9P2000.u.
+func DecodeMessage(version string, msgType uint8, payload []byte) (Message, error) {
+	dotu := version == VersionU
+	switch msgType {
+	case Tversion:
+		return DecodeTversion(payload)
+	case Rversion:
+		return DecodeRversion(payload)
+	case Tauth:
+		return DecodeTauth(payload)
+	case Rauth:
+		return DecodeRauth(payload)
+	case Tattach:
+		return DecodeTattach(payload)
+	case Rattach:
+		return DecodeRattach(payload)
+	case Rerror:
+		return DecodeRerror(payload)
+	case Rlerror:
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("Rlerror too short")
+		}
+		return &RlerrorMsg{Ecode: Errno(binary.LittleEndian.Uint32(payload))}, nil
+	case Tflush:
+		return DecodeTflush(payload)
+	case Rflush:
+		return &RflushMsg{}, nil
+	case Twalk:
+		return DecodeTwalk(payload)
+	case Rwalk:
+		return DecodeRwalk(payload)
+	case Topen:
+		return DecodeTopen(payload)
+	case Ropen:
+		return DecodeRopen(payload)
+	case Tcreate:
+		return DecodeTcreate(payload)
+	case Rcreate:
+		return DecodeRcreate(payload)
+	case Tread:
+		return DecodeTread(payload)
+	case Rread:
+		return DecodeRread(payload)
+	case Twrite:
+		return DecodeTwrite(payload)
+	case Rwrite:
+		return DecodeRwrite(payload)
+	case Tclunk:
+		return DecodeTclunk(payload)
+	case Rclunk:
+		return &RclunkMsg{}, nil
+	case Tremove:
+		return DecodeTremove(payload)
+	case Rremove:
+		return &RremoveMsg{}, nil
+	case Tstat:
+		return DecodeTstat(payload)
+	case Rstat:
+		if dotu {
+			return DecodeRstatU(payload)
+		}
+		return DecodeRstat(payload)
+	case Twstat:
+		if dotu {
+			return DecodeTwstatU(payload)
+		}
+		return DecodeTwstat(payload)
+	case Rwstat:
+		return &RwstatMsg{}, nil
+	case Tstatfs:
+		return DecodeTstatfs(payload)
+	case Tlopen:
+		return DecodeTlopen(payload)
+	case Tlcreate:
+		return DecodeTlcreate(payload)
+	case Tmkdir:
+		return DecodeTmkdir(payload)
+	case Tunlinkat:
+		return DecodeTunlinkat(payload)
+	case Trenameat:
+		return DecodeTrenameat(payload)
+	case Tgetattr:
+		return DecodeTgetattr(payload)
+	case Tsetattr:
+		return DecodeTsetattr(payload)
+	case Treaddir:
+		return DecodeTreaddir(payload)
+	case Tfsync:
+		return DecodeTfsync(payload)
+	}
+	return nil, fmt.Errorf("cannot decode %s", MessageName(msgType))
+}
@@ -0,0 +1,234 @@
+// Package llm9p is a Go client for the llm9p file server. It hides the
+// filesystem schema behind sessions with methods:
+//
+//	c, err := llm9p.Dial(ctx, "tcp", "localhost:5640")
+//	s, err := c.NewSession(ctx)
+//	err = s.SetModel(ctx, "claude-sonnet-4-20250514")
+//	answer, err := s.Ask(ctx, "What is 9P?")
+//
+// For files the methods don't cover, use the 9P client in package client
+// directly, starting from Client.Root.
+package llm9p
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/client"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// Client is a connection to an llm9p server.
+type Client struct {
+	conn *client.Conn
+	root *client.Fid
+}
+
+// An Option changes how New and Dial attach.
+type Option func(*options)
+
+type options struct {
+	// auth carries out the exchange on an auth fid, if the server
+	// requires authentication
+	auth func(ctx context.Context, afid *client.Fid, uname string) error
+}
+
+// WithSecret authenticates with the shared secret of a server started
+// with -auth-secret, by answering its challenge. The secret is not sent.
+func WithSecret(secret []byte) Option {
+	return func(o *options) {
+		o.auth = func(ctx context.Context, afid *client.Fid, uname string) error {
+			challenge, err := afid.ReadAll(ctx)
+			if err != nil {
+				return err
+			}
+			resp := protocol.SecretResponse(secret, uname, strings.TrimSpace(string(challenge)))
+			_, err = afid.WriteAt(ctx, []byte(resp), 0)
+			return err
+		}
+	}
+}
+
+// WithPassword authenticates with the user's password, for a server
+// started with -auth-passwd. The password is sent as it is, so use it
+// over TLS or a trusted network.
+func WithPassword(password string) Option {
+	return func(o *options) {
+		o.auth = func(ctx context.Context, afid *client.Fid, uname string) error {
+			_, err := afid.WriteAt(ctx, []byte(password), 0)
+			return err
+		}
+	}
+}
+
+// Dial connects to the llm9p server at address on the named network and
+// attaches as the current user.
+func Dial(ctx context.Context, network, address string, opts ...Option) (*Client, error) {
+	conn, err := client.Dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	uname := "none"
+	if u, err := user.Current(); err == nil {
+		uname = u.Username
+	}
+	c, err := New(ctx, conn, uname, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// New attaches to the server on conn as uname, authenticating first if
+// an option such as WithSecret says how. Closing the Client closes conn.
+func New(ctx context.Context, conn *client.Conn, uname string, opts ...Option) (*Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.auth == nil {
+		root, err := conn.Attach(ctx, uname, "")
+		if err != nil {
+			return nil, err
+		}
+		return &Client{conn: conn, root: root}, nil
+	}
+
+	afid, err := conn.Auth(ctx, uname, "")
+	if err != nil {
+		return nil, err
+	}
+	// The afid is only needed until the attach.
+	defer afid.Clunk(context.WithoutCancel(ctx))
+	if err := o.auth(ctx, afid, uname); err != nil {
+		return nil, err
+	}
+	root, err := conn.AttachAuth(ctx, afid, uname, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, root: root}, nil
+}
+
+// Root returns the Fid of the server's root directory, for use with
+// package client. It must not be clunked.
+func (c *Client) Root() *client.Fid {
+	return c.root
+}
+
+// Close closes the connection. Sessions live on in the server until they
+// are closed.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// NewSession creates a numbered session with the server's default
+// settings.
+func (c *Client) NewSession(ctx context.Context) (*Session, error) {
+	data, err := client.ReadFile(ctx, c.root, "new")
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(string(data))
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, fmt.Errorf("llm9p: bad session ID %q from new", id)
+	}
+	return c.Session(id), nil
+}
+
+// CreateSession creates a session called name, as mkdir would.
+func (c *Client) CreateSession(ctx context.Context, name string) (*Session, error) {
+	f, err := c.root.Walk(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Create(ctx, name, client.DMDIR|0755, client.OREAD); err != nil {
+		f.Clunk(context.WithoutCancel(ctx))
+		return nil, err
+	}
+	if err := f.Clunk(ctx); err != nil {
+		return nil, err
+	}
+	return c.Session(name), nil
+}
+
+// Session returns the existing session with the given name, which for a
+// numbered session is its ID. Nothing is checked until the session is
+// used.
+func (c *Client) Session(name string) *Session {
+	return &Session{c: c, name: name}
+}
+
+// Session is one conversation on the server, with its own history and
+// settings.
+type Session struct {
+	c    *Client
+	name string
+}
+
+// Name returns the session's directory name.
+func (s *Session) Name() string {
+	return s.name
+}
+
+// Ask sends prompt and returns the response. The prompt is added to the
+// session's history along with the response.
+//
+// The server reports a failed request as a response beginning "Error:".
+// Asks on one session run one at a time, so concurrent callers each get
+// the response to their own prompt only if nobody else asks in between.
+func (s *Session) Ask(ctx context.Context, prompt string) (string, error) {
+	if err := s.Set(ctx, "ask", prompt); err != nil {
+		return "", err
+	}
+	return s.Get(ctx, "ask")
+}
+
+// Model returns the session's model.
+func (s *Session) Model(ctx context.Context) (string, error) {
+	return s.Get(ctx, "model")
+}
+
+// SetModel sets the model used for the session's future requests.
+func (s *Session) SetModel(ctx context.Context, model string) error {
+	return s.Set(ctx, "model", model)
+}
+
+// SetSystem sets the session's system prompt.
+func (s *Session) SetSystem(ctx context.Context, prompt string) error {
+	return s.Set(ctx, "system", prompt)
+}
+
+// SetTemperature sets the session's sampling temperature.
+func (s *Session) SetTemperature(ctx context.Context, temp float64) error {
+	return s.Set(ctx, "temperature", strconv.FormatFloat(temp, 'g', -1, 64))
+}
+
+// Reset clears the session's conversation history.
+func (s *Session) Reset(ctx context.Context) error {
+	return s.Set(ctx, "ctl", "reset")
+}
+
+// Close removes the session from the server.
+func (s *Session) Close(ctx context.Context) error {
+	return s.Set(ctx, "ctl", "close")
+}
+
+// Get returns the contents of one of the session's files, such as
+// "thinking" or "context", without the trailing newline.
+func (s *Session) Get(ctx context.Context, file string) (string, error) {
+	data, err := client.ReadFile(ctx, s.c.root, s.name+"/"+file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// Set replaces the contents of one of the session's files.
+func (s *Session) Set(ctx context.Context, file, value string) error {
+	return client.WriteFile(ctx, s.c.root, s.name+"/"+file, []byte(value))
+}
@@ -0,0 +1,183 @@
+package llm9p
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/client"
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/llmfs"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// echoBackend answers every prompt with the model and prompt it was
+// given. Only AskWithRequest is used by sessions.
+type echoBackend struct {
+	llm.Backend
+}
+
+func (echoBackend) AskWithRequest(ctx context.Context, req llm.AskRequest) (string, int, error) {
+	return fmt.Sprintf("%s: %s", req.Model, req.Prompt), len(req.Prompt), nil
+}
+
+func setup(t *testing.T) (*Client, *llm.SessionManager) {
+	t.Helper()
+	sm := llm.NewSessionManager(echoBackend{})
+	cc, sc := net.Pipe()
+	go protocol.NewServer(llmfs.NewRoot(sm)).ServeConn(sc)
+
+	ctx := context.Background()
+	conn, err := client.NewConn(ctx, cc)
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	c, err := New(ctx, conn, "glenda")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, sm
+}
+
+func TestSession_Ask(t *testing.T) {
+	ctx := context.Background()
+	c, sm := setup(t)
+
+	s, err := c.NewSession(ctx)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	if err := s.SetModel(ctx, "test-model"); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	if model, err := s.Model(ctx); err != nil || model != "test-model" {
+		t.Errorf("Model = %q, %v; want test-model", model, err)
+	}
+
+	answer, err := s.Ask(ctx, "hello")
+	if err != nil || answer != "test-model: hello" {
+		t.Errorf("Ask = %q, %v; want %q", answer, err, "test-model: hello")
+	}
+	if n := len(sm.Get(0).Messages()); n != 2 {
+		t.Errorf("history has %d messages, want 2", n)
+	}
+
+	if err := s.Reset(ctx); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if n := len(sm.Get(0).Messages()); n != 0 {
+		t.Errorf("history after reset has %d messages, want 0", n)
+	}
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := s.Model(ctx); err != client.ErrNotFound {
+		t.Errorf("Model after Close error = %v, want %v", err, client.ErrNotFound)
+	}
+}
+
+func TestCreateSession(t *testing.T) {
+	ctx := context.Background()
+	c, sm := setup(t)
+
+	s, err := c.CreateSession(ctx, "reviewbot")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if _, ok := sm.Lookup("reviewbot"); !ok {
+		t.Error("session reviewbot not created")
+	}
+	if err := s.SetTemperature(ctx, 0.25); err != nil {
+		t.Fatalf("SetTemperature: %v", err)
+	}
+	if temp, _ := s.Get(ctx, "temperature"); temp != "0.25" {
+		t.Errorf("temperature = %q, want 0.25", temp)
+	}
+
+	if _, err := c.CreateSession(ctx, "reviewbot"); err != client.ErrExists {
+		t.Errorf("second CreateSession error = %v, want %v", err, client.ErrExists)
+	}
+	if _, err := c.Session("nobody").Ask(ctx, "hi"); err != client.ErrNotFound {
+		t.Errorf("Ask on missing session error = %v, want %v", err, client.ErrNotFound)
+	}
+}
+
+func TestSession_Ephemeral(t *testing.T) {
+	ctx := context.Background()
+	c, sm := setup(t)
+
+	kept, err := c.CreateSession(ctx, "kept")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	temp, err := c.CreateSession(ctx, "temp")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := temp.Set(ctx, "ctl", "ephemeral"); err != nil {
+		t.Fatalf("ctl ephemeral: %v", err)
+	}
+	if _, err := kept.Model(ctx); err != nil {
+		t.Fatalf("Model: %v", err)
+	}
+	c.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := sm.Lookup("temp"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ephemeral session still open after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := sm.Lookup("kept"); !ok {
+		t.Error("session kept was closed on disconnect")
+	}
+}
+
+func TestNew_Auth(t *testing.T) {
+	ctx := context.Background()
+	connect := func(auth protocol.Authenticator) *client.Conn {
+		t.Helper()
+		srv := protocol.NewServer(llmfs.NewRoot(llm.NewSessionManager(echoBackend{})))
+		srv.SetAuthenticator(auth)
+		cc, sc := net.Pipe()
+		go srv.ServeConn(sc)
+		conn, err := client.NewConn(ctx, cc)
+		if err != nil {
+			t.Fatalf("NewConn: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	for _, tt := range []struct {
+		name string
+		auth protocol.Authenticator
+		good Option
+		bad  Option
+	}{
+		{"secret", protocol.NewSecretAuth([]byte("s3cret")), WithSecret([]byte("s3cret")), WithSecret([]byte("guess"))},
+		{"password", protocol.NewPasswordAuth(map[string]string{"glenda": "bunny"}), WithPassword("bunny"), WithPassword("carrot")},
+	} {
+		if _, err := New(ctx, connect(tt.auth), "glenda"); err == nil {
+			t.Errorf("%s: New without authenticating succeeded", tt.name)
+		}
+		if _, err := New(ctx, connect(tt.auth), "glenda", tt.bad); err == nil {
+			t.Errorf("%s: New with the wrong credentials succeeded", tt.name)
+		}
+		c, err := New(ctx, connect(tt.auth), "glenda", tt.good)
+		if err != nil {
+			t.Fatalf("%s: New: %v", tt.name, err)
+		}
+		if _, err := c.NewSession(ctx); err != nil {
+			t.Errorf("%s: NewSession: %v", tt.name, err)
+		}
+	}
+}